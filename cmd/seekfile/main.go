@@ -1,19 +1,53 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"seekfile/internal/app"
+	"seekfile/internal/auth"
+	"seekfile/internal/certs"
 	"seekfile/internal/config"
+	"seekfile/internal/indexer"
 )
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "hash-password":
+			if err := hashPassword(); err != nil {
+				log.Fatalf("hash password: %v", err)
+			}
+			return
+		case "new-token":
+			if err := newToken(); err != nil {
+				log.Fatalf("new token: %v", err)
+			}
+			return
+		case "scan":
+			if err := scan(os.Args[2:]); err != nil {
+				log.Fatalf("scan: %v", err)
+			}
+			return
+		case "self-signed-cert":
+			if err := selfSignedCert(os.Args[2:]); err != nil {
+				log.Fatalf("self-signed certificate: %v", err)
+			}
+			return
+		}
+	}
+
 	cfg, err := config.FromFlags()
 	if err != nil {
 		log.Fatalf("parse config: %v", err)
@@ -32,3 +66,115 @@ func main() {
 		log.Fatalf("application error: %v", err)
 	}
 }
+
+// hashPassword reads a password from standard input and prints its bcrypt
+// hash for the "users" section of the auth configuration.
+func hashPassword() error {
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return fmt.Errorf("empty password")
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+// newToken prints a random API token and the hash to put in the "tokens"
+// section of the auth configuration.
+func newToken() error {
+	token, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("token:  %s\nsha256: %s\n", token, auth.HashToken(token))
+	return nil
+}
+
+// selfSignedCert writes a self-signed certificate and key for the "tls"
+// configuration section.
+func selfSignedCert(args []string) error {
+	flags := flag.NewFlagSet("self-signed-cert", flag.ExitOnError)
+	hosts := flags.String("hosts", "localhost,127.0.0.1,::1", "comma-separated host names and IP addresses")
+	certFile := flags.String("cert", "seekfile.crt", "output certificate file")
+	keyFile := flags.String("key", "seekfile.key", "output private key file")
+	days := flags.Int("days", 365, "validity in days")
+	flags.Parse(args)
+
+	var names []string
+	for _, host := range strings.Split(*hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			names = append(names, host)
+		}
+	}
+	certPEM, keyPEM, err := certs.GenerateSelfSigned(names, time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*certFile, certPEM, 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(*keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s and %s\n", *certFile, *keyFile)
+	return nil
+}
+
+// scan runs a one-off scan of every root, or of the roots and subtrees given
+// as arguments, and prints the outcome for each root.
+func scan(args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ExitOnError)
+	configPath := flags.String("config", "seekfile.config.json", "path to JSON configuration file")
+	modeName := flags.String("mode", "incremental", "scan mode: incremental or full")
+	flags.Parse(args)
+
+	mode, err := indexer.ParseScanMode(*modeName)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.FromFile(*configPath)
+	if err != nil {
+		return fmt.Errorf("parse config: %w", err)
+	}
+	application, err := app.New(cfg)
+	if err != nil {
+		return fmt.Errorf("initialize app: %w", err)
+	}
+	defer application.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	status, err := application.Scan(ctx, mode, flags.Args())
+	if err != nil {
+		return err
+	}
+	for _, root := range status.Roots {
+		if !root.StartedAt.Equal(status.StartedAt) {
+			continue
+		}
+		scope := root.Root
+		if len(root.Paths) > 0 {
+			scope = strings.Join(root.Paths, ", ")
+		}
+		if root.Error != "" {
+			fmt.Printf("%s: failed: %s\n", scope, root.Error)
+			continue
+		}
+		fmt.Printf("%s: %d files checked\n", scope, root.Processed)
+	}
+	fmt.Printf("%s scan finished in %s, %d files indexed\n", status.Mode,
+		status.FinishedAt.Sub(status.StartedAt).Round(time.Millisecond), status.KnownFiles)
+	if status.Error != "" {
+		return errors.New(status.Error)
+	}
+	return nil
+}
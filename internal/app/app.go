@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"time"
 
+	"seekfile/internal/auth"
 	"seekfile/internal/config"
 	"seekfile/internal/frontend"
 	"seekfile/internal/indexer"
+	"seekfile/internal/preview"
 	"seekfile/internal/server"
 	sqlitestore "seekfile/internal/storage/sqlite"
 )
@@ -27,18 +30,118 @@ func New(cfg config.Config) (*App, error) {
 		return nil, fmt.Errorf("open index store: %w", err)
 	}
 
-	idx, err := indexer.New(cfg.ScanPaths, store)
+	opts := make([]indexer.Option, 0, len(cfg.Roots)+4)
+	opts = append(opts,
+		indexer.WithContentHashing(cfg.HashContents),
+		indexer.WithMemoryIndex(cfg.MemoryIndex),
+		indexer.WithBatchSize(cfg.WriteBatchSize),
+	)
+	if cfg.ContentIndex.Enabled {
+		opts = append(opts, indexer.WithContentIndexing(indexer.ContentOptions{
+			MaxFileSize: cfg.ContentIndex.MaxFileSize,
+			Extensions:  cfg.ContentIndex.Extensions,
+		}))
+	}
+	if cfg.ChangeLog.Enabled {
+		opts = append(opts, indexer.WithChangeLog(indexer.ChangeLogOptions{
+			Retention:  time.Duration(cfg.ChangeLog.RetentionDays) * 24 * time.Hour,
+			MaxEntries: cfg.ChangeLog.MaxEntries,
+		}))
+	}
+	var previews *preview.Cache
+	if cfg.Preview.Enabled {
+		previews, err = preview.NewCache(preview.Options{
+			Dir:           cfg.Preview.CacheDir,
+			ThumbnailSize: cfg.Preview.ThumbnailSize,
+			TextLines:     cfg.Preview.TextLines,
+		})
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		// Drop cached thumbnails as soon as the indexer sees a file change.
+		opts = append(opts, indexer.WithChangeHandler(func(change indexer.Change) {
+			previews.Invalidate(change.Path)
+			if change.OldPath != "" {
+				previews.Invalidate(change.OldPath)
+			}
+		}))
+	}
+	for _, root := range cfg.Roots {
+		opts = append(opts, indexer.WithRootOptions(root.Path, indexer.RootOptions{
+			Exclude:             root.Exclude,
+			Include:             root.Include,
+			IgnoreFiles:         root.IgnoreFiles,
+			Concurrency:         root.Concurrency,
+			IncrementalSchedule: root.Schedule.Incremental,
+			FullSchedule:        root.Schedule.Full,
+			Unreadable:          indexer.UnreadablePolicy(root.OnUnreadable),
+		}))
+	}
+
+	idx, err := indexer.New(cfg.ScanPaths, store, opts...)
 	if err != nil {
 		store.Close()
 		return nil, fmt.Errorf("create indexer: %w", err)
 	}
 
+	serverOpts := []server.Option{
+		server.WithArchiveLimits(server.ArchiveLimits{
+			MaxFiles: cfg.Archive.MaxFiles,
+			MaxBytes: cfg.Archive.MaxBytes,
+		}),
+	}
+	if previews != nil {
+		serverOpts = append(serverOpts, server.WithPreviews(previews))
+	}
+	if cfg.TLS.Enabled() {
+		serverOpts = append(serverOpts, server.WithTLS(server.TLSOptions{
+			CertFile:          cfg.TLS.CertFile,
+			KeyFile:           cfg.TLS.KeyFile,
+			ClientCAFile:      cfg.TLS.ClientCAFile,
+			RequireClientCert: cfg.TLS.RequireClientCert,
+		}))
+	}
+	if cfg.Auth.Enabled {
+		authenticator, err := newAuthenticator(cfg.Auth)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("configure auth: %w", err)
+		}
+		serverOpts = append(serverOpts, server.WithAuthenticator(authenticator))
+	}
+
 	renderer := frontend.NewRenderer()
-	srv := server.New(idx, renderer)
+	srv := server.New(idx, renderer, serverOpts...)
 
 	return &App{cfg: cfg, indexer: idx, server: srv, store: store}, nil
 }
 
+func newAuthenticator(cfg config.Auth) (*auth.Authenticator, error) {
+	opts := auth.Options{
+		SessionTTL: time.Duration(cfg.SessionTTLHours) * time.Hour,
+	}
+	for _, user := range cfg.Users {
+		opts.Users = append(opts.Users, auth.User{Name: user.Name, PasswordHash: user.PasswordHash})
+	}
+	for _, token := range cfg.Tokens {
+		opts.Tokens = append(opts.Tokens, auth.Token{Name: token.Name, SHA256: token.SHA256})
+	}
+	for _, role := range cfg.Roles {
+		permissions := make([]auth.Permission, 0, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			permissions = append(permissions, auth.Permission(permission))
+		}
+		opts.Roles = append(opts.Roles, auth.Role{
+			Name:        role.Name,
+			Members:     role.Members,
+			Roots:       role.Roots,
+			Permissions: permissions,
+		})
+	}
+	return auth.New(opts)
+}
+
 // Run boots the indexer and starts the HTTP server until the context is cancelled.
 func (a *App) Run(ctx context.Context) error {
 	log.Printf("loading cached index from %s", a.cfg.DatabasePath)
@@ -54,11 +157,31 @@ func (a *App) Run(ctx context.Context) error {
 		initialMode = indexer.ScanModeFull
 	}
 
-	if err := a.indexer.StartScan(ctx, initialMode); err != nil && err != indexer.ErrScanInProgress {
+	if err := a.indexer.StartScan(ctx, initialMode); err != nil {
 		return fmt.Errorf("start initial scan: %w", err)
 	}
 
-	log.Printf("starting server on %s", a.cfg.ListenAddr)
+	if err := a.indexer.StartScheduler(ctx); err != nil {
+		return fmt.Errorf("start scan scheduler: %w", err)
+	}
+
+	var watchRoots []string
+	for _, root := range a.cfg.Roots {
+		if root.Watch {
+			watchRoots = append(watchRoots, root.Path)
+		}
+	}
+	if len(watchRoots) > 0 {
+		if err := a.indexer.StartWatching(ctx, watchRoots); err != nil {
+			log.Printf("filesystem watcher: %v", err)
+		}
+	}
+
+	scheme := "http"
+	if a.cfg.TLS.Enabled() {
+		scheme = "https"
+	}
+	log.Printf("starting %s server on %s", scheme, a.cfg.ListenAddr)
 	if err := a.server.Start(ctx, a.cfg.ListenAddr); err != nil {
 		return fmt.Errorf("run server: %w", err)
 	}
@@ -66,6 +189,25 @@ func (a *App) Run(ctx context.Context) error {
 	return nil
 }
 
+// Scan loads the cached index, runs a single scan of paths, or of every root
+// when paths is empty, and waits for it to end. It serves one-off scans from
+// the command line.
+func (a *App) Scan(ctx context.Context, mode indexer.ScanMode, paths []string) (indexer.ScanStatus, error) {
+	if _, err := a.indexer.LoadFromStore(ctx); err != nil {
+		return indexer.ScanStatus{}, fmt.Errorf("load cached index: %w", err)
+	}
+
+	job, err := a.indexer.QueueScan(ctx, indexer.ScanRequest{Mode: mode, Paths: paths})
+	if err != nil {
+		return indexer.ScanStatus{}, fmt.Errorf("start scan: %w", err)
+	}
+	// Cancelling ctx cancels the job too; wait for it to wind down regardless.
+	if _, err := a.indexer.WaitScan(context.WithoutCancel(ctx), job.ID); err != nil {
+		return indexer.ScanStatus{}, err
+	}
+	return a.indexer.Status(), nil
+}
+
 // Indexer exposes the underlying indexer instance for future integrations.
 func (a *App) Indexer() *indexer.Indexer {
 	return a.indexer
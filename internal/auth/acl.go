@@ -0,0 +1,82 @@
+package auth
+
+// Permission is an action a principal may perform on a scan root.
+type Permission string
+
+const (
+	// PermRead allows searching the files of a root.
+	PermRead Permission = "read"
+	// PermDownload allows downloading the files of a root.
+	PermDownload Permission = "download"
+	// PermAdmin allows triggering scans of a root.
+	PermAdmin Permission = "admin"
+)
+
+// AllRoots is the role root that matches every scan root.
+const AllRoots = "*"
+
+// Role grants permissions on scan roots to users and tokens, named by
+// Members.
+type Role struct {
+	Name        string
+	Members     []string
+	Roots       []string
+	Permissions []Permission
+}
+
+// grant is the set of roots a principal may access with one permission.
+type grant struct {
+	all   bool
+	roots map[string]struct{}
+}
+
+// acl maps principal names to their grants. A nil acl allows everything.
+type acl map[string]map[Permission]*grant
+
+func newACL(roles []Role) acl {
+	if len(roles) == 0 {
+		return nil
+	}
+	rules := make(acl)
+	for _, role := range roles {
+		for _, member := range role.Members {
+			perms, ok := rules[member]
+			if !ok {
+				perms = make(map[Permission]*grant)
+				rules[member] = perms
+			}
+			for _, permission := range role.Permissions {
+				g, ok := perms[permission]
+				if !ok {
+					g = &grant{roots: make(map[string]struct{})}
+					perms[permission] = g
+				}
+				for _, root := range role.Roots {
+					if root == AllRoots {
+						g.all = true
+						continue
+					}
+					g.roots[root] = struct{}{}
+				}
+			}
+		}
+	}
+	return rules
+}
+
+// Allowed reports whether principal holds permission on the scan root. When
+// no roles are configured every authenticated principal may do everything.
+func (a *Authenticator) Allowed(principal Principal, permission Permission, root string) bool {
+	if a.acl == nil {
+		return true
+	}
+	g, ok := a.acl[principal.Name][permission]
+	if !ok {
+		return false
+	}
+	if g.all {
+		return true
+	}
+	_, ok = g.roots[root]
+	return ok
+}
@@ -0,0 +1,243 @@
+// Package auth authenticates HTTP requests with session cookies for local
+// users and bearer tokens for scripts.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// SessionCookie is the name of the cookie carrying the session ID.
+	SessionCookie = "seekfile_session"
+	// DefaultSessionTTL is how long a session lasts when no lifetime is
+	// configured.
+	DefaultSessionTTL = 24 * time.Hour
+)
+
+// ErrInvalidCredentials is returned by Login for an unknown user or a wrong
+// password.
+var ErrInvalidCredentials = errors.New("invalid user name or password")
+
+// Principal kinds.
+const (
+	KindUser        = "user"
+	KindToken       = "token"
+	KindCertificate = "certificate"
+)
+
+// Principal identifies an authenticated user or API token.
+type Principal struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// User is a local account with a bcrypt password hash.
+type User struct {
+	Name         string
+	PasswordHash string
+}
+
+// Token is an API token, stored as the hex SHA-256 of its value.
+type Token struct {
+	Name   string
+	SHA256 string
+}
+
+// Options configures an Authenticator.
+type Options struct {
+	Users  []User
+	Tokens []Token
+	// Roles restrict principals to scan roots. Without roles every principal
+	// may access every root.
+	Roles []Role
+	// SessionTTL is the lifetime of a login session. Zero selects
+	// DefaultSessionTTL.
+	SessionTTL time.Duration
+}
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// Authenticator verifies credentials and keeps the login sessions, which are
+// held in memory and end when the process restarts.
+type Authenticator struct {
+	users  map[string][]byte
+	tokens map[string]string
+	ttl    time.Duration
+	// dummyHash is compared against for unknown users so that a login takes
+	// as long whether or not the user exists.
+	dummyHash []byte
+	acl       acl
+
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+// New builds an Authenticator from the configured users and tokens.
+func New(opts Options) (*Authenticator, error) {
+	a := &Authenticator{
+		users:    make(map[string][]byte, len(opts.Users)),
+		tokens:   make(map[string]string, len(opts.Tokens)),
+		ttl:      opts.SessionTTL,
+		sessions: make(map[string]session),
+		acl:      newACL(opts.Roles),
+	}
+	if a.ttl <= 0 {
+		a.ttl = DefaultSessionTTL
+	}
+
+	for _, user := range opts.Users {
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("user %s: invalid password hash: %w", user.Name, err)
+		}
+		a.users[user.Name] = []byte(user.PasswordHash)
+	}
+	for _, token := range opts.Tokens {
+		sum := strings.ToLower(strings.TrimSpace(token.SHA256))
+		if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("token %s: sha256 must be 64 hexadecimal characters", token.Name)
+		}
+		a.tokens[sum] = token.Name
+	}
+
+	dummy, err := bcrypt.GenerateFromPassword([]byte("seekfile"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	a.dummyHash = dummy
+	return a, nil
+}
+
+// Login checks a user's password and starts a session. It returns the
+// session ID and its expiry.
+func (a *Authenticator) Login(name, password string) (string, time.Time, error) {
+	hash, ok := a.users[name]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+
+	id, err := randomString(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(a.ttl)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for key, existing := range a.sessions {
+		if now.After(existing.expires) {
+			delete(a.sessions, key)
+		}
+	}
+	a.sessions[id] = session{user: name, expires: expires}
+	return id, expires, nil
+}
+
+// Logout ends a session.
+func (a *Authenticator) Logout(sessionID string) {
+	a.mu.Lock()
+	delete(a.sessions, sessionID)
+	a.mu.Unlock()
+}
+
+// Authenticate identifies the principal behind a request from its bearer
+// token, its verified client certificate or its session cookie. A client
+// certificate maps to the user named by its subject common name.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, value, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return Principal{}, false
+		}
+		name, ok := a.tokens[HashToken(strings.TrimSpace(value))]
+		if !ok {
+			return Principal{}, false
+		}
+		return Principal{Name: name, Kind: KindToken}, true
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if _, ok := a.users[name]; ok {
+			return Principal{Name: name, Kind: KindCertificate}, true
+		}
+	}
+
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return Principal{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	current, ok := a.sessions[cookie.Value]
+	if !ok {
+		return Principal{}, false
+	}
+	if time.Now().After(current.expires) {
+		delete(a.sessions, cookie.Value)
+		return Principal{}, false
+	}
+	return Principal{Name: current.user, Kind: KindUser}, true
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated principal.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal stored by WithPrincipal.
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// HashPassword returns the bcrypt hash of a password for the configuration
+// file.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// NewToken generates a random API token.
+func NewToken() (string, error) {
+	return randomString(32)
+}
+
+// HashToken returns the hex SHA-256 of a token as stored in the
+// configuration file.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
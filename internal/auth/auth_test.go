@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testToken = "script-token"
+
+// newTestAuthenticator returns an Authenticator with the users alice and bob,
+// whose passwords are their names, and the API token "script", whose value
+// is testToken.
+func newTestAuthenticator(t *testing.T, opts Options) *Authenticator {
+	t.Helper()
+	for _, name := range []string{"alice", "bob"} {
+		hash, err := bcrypt.GenerateFromPassword([]byte(name), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.Users = append(opts.Users, User{Name: name, PasswordHash: string(hash)})
+	}
+	opts.Tokens = append(opts.Tokens, Token{Name: "script", SHA256: HashToken(testToken)})
+	a, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// login starts a session and returns its ID.
+func login(t *testing.T, a *Authenticator, name string) string {
+	t.Helper()
+	id, _, err := a.Login(name, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// withCertificate marks the request as carrying a verified client
+// certificate for name.
+func withCertificate(r *http.Request, name string) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := newTestAuthenticator(t, Options{})
+	session := login(t, a, "alice")
+
+	for _, tc := range []struct {
+		name        string
+		header      string
+		certificate string
+		cookie      string
+		want        Principal
+		ok          bool
+	}{
+		{name: "no credentials"},
+		{name: "session", cookie: session, want: Principal{Name: "alice", Kind: KindUser}, ok: true},
+		{name: "unknown session", cookie: "forged"},
+		{name: "token", header: "Bearer " + testToken, want: Principal{Name: "script", Kind: KindToken}, ok: true},
+		{name: "token scheme is case-insensitive", header: "bearer " + testToken, want: Principal{Name: "script", Kind: KindToken}, ok: true},
+		{name: "unknown token", header: "Bearer other"},
+		{name: "other scheme", header: "Basic " + testToken},
+		{name: "certificate", certificate: "bob", want: Principal{Name: "bob", Kind: KindCertificate}, ok: true},
+		{name: "certificate of an unknown user", certificate: "mallory"},
+
+		// A bearer token takes precedence over every other credential, even
+		// when it is rejected; a certificate over the session cookie.
+		{name: "token before certificate and session", header: "Bearer " + testToken, certificate: "bob", cookie: session, want: Principal{Name: "script", Kind: KindToken}, ok: true},
+		{name: "rejected token before session", header: "Bearer other", cookie: session},
+		{name: "certificate before session", certificate: "bob", cookie: session, want: Principal{Name: "bob", Kind: KindCertificate}, ok: true},
+		{name: "unknown certificate falls back to session", certificate: "mallory", cookie: session, want: Principal{Name: "alice", Kind: KindUser}, ok: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/search", nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+			if tc.certificate != "" {
+				withCertificate(r, tc.certificate)
+			}
+			if tc.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: SessionCookie, Value: tc.cookie})
+			}
+			got, ok := a.Authenticate(r)
+			if ok != tc.ok || got != tc.want {
+				t.Fatalf("Authenticate = %+v, %t, want %+v, %t", got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	a := newTestAuthenticator(t, Options{SessionTTL: time.Hour})
+	session := login(t, a, "alice")
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: SessionCookie, Value: session})
+		return r
+	}
+	if _, ok := a.Authenticate(request()); !ok {
+		t.Fatal("fresh session rejected")
+	}
+
+	a.mu.Lock()
+	current := a.sessions[session]
+	current.expires = time.Now().Add(-time.Second)
+	a.sessions[session] = current
+	a.mu.Unlock()
+
+	if principal, ok := a.Authenticate(request()); ok {
+		t.Fatalf("expired session authenticated %+v", principal)
+	}
+	a.mu.Lock()
+	_, kept := a.sessions[session]
+	a.mu.Unlock()
+	if kept {
+		t.Error("expired session was kept")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	a := newTestAuthenticator(t, Options{})
+	for _, tc := range []struct{ name, password string }{
+		{"alice", "bob"},
+		{"mallory", "mallory"},
+		{"", ""},
+	} {
+		if _, _, err := a.Login(tc.name, tc.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Login(%q, %q): err = %v, want ErrInvalidCredentials", tc.name, tc.password, err)
+		}
+	}
+
+	// Logging out ends the session.
+	session := login(t, a, "bob")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: session})
+	a.Logout(session)
+	if principal, ok := a.Authenticate(r); ok {
+		t.Fatalf("session authenticated %+v after logout", principal)
+	}
+}
+
+func TestNewRejectsInvalidCredentials(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"password hash": {Users: []User{{Name: "alice", PasswordHash: "plain"}}},
+		"token hash":    {Tokens: []Token{{Name: "script", SHA256: "abc"}}},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("New accepted an invalid %s", name)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	a := newTestAuthenticator(t, Options{Roles: []Role{
+		{Name: "staff", Members: []string{"alice", "bob"}, Roots: []string{"/data/docs"}, Permissions: []Permission{PermRead}},
+		{Name: "uploaders", Members: []string{"alice"}, Roots: []string{"/data/docs", "/data/media"}, Permissions: []Permission{PermDownload}},
+		{Name: "ops", Members: []string{"script"}, Roots: []string{AllRoots}, Permissions: []Permission{PermRead, PermAdmin}},
+	}})
+
+	for _, tc := range []struct {
+		principal  string
+		permission Permission
+		root       string
+		want       bool
+	}{
+		{"alice", PermRead, "/data/docs", true},
+		{"alice", PermRead, "/data/media", false},
+		{"alice", PermDownload, "/data/media", true},
+		{"alice", PermAdmin, "/data/docs", false},
+		{"bob", PermRead, "/data/docs", true},
+		{"bob", PermDownload, "/data/docs", false},
+		{"script", PermRead, "/data/media", true},
+		{"script", PermAdmin, "/anything", true},
+		{"script", PermDownload, "/data/docs", false},
+		{"mallory", PermRead, "/data/docs", false},
+	} {
+		if got := a.Allowed(Principal{Name: tc.principal}, tc.permission, tc.root); got != tc.want {
+			t.Errorf("Allowed(%s, %s, %s) = %t, want %t", tc.principal, tc.permission, tc.root, got, tc.want)
+		}
+	}
+
+	// Without roles every principal may do everything.
+	open := newTestAuthenticator(t, Options{})
+	if !open.Allowed(Principal{Name: "mallory"}, PermAdmin, "/data/docs") {
+		t.Error("Allowed without roles = false, want true")
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -17,6 +18,10 @@ type Config struct {
 	// ScanPaths are the root directories that will be indexed and watched for changes.
 	ScanPaths []string
 
+	// Roots carries the per-root settings for every entry in ScanPaths, in the
+	// same order.
+	Roots []Root
+
 	// RebuildOnStart forces the index to rebuild even if cached data is available.
 	// The flag is included for future extensibility and currently has no effect
 	// beyond signaling intent.
@@ -24,6 +29,243 @@ type Config struct {
 
 	// DatabasePath specifies where the on-disk index cache is stored.
 	DatabasePath string
+
+	// HashContents enables content hashing during scans so duplicate files can
+	// be detected.
+	HashContents bool
+
+	// ContentIndex configures full-text indexing of file contents.
+	ContentIndex ContentIndex
+
+	// MemoryIndex keeps every record in memory for fast searches. When false,
+	// searches are answered by indexed SQL queries so memory use stays bounded
+	// on large installs.
+	MemoryIndex bool
+
+	// WriteBatchSize is the number of record writes a scan commits per
+	// transaction. Zero uses the indexer default.
+	WriteBatchSize int
+
+	// ChangeLog configures the persistent log of file changes served by
+	// /api/changes.
+	ChangeLog ChangeLog
+
+	// Auth configures authentication for the web UI and the API.
+	Auth Auth
+
+	// TLS configures HTTPS; it is disabled when no certificate is set.
+	TLS TLS
+
+	// Archive limits the multi-file downloads of /api/archive.
+	Archive Archive
+
+	// Preview configures thumbnails and text previews.
+	Preview Preview
+}
+
+// Preview configures /api/preview.
+type Preview struct {
+	Enabled bool `json:"enabled"`
+
+	// CacheDir holds the thumbnail cache. Defaults to "thumbnails" next to
+	// the database.
+	CacheDir string `json:"cache_dir"`
+
+	// ThumbnailSize is the longest edge of a thumbnail in pixels. Zero uses
+	// 256.
+	ThumbnailSize int `json:"thumbnail_size"`
+
+	// TextLines is the number of lines shown for text files. Zero uses 20.
+	TextLines int `json:"text_lines"`
+}
+
+// Archive limits the size of a single archive download.
+type Archive struct {
+	// MaxFiles caps the number of files. Zero uses 10000.
+	MaxFiles int `json:"max_files"`
+
+	// MaxBytes caps the total size of the files. Zero uses 4 GiB.
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// TLS configures the server certificate and client certificate checks.
+type TLS struct {
+	// CertFile and KeyFile are PEM files, reloaded when they change.
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// ClientCAFile enables mutual TLS: client certificates are verified
+	// against these CA certificates and map to the user named by their
+	// common name.
+	ClientCAFile string `json:"client_ca_file"`
+
+	// RequireClientCert rejects clients without a valid certificate.
+	RequireClientCert bool `json:"require_client_cert"`
+}
+
+// Enabled reports whether HTTPS is configured.
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Auth configures who may use the server.
+type Auth struct {
+	// Enabled requires every request except the login page to carry a
+	// session cookie or an API token.
+	Enabled bool `json:"enabled"`
+
+	// SessionTTLHours is how long a web login lasts. Zero uses 24 hours.
+	SessionTTLHours int `json:"session_ttl_hours"`
+
+	// Users are local accounts that sign in through the login page.
+	Users []User `json:"users"`
+
+	// Tokens are bearer tokens for scripts.
+	Tokens []Token `json:"tokens"`
+
+	// Roles grant users and tokens access to scan roots. Without roles every
+	// authenticated user may do everything.
+	Roles []Role `json:"roles"`
+}
+
+// Role grants permissions on a set of scan roots to its members.
+type Role struct {
+	Name string `json:"name"`
+
+	// Members are user and token names.
+	Members []string `json:"members"`
+
+	// Roots are scan paths, resolved like scan_paths, or "*" for every root.
+	Roots []string `json:"roots"`
+
+	// Permissions are any of "read" (search), "download" and "admin"
+	// (trigger scans).
+	Permissions []string `json:"permissions"`
+}
+
+// User is a local account.
+type User struct {
+	Name string `json:"name"`
+
+	// PasswordHash is a bcrypt hash, as printed by "seekfile hash-password".
+	PasswordHash string `json:"password_hash"`
+}
+
+// Token is an API token.
+type Token struct {
+	Name string `json:"name"`
+
+	// SHA256 is the hex SHA-256 of the token value, as printed by
+	// "seekfile new-token".
+	SHA256 string `json:"sha256"`
+}
+
+// ChangeLog configures the persistent change log.
+type ChangeLog struct {
+	// Enabled records every created, modified, moved and deleted file.
+	Enabled bool `json:"enabled"`
+
+	// RetentionDays is how long entries are kept. Zero uses the indexer
+	// default of 30 days.
+	RetentionDays int `json:"retention_days"`
+
+	// MaxEntries caps the number of entries kept. Zero means no cap.
+	MaxEntries int `json:"max_entries"`
+}
+
+// ContentIndex configures full-text indexing of file contents.
+type ContentIndex struct {
+	// Enabled turns on text extraction during scans.
+	Enabled bool `json:"enabled"`
+
+	// MaxFileSize skips files larger than this many bytes. Zero uses the
+	// indexer default.
+	MaxFileSize int64 `json:"max_file_size"`
+
+	// Extensions restricts extraction to these file extensions. Empty uses the
+	// indexer defaults for plain-text, source and markup files.
+	Extensions []string `json:"extensions"`
+}
+
+// Root captures the settings of a single scan root.
+type Root struct {
+	// Path is the absolute, cleaned directory that is indexed.
+	Path string
+
+	// Watch enables real-time filesystem watching for the root. It is off
+	// unless the root sets "watch": true.
+	Watch bool
+
+	// Exclude lists gitignore-style patterns, relative to the root, for entries
+	// that must not be indexed. Global patterns come first, followed by the
+	// root's own patterns.
+	Exclude []string
+
+	// Include, when non-empty, restricts indexing to files matching at least
+	// one of these gitignore-style patterns. Directories are never filtered by
+	// include rules.
+	Include []string
+
+	// IgnoreFiles names per-directory ignore files (such as .gitignore) whose
+	// rules are honored while walking the tree.
+	IgnoreFiles []string
+
+	// Concurrency bounds how many directories are read in parallel while
+	// scanning the root. Zero lets the indexer pick a default based on whether
+	// the root is on a local disk or a network mount.
+	Concurrency int
+
+	// Schedule runs scans of the root periodically. A root without its own
+	// schedule uses the global one.
+	Schedule Schedule
+
+	// OnUnreadable is "keep" or "remove" and decides whether scans keep the
+	// indexed records beneath directories and files they cannot read, such
+	// as permission-denied directories or broken mounts. A root without its
+	// own setting uses the global one, which defaults to "keep".
+	OnUnreadable string
+}
+
+// Schedule holds cron expressions for periodic scans. An empty expression
+// disables that kind of scan.
+type Schedule struct {
+	// Incremental is when incremental scans run, for example "*/15 * * * *".
+	Incremental string `json:"incremental"`
+
+	// Full is when full scans run, for example "0 3 * * sun".
+	Full string `json:"full"`
+}
+
+// rawRoot accepts either a plain path string or an object with per-root
+// settings inside the scan_paths array.
+type rawRoot struct {
+	Path         string    `json:"path"`
+	Watch        *bool     `json:"watch"`
+	Exclude      []string  `json:"exclude"`
+	Include      []string  `json:"include"`
+	IgnoreFiles  []string  `json:"ignore_files"`
+	Concurrency  int       `json:"concurrency"`
+	Schedule     *Schedule `json:"schedule"`
+	OnUnreadable string    `json:"on_unreadable"`
+}
+
+func (r *rawRoot) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*r = rawRoot{Path: path}
+		return nil
+	}
+
+	type plain rawRoot
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var value plain
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("scan path entry must be a string or an object: %w", err)
+	}
+	*r = rawRoot(value)
+	return nil
 }
 
 // FromFlags parses configuration from command line flags. It should be called
@@ -61,10 +303,25 @@ func FromFile(path string) (Config, error) {
 	decoder.DisallowUnknownFields()
 
 	var raw struct {
-		ListenAddr     string   `json:"listen_addr"`
-		ScanPaths      []string `json:"scan_paths"`
-		RebuildOnStart bool     `json:"rebuild_on_start"`
-		DatabasePath   string   `json:"database_path"`
+		ListenAddr      string       `json:"listen_addr"`
+		ScanPaths       []rawRoot    `json:"scan_paths"`
+		RebuildOnStart  bool         `json:"rebuild_on_start"`
+		DatabasePath    string       `json:"database_path"`
+		Exclude         []string     `json:"exclude"`
+		Include         []string     `json:"include"`
+		IgnoreFiles     []string     `json:"ignore_files"`
+		HashContents    bool         `json:"hash_contents"`
+		ContentIndex    ContentIndex `json:"content_index"`
+		MemoryIndex     *bool        `json:"memory_index"`
+		ScanConcurrency int          `json:"scan_concurrency"`
+		Schedule        Schedule     `json:"schedule"`
+		OnUnreadable    string       `json:"on_unreadable"`
+		WriteBatchSize  int          `json:"write_batch_size"`
+		ChangeLog       ChangeLog    `json:"change_log"`
+		Auth            Auth         `json:"auth"`
+		TLS             TLS          `json:"tls"`
+		Archive         Archive      `json:"archive"`
+		Preview         Preview      `json:"preview"`
 	}
 
 	if err := decoder.Decode(&raw); err != nil {
@@ -77,11 +334,56 @@ func FromFile(path string) (Config, error) {
 		return Config{}, fmt.Errorf("resolve configuration directory %q: %w", baseDir, err)
 	}
 
-	paths, err := normalizeScanPaths(raw.ScanPaths, baseAbs)
+	roots, err := normalizeScanPaths(raw.ScanPaths, baseAbs, cleanSchedule(raw.Schedule))
+	if err != nil {
+		return Config{}, err
+	}
+
+	for i := range roots {
+		roots[i].Exclude = append(cleanPatterns(raw.Exclude), roots[i].Exclude...)
+		roots[i].Include = append(cleanPatterns(raw.Include), roots[i].Include...)
+		if roots[i].IgnoreFiles == nil {
+			roots[i].IgnoreFiles = cleanPatterns(raw.IgnoreFiles)
+		}
+		if roots[i].Concurrency == 0 {
+			roots[i].Concurrency = raw.ScanConcurrency
+		}
+		if roots[i].Concurrency < 0 {
+			return Config{}, fmt.Errorf("scan path %q: concurrency must not be negative", roots[i].Path)
+		}
+		if roots[i].OnUnreadable == "" {
+			roots[i].OnUnreadable = raw.OnUnreadable
+		}
+		if roots[i].OnUnreadable, err = cleanUnreadablePolicy(roots[i].OnUnreadable); err != nil {
+			return Config{}, fmt.Errorf("scan path %q: %w", roots[i].Path, err)
+		}
+	}
+
+	if raw.ChangeLog.RetentionDays < 0 || raw.ChangeLog.MaxEntries < 0 {
+		return Config{}, fmt.Errorf("change log retention must not be negative")
+	}
+
+	if raw.Archive.MaxFiles < 0 || raw.Archive.MaxBytes < 0 {
+		return Config{}, fmt.Errorf("archive limits must not be negative")
+	}
+
+	if err := validateAuth(raw.Auth); err != nil {
+		return Config{}, err
+	}
+	if raw.Auth.Roles, err = normalizeRoles(raw.Auth, roots, baseAbs); err != nil {
+		return Config{}, err
+	}
+
+	tlsConfig, err := normalizeTLS(raw.TLS, baseAbs)
 	if err != nil {
 		return Config{}, err
 	}
 
+	paths := make([]string, 0, len(roots))
+	for _, root := range roots {
+		paths = append(paths, root.Path)
+	}
+
 	databasePath := strings.TrimSpace(raw.DatabasePath)
 	if databasePath == "" {
 		databasePath = filepath.Join(baseAbs, "seekfile.db")
@@ -94,11 +396,32 @@ func FromFile(path string) (Config, error) {
 		return Config{}, fmt.Errorf("resolve database path %q: %w", databasePath, err)
 	}
 
+	previewConfig := raw.Preview
+	if previewConfig.ThumbnailSize < 0 || previewConfig.TextLines < 0 {
+		return Config{}, fmt.Errorf("preview sizes must not be negative")
+	}
+	previewConfig.CacheDir = strings.TrimSpace(previewConfig.CacheDir)
+	if previewConfig.CacheDir == "" {
+		previewConfig.CacheDir = filepath.Join(filepath.Dir(dbAbs), "thumbnails")
+	} else if !filepath.IsAbs(previewConfig.CacheDir) {
+		previewConfig.CacheDir = filepath.Join(baseAbs, previewConfig.CacheDir)
+	}
+
 	cfg := Config{
 		ListenAddr:     strings.TrimSpace(raw.ListenAddr),
 		ScanPaths:      paths,
+		Roots:          roots,
 		RebuildOnStart: raw.RebuildOnStart,
 		DatabasePath:   filepath.Clean(dbAbs),
+		HashContents:   raw.HashContents,
+		ContentIndex:   raw.ContentIndex,
+		MemoryIndex:    raw.MemoryIndex == nil || *raw.MemoryIndex,
+		WriteBatchSize: raw.WriteBatchSize,
+		ChangeLog:      raw.ChangeLog,
+		Auth:           raw.Auth,
+		TLS:            tlsConfig,
+		Archive:        raw.Archive,
+		Preview:        previewConfig,
 	}
 
 	if cfg.ListenAddr == "" {
@@ -108,10 +431,12 @@ func FromFile(path string) (Config, error) {
 	return cfg, nil
 }
 
-func normalizeScanPaths(raw []string, baseDir string) ([]string, error) {
-	normalized := make([]string, 0, len(raw))
+// normalizeScanPaths resolves the scan paths against baseDir. Roots without a
+// schedule of their own get schedule.
+func normalizeScanPaths(raw []rawRoot, baseDir string, schedule Schedule) ([]Root, error) {
+	normalized := make([]Root, 0, len(raw))
 	for _, part := range raw {
-		trimmed := strings.TrimSpace(part)
+		trimmed := strings.TrimSpace(part.Path)
 		if trimmed == "" {
 			continue
 		}
@@ -126,12 +451,180 @@ func normalizeScanPaths(raw []string, baseDir string) ([]string, error) {
 			return nil, fmt.Errorf("resolve scan path %q: %w", trimmed, err)
 		}
 
-		normalized = append(normalized, filepath.Clean(abs))
+		root := Root{
+			Path:         filepath.Clean(abs),
+			Exclude:      cleanPatterns(part.Exclude),
+			Include:      cleanPatterns(part.Include),
+			Concurrency:  part.Concurrency,
+			Schedule:     schedule,
+			OnUnreadable: part.OnUnreadable,
+		}
+		if part.Schedule != nil {
+			root.Schedule = cleanSchedule(*part.Schedule)
+		}
+		if part.Watch != nil {
+			root.Watch = *part.Watch
+		}
+		if part.IgnoreFiles != nil {
+			root.IgnoreFiles = cleanPatterns(part.IgnoreFiles)
+		}
+		normalized = append(normalized, root)
 	}
 
 	if len(normalized) == 0 {
-		normalized = append(normalized, filepath.Clean(baseDir))
+		normalized = append(normalized, Root{Path: filepath.Clean(baseDir), Schedule: schedule})
 	}
 
 	return normalized, nil
 }
+
+func validateAuth(auth Auth) error {
+	if !auth.Enabled {
+		return nil
+	}
+	if len(auth.Users) == 0 && len(auth.Tokens) == 0 {
+		return fmt.Errorf("auth: at least one user or token is required when enabled")
+	}
+	if auth.SessionTTLHours < 0 {
+		return fmt.Errorf("auth: session_ttl_hours must not be negative")
+	}
+
+	names := make(map[string]struct{}, len(auth.Users)+len(auth.Tokens))
+	for _, user := range auth.Users {
+		if strings.TrimSpace(user.Name) == "" || user.PasswordHash == "" {
+			return fmt.Errorf("auth: users need a name and a password_hash")
+		}
+		if _, ok := names[user.Name]; ok {
+			return fmt.Errorf("auth: duplicate user %q", user.Name)
+		}
+		names[user.Name] = struct{}{}
+	}
+	for _, token := range auth.Tokens {
+		if strings.TrimSpace(token.Name) == "" || token.SHA256 == "" {
+			return fmt.Errorf("auth: tokens need a name and a sha256")
+		}
+		if _, ok := names[token.Name]; ok {
+			return fmt.Errorf("auth: duplicate user or token name %q", token.Name)
+		}
+		names[token.Name] = struct{}{}
+	}
+	return nil
+}
+
+// normalizeRoles resolves the roots of each role and checks that they name
+// configured scan paths, and that members and permissions are known.
+func normalizeRoles(auth Auth, roots []Root, baseDir string) ([]Role, error) {
+	if len(auth.Roles) == 0 {
+		return nil, nil
+	}
+
+	members := make(map[string]struct{}, len(auth.Users)+len(auth.Tokens))
+	for _, user := range auth.Users {
+		members[user.Name] = struct{}{}
+	}
+	for _, token := range auth.Tokens {
+		members[token.Name] = struct{}{}
+	}
+	scanRoots := make(map[string]struct{}, len(roots))
+	for _, root := range roots {
+		scanRoots[root.Path] = struct{}{}
+	}
+
+	normalized := make([]Role, 0, len(auth.Roles))
+	for _, role := range auth.Roles {
+		if strings.TrimSpace(role.Name) == "" {
+			return nil, fmt.Errorf("auth: roles need a name")
+		}
+		for _, member := range role.Members {
+			if _, ok := members[member]; !ok {
+				return nil, fmt.Errorf("auth: role %q: unknown member %q", role.Name, member)
+			}
+		}
+		for _, permission := range role.Permissions {
+			switch permission {
+			case "read", "download", "admin":
+			default:
+				return nil, fmt.Errorf("auth: role %q: unknown permission %q", role.Name, permission)
+			}
+		}
+
+		resolved := make([]string, 0, len(role.Roots))
+		for _, root := range role.Roots {
+			root = strings.TrimSpace(root)
+			if root == "*" {
+				resolved = append(resolved, root)
+				continue
+			}
+			if !filepath.IsAbs(root) {
+				root = filepath.Join(baseDir, root)
+			}
+			root = filepath.Clean(root)
+			if _, ok := scanRoots[root]; !ok {
+				return nil, fmt.Errorf("auth: role %q: %q is not a scan path", role.Name, root)
+			}
+			resolved = append(resolved, root)
+		}
+		role.Roots = resolved
+		normalized = append(normalized, role)
+	}
+	return normalized, nil
+}
+
+// normalizeTLS resolves the TLS file paths relative to the configuration
+// directory.
+func normalizeTLS(raw TLS, baseDir string) (TLS, error) {
+	resolve := func(path string) string {
+		path = strings.TrimSpace(path)
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(baseDir, path)
+	}
+	cfg := TLS{
+		CertFile:          resolve(raw.CertFile),
+		KeyFile:           resolve(raw.KeyFile),
+		ClientCAFile:      resolve(raw.ClientCAFile),
+		RequireClientCert: raw.RequireClientCert,
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return TLS{}, fmt.Errorf("tls: cert_file and key_file must be set together")
+	}
+	if cfg.CertFile == "" && (cfg.ClientCAFile != "" || cfg.RequireClientCert) {
+		return TLS{}, fmt.Errorf("tls: client certificates require cert_file and key_file")
+	}
+	if cfg.RequireClientCert && cfg.ClientCAFile == "" {
+		return TLS{}, fmt.Errorf("tls: require_client_cert needs client_ca_file")
+	}
+	return cfg, nil
+}
+
+func cleanPatterns(raw []string) []string {
+	cleaned := make([]string, 0, len(raw))
+	for _, pattern := range raw {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		cleaned = append(cleaned, pattern)
+	}
+	return cleaned
+}
+
+// cleanUnreadablePolicy validates an on_unreadable setting, defaulting to
+// "keep".
+func cleanUnreadablePolicy(policy string) (string, error) {
+	switch policy = strings.ToLower(strings.TrimSpace(policy)); policy {
+	case "":
+		return "keep", nil
+	case "keep", "remove":
+		return policy, nil
+	default:
+		return "", fmt.Errorf("on_unreadable must be keep or remove, got %q", policy)
+	}
+}
+
+func cleanSchedule(raw Schedule) Schedule {
+	return Schedule{
+		Incremental: strings.TrimSpace(raw.Incremental),
+		Full:        strings.TrimSpace(raw.Full),
+	}
+}
@@ -0,0 +1,218 @@
+// Package cron parses cron expressions and computes their activation times.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchLimit bounds how far ahead Next looks for an activation, so that
+// expressions that can never fire, such as 0 0 30 2 *, do not loop forever.
+const searchLimit = 5 * 366 * 24 * time.Hour
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	expr   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// domAny and dowAny are set when the day fields start with *. When both
+	// day fields are restricted, a day matching either of them activates.
+	domAny bool
+	dowAny bool
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is both 0 and 7.
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse compiles a standard five-field cron expression: minute, hour, day of
+// month, month and day of week. Fields accept *, single values, ranges (a-b),
+// steps (*/n, a-b/n, a/n) and comma-separated lists of those; months and days
+// of the week may also be given by their three-letter English names. The
+// descriptors @yearly, @annually, @monthly, @weekly, @daily, @midnight and
+// @hourly are accepted as shorthands.
+func Parse(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := descriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("invalid cron expression %q: unknown descriptor", expr)
+		}
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	schedule := &Schedule{
+		expr:   strings.TrimSpace(expr),
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	for i, target := range []struct {
+		bits *uint64
+		def  field
+	}{
+		{&schedule.minute, minuteField},
+		{&schedule.hour, hourField},
+		{&schedule.dom, domField},
+		{&schedule.month, monthField},
+		{&schedule.dow, dowField},
+	} {
+		if *target.bits, err = target.def.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+	}
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	return schedule, nil
+}
+
+// String returns the expression the schedule was parsed from.
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Next returns the first activation strictly after t, in t's location. It
+// returns the zero time when the schedule does not fire within five years.
+//
+// Activations are searched in wall-clock time, so a daylight saving change
+// neither repeats nor skips them: a time that occurs twice when the clocks go
+// back fires once, and a time that does not exist when they go forward fires
+// at the moment the clocks jump.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	// wall holds the local date and time in UTC, where every day has 24
+	// hours.
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	limit := wall.Add(searchLimit)
+
+	for wall.Before(limit) {
+		year, month, day := wall.Date()
+		switch {
+		case s.month&(1<<uint(month)) == 0:
+			wall = time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(wall):
+			wall = time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(wall.Hour())) == 0:
+			wall = wall.Add(time.Duration(60-wall.Minute()) * time.Minute)
+		case s.minute&(1<<uint(wall.Minute())) == 0:
+			wall = wall.Add(time.Minute)
+		default:
+			next := time.Date(year, month, day, wall.Hour(), wall.Minute(), 0, 0, loc)
+			if next.Hour() != wall.Hour() || next.Minute() != wall.Minute() {
+				// The wall-clock time falls in the gap of a change forward;
+				// fire when the gap ends.
+				_, next = next.ZoneBounds()
+			}
+			if next.After(t) {
+				return next
+			}
+			// The wall-clock time maps to an instant at or before t, as
+			// happens during the repeated hour of a change back.
+			wall = wall.Add(time.Minute)
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// parse returns the set of values selected by a field as a bit mask.
+func (f field) parse(expr string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		span, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("%s: invalid step %q", f.name, stepText)
+			}
+		}
+
+		var low, high int
+		if span == "*" {
+			low, high = f.min, f.max
+		} else {
+			lowText, highText, isRange := strings.Cut(span, "-")
+			var err error
+			if low, err = f.value(lowText); err != nil {
+				return 0, err
+			}
+			high = low
+			switch {
+			case isRange:
+				if high, err = f.value(highText); err != nil {
+					return 0, err
+				}
+			case hasStep:
+				high = f.max
+			}
+			if low > high {
+				return 0, fmt.Errorf("%s: range %q is backwards", f.name, span)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(text string) (int, error) {
+	if value, ok := f.names[strings.ToLower(text)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value %q", f.name, text)
+	}
+	if value < f.min || value > f.max {
+		return 0, fmt.Errorf("%s: %d is outside %d-%d", f.name, value, f.min, f.max)
+	}
+	return value, nil
+}
@@ -0,0 +1,135 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "expected 5 fields, got 0"},
+		{"* * * *", "expected 5 fields, got 4"},
+		{"* * * * * *", "expected 5 fields, got 6"},
+		{"@fortnightly", "unknown descriptor"},
+		{"60 * * * *", "minute: 60 is outside 0-59"},
+		{"* 24 * * *", "hour: 24 is outside 0-23"},
+		{"* * 0 * *", "day of month: 0 is outside 1-31"},
+		{"* * * 13 *", "month: 13 is outside 1-12"},
+		{"* * * * 8", "day of week: 8 is outside 0-7"},
+		{"*/0 * * * *", `minute: invalid step "0"`},
+		{"*/x * * * *", `minute: invalid step "x"`},
+		{"* 10-5 * * *", `hour: range "10-5" is backwards`},
+		{"* * * foo *", `month: invalid value "foo"`},
+		{"1,,2 * * * *", `minute: invalid value ""`},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want error containing %q", tt.expr, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %q, want it to contain %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestParseString(t *testing.T) {
+	for _, expr := range []string{"*/5 * * * *", "@daily", "0 9 * jan-mar mon-fri"} {
+		schedule, err := Parse("  " + expr + " ")
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", expr, err)
+		}
+		if got := schedule.String(); got != expr {
+			t.Errorf("String() = %q, want %q", got, expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// America/New_York springs forward at 2024-03-10 02:00 EST and falls back
+	// at 2024-11-03 02:00 EDT.
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string // empty when the schedule never fires
+	}{
+		{"strictly after", "0 0 * * *", "2024-01-01T00:00:00-05:00", "2024-01-02T00:00:00-05:00"},
+		{"seconds are dropped", "*/15 * * * *", "2024-01-01T10:14:30-05:00", "2024-01-01T10:15:00-05:00"},
+		{"minute step", "*/15 * * * *", "2024-01-01T10:07:00-05:00", "2024-01-01T10:15:00-05:00"},
+		{"hour step", "0 */6 * * *", "2024-01-01T10:07:00-05:00", "2024-01-01T12:00:00-05:00"},
+		{"range step", "0 9-17/4 * * *", "2024-01-01T13:01:00-05:00", "2024-01-01T17:00:00-05:00"},
+		{"value step", "0 0 10/10 * *", "2024-01-21T00:00:00-05:00", "2024-01-30T00:00:00-05:00"},
+		{"list", "5,35 * * * *", "2024-01-01T10:06:00-05:00", "2024-01-01T10:35:00-05:00"},
+		{"descriptor", "@hourly", "2024-01-01T10:07:00-05:00", "2024-01-01T11:00:00-05:00"},
+		{"month rollover", "0 0 1 * *", "2024-01-31T12:00:00-05:00", "2024-02-01T00:00:00-05:00"},
+		{"year rollover", "0 0 1 1 *", "2024-12-31T23:59:00-05:00", "2025-01-01T00:00:00-05:00"},
+		{"short months are skipped", "0 0 31 * *", "2024-04-15T00:00:00-04:00", "2024-05-31T00:00:00-04:00"},
+		{"leap day", "0 0 29 2 *", "2024-03-01T00:00:00-05:00", "2028-02-29T00:00:00-05:00"},
+		{"impossible date", "0 0 30 2 *", "2024-01-01T00:00:00-05:00", ""},
+		{"month names", "0 0 1 jun,DEC *", "2024-07-01T00:00:00-04:00", "2024-12-01T00:00:00-05:00"},
+		{"sunday as 7", "0 0 * * 7", "2024-01-01T00:00:00-05:00", "2024-01-07T00:00:00-05:00"},
+
+		// When both day fields are restricted, either one matches.
+		{"day of week before day of month", "0 0 13 * fri", "2024-01-10T00:00:00-05:00", "2024-01-12T00:00:00-05:00"},
+		{"day of month before day of week", "0 0 13 * fri", "2024-01-12T00:00:00-05:00", "2024-01-13T00:00:00-05:00"},
+		// When either day field starts with *, both must match.
+		{"unrestricted day of month", "0 0 * * fri", "2024-01-01T00:00:00-05:00", "2024-01-05T00:00:00-05:00"},
+		{"unrestricted day of week", "0 0 13 * *", "2024-01-01T00:00:00-05:00", "2024-01-13T00:00:00-05:00"},
+		{"stepped day of month", "0 0 */2 * mon", "2024-01-01T00:00:00-05:00", "2024-01-15T00:00:00-05:00"},
+
+		// 02:30 does not exist on the day the clocks go forward; it fires
+		// when they jump.
+		{"spring forward gap", "30 2 * * *", "2024-03-10T00:00:00-05:00", "2024-03-10T03:00:00-04:00"},
+		{"spring forward next day", "30 2 * * *", "2024-03-10T03:00:00-04:00", "2024-03-11T02:30:00-04:00"},
+		{"spring forward hourly", "0 * * * *", "2024-03-10T01:30:00-05:00", "2024-03-10T03:00:00-04:00"},
+		{"spring forward after gap", "0 * * * *", "2024-03-10T03:00:00-04:00", "2024-03-10T04:00:00-04:00"},
+		// 01:30 occurs twice on the day the clocks go back; it fires once.
+		{"fall back first", "30 1 * * *", "2024-11-03T00:00:00-04:00", "2024-11-03T01:30:00-04:00"},
+		{"fall back no repeat", "30 1 * * *", "2024-11-03T01:30:00-04:00", "2024-11-04T01:30:00-05:00"},
+		{"fall back within repeat", "30 1 * * *", "2024-11-03T01:10:00-05:00", "2024-11-04T01:30:00-05:00"},
+		{"fall back after", "0 3 * * *", "2024-11-03T01:30:00-05:00", "2024-11-03T03:00:00-05:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			from, err := time.Parse(time.RFC3339, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := schedule.Next(from.In(loc))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Fatalf("Next(%s) = %s, want zero time", tt.from, got.Format(time.RFC3339))
+				}
+				return
+			}
+			want, err := time.Parse(time.RFC3339, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(want) {
+				t.Fatalf("Next(%s) = %s, want %s", tt.from, got.Format(time.RFC3339), tt.want)
+			}
+			if got.Location() != loc {
+				t.Fatalf("Next(%s) location = %s, want %s", tt.from, got.Location(), loc)
+			}
+		})
+	}
+}
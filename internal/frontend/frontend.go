@@ -25,7 +25,7 @@ func NewRenderer() *Renderer {
 
 func (r *Renderer) ensureTemplates() error {
 	r.once.Do(func() {
-		tpl, err := template.ParseFS(assets, "templates/index.html")
+		tpl, err := template.ParseFS(assets, "templates/*.html")
 		if err != nil {
 			r.initErr = err
 			return
@@ -44,6 +44,15 @@ func (r *Renderer) RenderIndex(w http.ResponseWriter, data any) error {
 	return r.template.ExecuteTemplate(w, "index.html", data)
 }
 
+// RenderLogin writes the login page to the response writer.
+func (r *Renderer) RenderLogin(w http.ResponseWriter, data any) error {
+	if err := r.ensureTemplates(); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return r.template.ExecuteTemplate(w, "login.html", data)
+}
+
 // StaticHandler returns an http.Handler that serves embedded static assets.
 func (r *Renderer) StaticHandler() http.Handler {
 	sub, err := fs.Sub(assets, "static")
@@ -0,0 +1,263 @@
+// Package ignore implements gitignore-style path matching.
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Pattern is a single compiled gitignore rule.
+type Pattern struct {
+	raw     string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// String returns the pattern as it was written.
+func (p Pattern) String() string {
+	return p.raw
+}
+
+// Rules is an ordered list of patterns whose relative paths are resolved
+// against a base directory.
+type Rules struct {
+	base     string
+	patterns []Pattern
+}
+
+// Parse compiles gitignore-formatted lines relative to base. Blank lines and
+// comments are skipped.
+func Parse(base string, lines []string) (*Rules, error) {
+	rules := &Rules{base: filepath.Clean(base)}
+	for _, line := range lines {
+		pattern, ok, err := compile(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules.patterns = append(rules.patterns, pattern)
+		}
+	}
+	return rules, nil
+}
+
+// ParseFile reads an ignore file located in base. A missing file yields
+// (nil, nil).
+func ParseFile(base, name string) (*Rules, error) {
+	file, err := os.Open(filepath.Join(base, name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", file.Name(), err)
+	}
+
+	rules, err := Parse(base, lines)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file.Name(), err)
+	}
+	return rules, nil
+}
+
+// Len reports the number of patterns.
+func (r *Rules) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.patterns)
+}
+
+// match evaluates the rules against path and reports whether any pattern
+// matched and, if so, whether the last matching pattern excludes the path.
+func (r *Rules) match(path string, isDir bool) (matched, excluded bool) {
+	if r == nil || len(r.patterns) == 0 {
+		return false, false
+	}
+
+	rel, err := filepath.Rel(r.base, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false, false
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, pattern := range r.patterns {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+		if pattern.re.MatchString(rel) {
+			matched = true
+			excluded = !pattern.negate
+		}
+	}
+	return matched, excluded
+}
+
+// Matcher evaluates a stack of rule sets, from the outermost directory to the
+// innermost. Deeper rules take precedence, as in git.
+type Matcher struct {
+	parent *Matcher
+	rules  *Rules
+}
+
+// NewMatcher creates a Matcher from the provided rule sets, lowest precedence
+// first. Nil rule sets are ignored.
+func NewMatcher(rules ...*Rules) *Matcher {
+	var matcher *Matcher
+	for _, r := range rules {
+		matcher = matcher.With(r)
+	}
+	return matcher
+}
+
+// With returns a Matcher that evaluates rules after everything in m. The
+// receiver may be nil.
+func (m *Matcher) With(rules *Rules) *Matcher {
+	if rules.Len() == 0 {
+		return m
+	}
+	return &Matcher{parent: m, rules: rules}
+}
+
+// Match reports whether path is excluded. The path must be absolute and
+// cleaned; isDir enables directory-only patterns.
+func (m *Matcher) Match(path string, isDir bool) bool {
+	_, excluded := m.match(path, isDir)
+	return excluded
+}
+
+func (m *Matcher) match(path string, isDir bool) (matched, excluded bool) {
+	if m == nil {
+		return false, false
+	}
+	matched, excluded = m.parent.match(path, isDir)
+	if ok, verdict := m.rules.match(path, isDir); ok {
+		return true, verdict
+	}
+	return matched, excluded
+}
+
+func compile(line string) (Pattern, bool, error) {
+	raw := line
+	line = trimTrailingSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Pattern{}, false, nil
+	}
+
+	pattern := Pattern{raw: raw}
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return Pattern{}, false, nil
+	}
+
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr, err := translate(line)
+	if err != nil {
+		return Pattern{}, false, fmt.Errorf("invalid pattern %q: %w", raw, err)
+	}
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return Pattern{}, false, fmt.Errorf("invalid pattern %q: %w", raw, err)
+	}
+	pattern.re = re
+	return pattern, true, nil
+}
+
+// translate converts a gitignore glob into a regular expression fragment.
+func translate(glob string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				j := i + 2
+				atEnd := j == len(glob) || glob[j] == '/'
+				if atStart && atEnd {
+					switch {
+					case j == len(glob):
+						b.WriteString(".*")
+					default:
+						b.WriteString("(?:.*/)?")
+						j++
+					}
+					i = j - 1
+					continue
+				}
+				b.WriteString("[^/]*")
+				i++
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if class == "" {
+				return "", errors.New("empty character class")
+			}
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
+
+// trimTrailingSpace removes unescaped trailing spaces.
+func trimTrailingSpace(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-2] + " "
+	}
+	return line
+}
@@ -0,0 +1,149 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"unanchored at base", []string{"*.log"}, "/repo/a.log", false, true},
+		{"unanchored below base", []string{"*.log"}, "/repo/a/b/a.log", false, true},
+		{"no partial name match", []string{"*.log"}, "/repo/a.logx", false, false},
+		{"outside base", []string{"*.log"}, "/other/a.log", false, false},
+		{"base itself", []string{"*"}, "/repo", true, false},
+
+		{"negation", []string{"*.log", "!keep.log"}, "/repo/keep.log", false, false},
+		{"negation leaves others", []string{"*.log", "!keep.log"}, "/repo/drop.log", false, true},
+		{"last match wins", []string{"!keep.log", "*.log"}, "/repo/keep.log", false, true},
+		{"escaped bang", []string{`\!bang`}, "/repo/!bang", false, true},
+		{"escaped hash", []string{`\#hash`}, "/repo/#hash", false, true},
+		{"comment", []string{"#hash"}, "/repo/#hash", false, false},
+
+		{"directory only matches directory", []string{"build/"}, "/repo/build", true, true},
+		{"directory only skips file", []string{"build/"}, "/repo/build", false, false},
+		{"directory only below base", []string{"build/"}, "/repo/a/build", true, true},
+
+		{"leading slash at base", []string{"/root.txt"}, "/repo/root.txt", false, true},
+		{"leading slash below base", []string{"/root.txt"}, "/repo/a/root.txt", false, false},
+		{"inner slash anchors", []string{"doc/*.txt"}, "/repo/doc/a.txt", false, true},
+		{"inner slash below base", []string{"doc/*.txt"}, "/repo/a/doc/a.txt", false, false},
+		{"star stays in segment", []string{"doc/*.txt"}, "/repo/doc/a/b.txt", false, false},
+
+		{"leading ** at base", []string{"**/foo"}, "/repo/foo", false, true},
+		{"leading ** below base", []string{"**/foo"}, "/repo/a/b/foo", false, true},
+		{"trailing ** contents", []string{"abc/**"}, "/repo/abc/x/y", false, true},
+		{"trailing ** not directory", []string{"abc/**"}, "/repo/abc", true, false},
+		{"inner ** no directories", []string{"a/**/b"}, "/repo/a/b", false, true},
+		{"inner ** directories", []string{"a/**/b"}, "/repo/a/x/y/b", false, true},
+		{"inner ** anchored", []string{"a/**/b"}, "/repo/c/a/b", false, false},
+		{"inner ** separator", []string{"a/**/b"}, "/repo/a/xb", false, false},
+		{"** inside segment", []string{"foo**bar"}, "/repo/foo/bar", false, false},
+
+		{"question mark", []string{"a?c"}, "/repo/abc", false, true},
+		{"question mark separator", []string{"a?c"}, "/repo/a/c", false, false},
+		{"character class", []string{"[ab]c"}, "/repo/bc", false, true},
+		{"negated character class", []string{"[!a]bc"}, "/repo/abc", false, false},
+
+		{"trailing spaces trimmed", []string{"space   "}, "/repo/space", false, true},
+		{"escaped trailing space kept", []string{`trail\ `}, "/repo/trail ", false, true},
+		{"escaped trailing space required", []string{`trail\ `}, "/repo/trail", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Parse("/repo", tt.lines)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.lines, err)
+			}
+			if got := NewMatcher(rules).Match(tt.path, tt.isDir); got != tt.want {
+				t.Fatalf("Match(%s, %t) with %q = %t, want %t", tt.path, tt.isDir, tt.lines, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	rules, err := Parse("/repo", []string{"", "   ", "# comment", "!", "/", "*.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rules.Len(); got != 1 {
+		t.Fatalf("Len() = %d, want 1", got)
+	}
+	if _, err := Parse("/repo", []string{"[]"}); err == nil {
+		t.Fatal("Parse([]) succeeded, want an error")
+	}
+}
+
+func TestMatcherWith(t *testing.T) {
+	outer, err := Parse("/repo", []string{"*.log", "tmp/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := Parse("/repo/sub", []string{"!debug.log", "/cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := NewMatcher(nil, outer).With(nil).With(inner)
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"/repo/debug.log", false, true},
+		{"/repo/sub/debug.log", false, false},
+		{"/repo/sub/deeper/debug.log", false, false},
+		{"/repo/sub/other.log", false, true},
+		{"/repo/sub/tmp", true, true},
+		{"/repo/cache", true, false},
+		{"/repo/sub/cache", true, true},
+		{"/repo/sub/deeper/cache", true, false},
+	}
+	for _, tt := range tests {
+		if got := matcher.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Match(%s, %t) = %t, want %t", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	// The outer matcher is unaffected by the rules stacked on top of it.
+	if !NewMatcher(outer).Match("/repo/sub/debug.log", false) {
+		t.Error("outer matcher does not exclude /repo/sub/debug.log")
+	}
+
+	var empty *Matcher
+	if empty.Match("/repo/a.log", false) {
+		t.Error("nil matcher excludes /repo/a.log")
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	dir := t.TempDir()
+	rules, err := ParseFile(dir, ".ignore")
+	if err != nil || rules != nil {
+		t.Fatalf("ParseFile of a missing file = %v, %v, want nil, nil", rules, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".ignore"), []byte("# cache\n*.tmp\n!keep.tmp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = ParseFile(dir, ".ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := NewMatcher(rules)
+	if !matcher.Match(filepath.Join(dir, "a", "b.tmp"), false) {
+		t.Error("b.tmp is not excluded")
+	}
+	if matcher.Match(filepath.Join(dir, "keep.tmp"), false) {
+		t.Error("keep.tmp is excluded")
+	}
+}
@@ -0,0 +1,202 @@
+package indexer
+
+import (
+	"context"
+	"maps"
+	"path/filepath"
+	"sync"
+
+	"seekfile/internal/storage"
+)
+
+// DefaultBatchSize is the number of writes a scan groups into one
+// transaction when no size is configured.
+const DefaultBatchSize = 500
+
+// recordWriter persists record changes made while indexing.
+type recordWriter interface {
+	// save writes record together with its text for the full-text index,
+	// unless text is nil.
+	save(ctx context.Context, record FileRecord, kind ChangeKind, text *fileText) error
+	remove(ctx context.Context, path string) error
+	move(ctx context.Context, from string, record FileRecord) error
+}
+
+// directWriter applies every change immediately.
+type directWriter struct {
+	idx    *Indexer
+	origin changeOrigin
+}
+
+func (w directWriter) save(ctx context.Context, record FileRecord, kind ChangeKind, text *fileText) error {
+	return w.idx.saveRecord(ctx, record, kind, text, w.origin)
+}
+
+func (w directWriter) remove(ctx context.Context, path string) error {
+	return w.idx.deleteRecord(ctx, path, w.origin)
+}
+
+func (w directWriter) move(ctx context.Context, from string, record FileRecord) error {
+	return w.idx.moveRecord(ctx, from, record, w.origin)
+}
+
+type batchOp struct {
+	record    FileRecord
+	text      *fileText
+	delete    string
+	from      string
+	change    Change
+	scanState *storage.ScanState
+}
+
+// batchWriter buffers changes and writes them in store transactions of up to
+// size operations, together with their change log entries. The in-memory
+// index is only updated, and change handlers only notified, once a batch has
+// been committed, so neither sees changes that were rolled back. It is safe
+// for concurrent use. After a failed batch every further write fails too, so
+// a scan state can never be committed for data that was dropped.
+type batchWriter struct {
+	idx    *Indexer
+	size   int
+	origin changeOrigin
+
+	mu  sync.Mutex
+	ops []batchOp
+	err error
+	// committed counts the committed changes by kind.
+	committed map[ChangeKind]int64
+}
+
+func (idx *Indexer) newBatchWriter(origin changeOrigin) *batchWriter {
+	return &batchWriter{idx: idx, size: idx.batchSize, origin: origin, committed: make(map[ChangeKind]int64)}
+}
+
+// changeCounts returns the number of committed changes of each kind.
+func (w *batchWriter) changeCounts() map[ChangeKind]int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return maps.Clone(w.committed)
+}
+
+func (w *batchWriter) save(ctx context.Context, record FileRecord, kind ChangeKind, text *fileText) error {
+	record.Path = filepath.Clean(record.Path)
+	return w.add(ctx, batchOp{record: record, text: text, change: w.origin.change(kind, record.Path, "")})
+}
+
+func (w *batchWriter) remove(ctx context.Context, path string) error {
+	path = filepath.Clean(path)
+	return w.add(ctx, batchOp{delete: path, change: w.origin.change(ChangeDeleted, path, "")})
+}
+
+func (w *batchWriter) move(ctx context.Context, from string, record FileRecord) error {
+	record.Path = filepath.Clean(record.Path)
+	from = filepath.Clean(from)
+	return w.add(ctx, batchOp{record: record, from: from, change: w.origin.change(ChangeMoved, record.Path, from)})
+}
+
+// updateScanState records a root's scan state. It is written in the same
+// transaction as, and after, every change queued before it.
+func (w *batchWriter) updateScanState(ctx context.Context, state storage.ScanState) error {
+	return w.add(ctx, batchOp{scanState: &state})
+}
+
+func (w *batchWriter) add(ctx context.Context, op batchOp) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.ops = append(w.ops, op)
+	if len(w.ops) < w.size {
+		return nil
+	}
+	return w.flushLocked(ctx)
+}
+
+// flush writes every queued change.
+func (w *batchWriter) flush(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flushLocked(ctx)
+}
+
+// flushLocked commits the queued operations as one transaction. A batch that
+// has started is always finished, even if ctx is cancelled meanwhile, so a
+// cancelled scan keeps the batches it completed. If any write fails the
+// transaction is rolled back and the batch is dropped.
+func (w *batchWriter) flushLocked(ctx context.Context) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.ops) == 0 {
+		return nil
+	}
+	ops := w.ops
+	w.ops = nil
+
+	if w.idx.store != nil {
+		if err := w.commit(context.WithoutCancel(ctx), ops); err != nil {
+			w.err = err
+			return err
+		}
+	}
+
+	if w.idx.memory {
+		w.idx.mu.Lock()
+		for _, op := range ops {
+			switch {
+			case op.scanState != nil:
+			case op.delete != "":
+				w.idx.removeFile(op.delete)
+			case op.from != "":
+				w.idx.removeFile(op.from)
+				w.idx.putFile(op.record)
+			default:
+				w.idx.putFile(op.record)
+			}
+		}
+		w.idx.mu.Unlock()
+	}
+
+	for _, op := range ops {
+		if op.scanState == nil && op.change.Kind != changeNone {
+			w.committed[op.change.Kind]++
+			w.idx.notify(op.change)
+		}
+	}
+	return nil
+}
+
+func (w *batchWriter) commit(ctx context.Context, ops []batchOp) error {
+	batch, err := w.idx.store.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		switch {
+		case op.scanState != nil:
+			err = batch.UpdateScanState(ctx, *op.scanState)
+		case op.delete != "":
+			err = batch.Delete(ctx, op.delete)
+		case op.from != "":
+			err = batch.Move(ctx, op.from, toStorageRecord(op.record))
+		default:
+			err = batch.Upsert(ctx, toStorageRecord(op.record))
+			if err == nil && op.text != nil {
+				err = writeText(ctx, batch, op.record, op.text)
+			}
+		}
+		if err == nil && op.scanState == nil && op.change.Kind != changeNone && w.idx.changeLog != nil {
+			err = batch.AppendChange(ctx, toStorageChange(op.change))
+		}
+		if err != nil {
+			batch.Rollback()
+			return err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		batch.Rollback()
+		return err
+	}
+	return nil
+}
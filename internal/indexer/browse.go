@@ -0,0 +1,357 @@
+package indexer
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+// ErrDirectoryNotFound is returned by Browse for a path that is neither a
+// scan root nor a directory holding indexed files.
+var ErrDirectoryNotFound = errors.New("directory not found in the index")
+
+// DirectoryStats aggregates the indexed files beneath a directory at any
+// depth.
+type DirectoryStats struct {
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	RootPath string    `json:"rootPath"`
+	Files    int       `json:"files"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modified"`
+}
+
+// BrowseQuery selects a page of the entries of a directory. Child
+// directories come first, followed by the files directly inside it; both are
+// ordered by SortField, which is one of name, size or modified.
+type BrowseQuery struct {
+	// Path is the directory to list. An empty path lists the scan roots.
+	Path           string
+	SortField      string
+	SortDescending bool
+	Offset         int
+	Limit          int
+	// Roots restricts browsing to these scan roots. Nil allows every root.
+	Roots []string
+}
+
+// BrowseResult is a page of a directory listing.
+type BrowseResult struct {
+	Directory   DirectoryStats
+	Directories []DirectoryStats
+	Files       []FileRecord
+	// Total counts the child directories and files before paging.
+	Total int
+}
+
+// Browse lists the child directories and files of an indexed directory.
+// Directories only exist in the index while they hold files, so empty
+// directories are not listed.
+func (idx *Indexer) Browse(ctx context.Context, query BrowseQuery) (BrowseResult, error) {
+	roots := idx.Roots()
+	if query.Roots != nil {
+		allowed := rootSet(query.Roots)
+		kept := roots[:0]
+		for _, root := range roots {
+			if _, ok := allowed[root]; ok {
+				kept = append(kept, root)
+			}
+		}
+		roots = kept
+	}
+
+	var (
+		result   BrowseResult
+		children = []DirectoryStats{}
+	)
+	if query.Path == "" {
+		for _, root := range roots {
+			stats, _, err := idx.directoryStats(ctx, root)
+			if err != nil {
+				return BrowseResult{}, err
+			}
+			stats.Path, stats.Name, stats.RootPath = root, filepath.Base(root), root
+			children = append(children, stats)
+		}
+	} else {
+		path := filepath.Clean(query.Path)
+		root, ok := rootOf(roots, path)
+		if !ok {
+			return BrowseResult{}, ErrDirectoryNotFound
+		}
+		stats, found, err := idx.directoryStats(ctx, path)
+		if err != nil {
+			return BrowseResult{}, err
+		}
+		if !found && path != root {
+			return BrowseResult{}, ErrDirectoryNotFound
+		}
+		stats.Path, stats.Name, stats.RootPath = path, filepath.Base(path), root
+		result.Directory = stats
+
+		if children, err = idx.subdirectories(ctx, path); err != nil {
+			return BrowseResult{}, err
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return lessDirectories(children[i], children[j], query)
+	})
+	offset := min(max(query.Offset, 0), len(children))
+	end := len(children)
+	if query.Limit > 0 {
+		end = min(offset+query.Limit, end)
+	}
+	result.Directories = children[offset:end]
+	result.Total = len(children)
+
+	if query.Path == "" {
+		result.Files = []FileRecord{}
+		return result, nil
+	}
+
+	// A page filled by directories still needs the number of files.
+	files := Query{
+		SortField:      browseSortField(query.SortField),
+		SortDescending: query.SortDescending,
+		Offset:         max(query.Offset-len(children), 0),
+		Dir:            result.Directory.Path,
+	}
+	var full bool
+	if query.Limit > 0 {
+		files.Limit = query.Limit - len(result.Directories)
+		if full = files.Limit == 0; full {
+			files.Limit = 1
+		}
+	}
+	page, err := idx.Search(ctx, files)
+	if err != nil {
+		return BrowseResult{}, err
+	}
+	result.Files = page.Files
+	if full {
+		result.Files = []FileRecord{}
+	}
+	result.Total += page.Total
+	return result, nil
+}
+
+// directoryStats returns the aggregates of an indexed directory.
+func (idx *Indexer) directoryStats(ctx context.Context, path string) (DirectoryStats, bool, error) {
+	if !idx.memory {
+		dir, ok, err := idx.query.Directory(ctx, path)
+		return fromStorageDirectory(dir), ok, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	stats, ok := idx.dirs.stats(path, idx.files)
+	return stats, ok, nil
+}
+
+// subdirectories returns the aggregates of the indexed directories directly
+// inside path.
+func (idx *Indexer) subdirectories(ctx context.Context, path string) ([]DirectoryStats, error) {
+	if !idx.memory {
+		dirs, err := idx.query.Subdirectories(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		children := make([]DirectoryStats, 0, len(dirs))
+		for _, dir := range dirs {
+			children = append(children, fromStorageDirectory(dir))
+		}
+		return children, nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	node, ok := idx.dirs.nodes[path]
+	if !ok {
+		return []DirectoryStats{}, nil
+	}
+	children := make([]DirectoryStats, 0, len(node.children))
+	for child := range node.children {
+		if stats, ok := idx.dirs.stats(child, idx.files); ok {
+			children = append(children, stats)
+		}
+	}
+	return children, nil
+}
+
+func fromStorageDirectory(dir storage.Directory) DirectoryStats {
+	return DirectoryStats{
+		Path:     dir.Path,
+		Name:     filepath.Base(dir.Path),
+		RootPath: dir.RootPath,
+		Files:    dir.Files,
+		Size:     dir.Size,
+		ModTime:  dir.ModTime,
+	}
+}
+
+// rootOf returns the scan root containing path.
+func rootOf(roots []string, path string) (string, bool) {
+	for _, root := range roots {
+		if withinDir(root, path) {
+			return root, true
+		}
+	}
+	return "", false
+}
+
+func browseSortField(field string) string {
+	switch field = normalizeSortField(field); field {
+	case "size", "modified":
+		return field
+	default:
+		return "name"
+	}
+}
+
+// lessDirectories orders directories like lessRecords orders files.
+func lessDirectories(a, b DirectoryStats, query BrowseQuery) bool {
+	var order int
+	switch browseSortField(query.SortField) {
+	case "size":
+		order = cmp.Compare(a.Size, b.Size)
+	case "modified":
+		order = a.ModTime.Compare(b.ModTime)
+	}
+	if order == 0 {
+		order = compareNoCase(a.Name, b.Name)
+		if order == 0 {
+			order = strings.Compare(a.Path, b.Path)
+		}
+	}
+	if query.SortDescending {
+		return order > 0
+	}
+	return order < 0
+}
+
+// dirTree aggregates the in-memory records by directory. Every directory
+// from a file's parent up to its scan root has a node while it holds files.
+type dirTree struct {
+	nodes map[string]*dirNode
+}
+
+type dirNode struct {
+	files    map[string]struct{}
+	children map[string]struct{}
+	root     string
+	count    int
+	size     int64
+	latest   time.Time
+	// stale is set when a file as recent as latest was removed; latest is
+	// recomputed on the next read.
+	stale bool
+}
+
+func newDirTree() *dirTree {
+	return &dirTree{nodes: make(map[string]*dirNode)}
+}
+
+// add counts a record in its parent directory and every ancestor up to its
+// root.
+func (t *dirTree) add(record FileRecord) {
+	child, file := record.Path, true
+	for dir := filepath.Dir(record.Path); ; dir = filepath.Dir(dir) {
+		node, ok := t.nodes[dir]
+		if !ok {
+			node = &dirNode{
+				files:    make(map[string]struct{}),
+				children: make(map[string]struct{}),
+				root:     record.RootPath,
+			}
+			t.nodes[dir] = node
+		}
+		if file {
+			node.files[child] = struct{}{}
+		} else {
+			node.children[child] = struct{}{}
+		}
+		node.count++
+		node.size += record.Size
+		if record.ModTime.After(node.latest) {
+			node.latest = record.ModTime
+		}
+		if dir == record.RootPath || filepath.Dir(dir) == dir {
+			return
+		}
+		child, file = dir, false
+	}
+}
+
+// remove uncounts a record added before, dropping directories left without
+// files.
+func (t *dirTree) remove(record FileRecord) {
+	child, file, empty := record.Path, true, false
+	for dir := filepath.Dir(record.Path); ; dir = filepath.Dir(dir) {
+		node, ok := t.nodes[dir]
+		if !ok {
+			return
+		}
+		if file {
+			delete(node.files, child)
+		} else if empty {
+			delete(node.children, child)
+		}
+		node.count--
+		node.size -= record.Size
+		if !record.ModTime.Before(node.latest) {
+			node.stale = true
+		}
+		if empty = node.count <= 0; empty {
+			delete(t.nodes, dir)
+		}
+		if dir == record.RootPath || filepath.Dir(dir) == dir {
+			return
+		}
+		child, file = dir, false
+	}
+}
+
+// stats returns the aggregates of a directory, recomputing stale times from
+// files.
+func (t *dirTree) stats(path string, files map[string]FileRecord) (DirectoryStats, bool) {
+	node, ok := t.nodes[path]
+	if !ok {
+		return DirectoryStats{}, false
+	}
+	return DirectoryStats{
+		Path:     path,
+		Name:     filepath.Base(path),
+		RootPath: node.root,
+		Files:    node.count,
+		Size:     node.size,
+		ModTime:  t.latest(node, files),
+	}, true
+}
+
+func (t *dirTree) latest(node *dirNode, files map[string]FileRecord) time.Time {
+	if !node.stale {
+		return node.latest
+	}
+	var latest time.Time
+	for path := range node.files {
+		if modTime := files[path].ModTime; modTime.After(latest) {
+			latest = modTime
+		}
+	}
+	for path := range node.children {
+		if child, ok := t.nodes[path]; ok {
+			if modTime := t.latest(child, files); modTime.After(latest) {
+				latest = modTime
+			}
+		}
+	}
+	node.latest, node.stale = latest, false
+	return latest
+}
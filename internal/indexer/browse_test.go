@@ -0,0 +1,136 @@
+package indexer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// browseNames returns the names of the directories and files of a listing.
+func browseNames(result BrowseResult) (dirs, files []string) {
+	dirs, files = []string{}, []string{}
+	for _, dir := range result.Directories {
+		dirs = append(dirs, dir.Name)
+	}
+	for _, file := range result.Files {
+		files = append(files, file.Name)
+	}
+	return dirs, files
+}
+
+func TestBrowse(t *testing.T) {
+	stored, roots := newTestIndexer(t, 1)
+	root := roots[0]
+	memory, err := New(roots, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"a.txt":           "1",
+		"big.bin":         "0123456789",
+		"docs/x.md":       "333",
+		"docs/deep/y.md":  "55555",
+		"pics/photo.png":  "4444",
+		"pics/.empty/z.t": "",
+	}
+	for name, content := range files {
+		writeFile(t, filepath.Join(root, name), content)
+	}
+	latest := testTime.Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "docs/deep/y.md"), latest, latest); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, idx := range map[string]*Indexer{"memory": memory, "store": stored} {
+		t.Run(name, func(t *testing.T) {
+			scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+			browse := func(query BrowseQuery) BrowseResult {
+				t.Helper()
+				result, err := idx.Browse(t.Context(), query)
+				if err != nil {
+					t.Fatalf("Browse(%+v): %v", query, err)
+				}
+				return result
+			}
+
+			result := browse(BrowseQuery{})
+			if len(result.Directories) != 1 || result.Directories[0].Path != root || result.Directories[0].Files != 6 || len(result.Files) != 0 {
+				t.Fatalf("listing of the roots = %+v, want the root with six files", result)
+			}
+
+			// Directories come first and aggregate the files beneath them at
+			// any depth.
+			result = browse(BrowseQuery{Path: root})
+			dirs, names := browseNames(result)
+			if !slices.Equal(dirs, []string{"docs", "pics"}) || !slices.Equal(names, []string{"a.txt", "big.bin"}) || result.Total != 4 {
+				t.Fatalf("listing of the root = %v %v (total %d), want [docs pics] [a.txt big.bin] (total 4)", dirs, names, result.Total)
+			}
+			if dir := result.Directory; dir.Files != 6 || dir.Size != 23 || !dir.ModTime.Equal(latest) {
+				t.Errorf("root stats = %+v, want six files of 23 bytes modified at %s", dir, latest)
+			}
+			if docs := result.Directories[0]; docs.Files != 2 || docs.Size != 8 || !docs.ModTime.Equal(latest) || docs.RootPath != root {
+				t.Errorf("docs stats = %+v, want two files of 8 bytes modified at %s", docs, latest)
+			}
+
+			result = browse(BrowseQuery{Path: root, SortField: "size", SortDescending: true})
+			if dirs, names := browseNames(result); !slices.Equal(dirs, []string{"docs", "pics"}) || !slices.Equal(names, []string{"big.bin", "a.txt"}) {
+				t.Errorf("listing by size, descending = %v %v", dirs, names)
+			}
+
+			// Pages continue from the directories into the files.
+			for _, tc := range []struct {
+				offset, limit int
+				dirs, files   []string
+			}{
+				{0, 2, []string{"docs", "pics"}, []string{}},
+				{0, 3, []string{"docs", "pics"}, []string{"a.txt"}},
+				{1, 2, []string{"pics"}, []string{"a.txt"}},
+				{3, 3, []string{}, []string{"big.bin"}},
+				{5, 3, []string{}, []string{}},
+			} {
+				result := browse(BrowseQuery{Path: root, Offset: tc.offset, Limit: tc.limit})
+				dirs, names := browseNames(result)
+				if !slices.Equal(dirs, tc.dirs) || !slices.Equal(names, tc.files) || result.Total != 4 {
+					t.Errorf("page at %d of %d = %v %v (total %d), want %v %v (total 4)", tc.offset, tc.limit, dirs, names, result.Total, tc.dirs, tc.files)
+				}
+			}
+
+			result = browse(BrowseQuery{Path: filepath.Join(root, "pics")})
+			if dirs, names := browseNames(result); !slices.Equal(dirs, []string{".empty"}) || !slices.Equal(names, []string{"photo.png"}) {
+				t.Errorf("listing of pics = %v %v", dirs, names)
+			}
+
+			for _, query := range []BrowseQuery{
+				{Path: filepath.Join(root, "missing")},
+				{Path: filepath.Dir(root)},
+				{Path: root, Roots: []string{}},
+			} {
+				if _, err := idx.Browse(t.Context(), query); !errors.Is(err, ErrDirectoryNotFound) {
+					t.Errorf("Browse(%+v): err = %v, want ErrDirectoryNotFound", query, err)
+				}
+			}
+		})
+	}
+
+	// Directories disappear with their last file.
+	if err := os.RemoveAll(filepath.Join(root, "pics")); err != nil {
+		t.Fatal(err)
+	}
+	for name, idx := range map[string]*Indexer{"memory": memory, "store": stored} {
+		scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+		result, err := idx.Browse(t.Context(), BrowseQuery{Path: root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dirs, _ := browseNames(result); !slices.Equal(dirs, []string{"docs"}) || result.Directory.Files != 4 {
+			t.Errorf("%s listing after pics was removed = %v with %d files", name, dirs, result.Directory.Files)
+		}
+		if _, err := idx.Browse(t.Context(), BrowseQuery{Path: filepath.Join(root, "pics")}); !errors.Is(err, ErrDirectoryNotFound) {
+			t.Errorf("%s: browsing the removed directory: err = %v", name, err)
+		}
+	}
+}
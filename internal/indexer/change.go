@@ -0,0 +1,235 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+const (
+	// DefaultChangeRetention is how long change log entries are kept when no
+	// retention is configured.
+	DefaultChangeRetention = 30 * 24 * time.Hour
+	// changePruneInterval bounds how often the change log is pruned outside
+	// of scans.
+	changePruneInterval = time.Hour
+)
+
+// ErrChangeLogDisabled is returned when the change feed is read while the
+// change log is not enabled.
+var ErrChangeLogDisabled = errors.New("change log is disabled")
+
+// ChangeKind classifies a change applied to the index.
+type ChangeKind string
+
+const (
+	// ChangeCreated marks a file that was added to the index.
+	ChangeCreated ChangeKind = "created"
+	// ChangeModified marks a file whose size or modification time changed.
+	ChangeModified ChangeKind = "modified"
+	// ChangeMoved marks a file that was renamed or moved; OldPath holds its
+	// previous location.
+	ChangeMoved ChangeKind = "moved"
+	// ChangeDeleted marks a file that was removed from the index.
+	ChangeDeleted ChangeKind = "deleted"
+
+	// changeNone is used for writes that only refresh derived data, such as
+	// hashes, and are not reported.
+	changeNone ChangeKind = ""
+)
+
+// ChangeCause identifies what triggered a change.
+type ChangeCause string
+
+const (
+	// ChangeCauseScan marks changes found by a full or incremental scan.
+	ChangeCauseScan ChangeCause = "scan"
+	// ChangeCauseWatch marks changes reported by the filesystem watcher.
+	ChangeCauseWatch ChangeCause = "watch"
+	// ChangeCauseAPI marks changes made through UpdateFile and RemoveFile.
+	ChangeCauseAPI ChangeCause = "api"
+)
+
+// Change describes a single file change applied to the index.
+type Change struct {
+	// ID is assigned by the change log; it is zero for changes that were not
+	// read from it.
+	ID      int64       `json:"id,omitempty"`
+	Kind    ChangeKind  `json:"kind"`
+	Path    string      `json:"path"`
+	OldPath string      `json:"oldPath,omitempty"`
+	Cause   ChangeCause `json:"cause"`
+	ScanID  int64       `json:"scanId,omitempty"`
+	Time    time.Time   `json:"time"`
+}
+
+// ChangeQuery selects a page of the change feed. Cursor continues from the
+// NextCursor of a previous page; otherwise the feed starts at Since.
+type ChangeQuery struct {
+	Since  time.Time
+	Cursor string
+	// Roots, when not nil, keeps only changes to paths within these roots.
+	Roots []string
+	Limit int
+}
+
+// ChangePage is a page of the change feed.
+type ChangePage struct {
+	Changes []Change
+	// NextCursor resumes after the last change of this page. When the page is
+	// empty it repeats the cursor of the query, so it can always be stored
+	// and used for the next poll.
+	NextCursor string
+	HasMore    bool
+}
+
+// ChangeStore is implemented by stores that can persist the change log.
+type ChangeStore interface {
+	AppendChanges(ctx context.Context, changes []storage.Change) error
+	Changes(ctx context.Context, query storage.ChangeQuery) ([]storage.Change, error)
+	PruneChanges(ctx context.Context, before time.Time, maxEntries int) (int64, error)
+}
+
+// ChangeLogOptions configures the persistent change log.
+type ChangeLogOptions struct {
+	// Retention is how long entries are kept. Zero selects
+	// DefaultChangeRetention.
+	Retention time.Duration
+	// MaxEntries caps the number of entries kept; zero means no cap.
+	MaxEntries int
+}
+
+type changeLog struct {
+	store      ChangeStore
+	retention  time.Duration
+	maxEntries int
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// changeOrigin records what caused the writes of a recordWriter.
+type changeOrigin struct {
+	cause  ChangeCause
+	scanID int64
+}
+
+func (o changeOrigin) change(kind ChangeKind, path, oldPath string) Change {
+	return Change{Kind: kind, Path: path, OldPath: oldPath, Cause: o.cause, ScanID: o.scanID, Time: time.Now()}
+}
+
+// ChangeLogEnabled reports whether the persistent change log is enabled.
+func (idx *Indexer) ChangeLogEnabled() bool {
+	return idx.changeLog != nil
+}
+
+// Changes returns a page of the persistent change log in the order the
+// changes were applied.
+func (idx *Indexer) Changes(ctx context.Context, query ChangeQuery) (ChangePage, error) {
+	if idx.changeLog == nil {
+		return ChangePage{}, ErrChangeLogDisabled
+	}
+
+	q := storage.ChangeQuery{Since: query.Since, Roots: query.Roots}
+	if query.Cursor != "" {
+		after, err := strconv.ParseInt(query.Cursor, 10, 64)
+		if err != nil || after < 0 {
+			return ChangePage{}, ErrInvalidCursor
+		}
+		q.AfterID = after
+		q.Since = time.Time{}
+	}
+	if query.Limit > 0 {
+		q.Limit = query.Limit + 1
+	}
+
+	stored, err := idx.changeLog.store.Changes(ctx, q)
+	if err != nil {
+		return ChangePage{}, err
+	}
+
+	page := ChangePage{NextCursor: query.Cursor}
+	if query.Limit > 0 && len(stored) > query.Limit {
+		stored = stored[:query.Limit]
+		page.HasMore = true
+	}
+	page.Changes = make([]Change, 0, len(stored))
+	for _, change := range stored {
+		page.Changes = append(page.Changes, fromStorageChange(change))
+	}
+	if len(stored) > 0 {
+		page.NextCursor = strconv.FormatInt(stored[len(stored)-1].ID, 10)
+	}
+	return page, nil
+}
+
+// recordChange logs a change written outside of a batch and reports it to
+// the change handlers. Failing to log the change does not undo it.
+func (idx *Indexer) recordChange(ctx context.Context, change Change) error {
+	if change.Kind == changeNone {
+		return nil
+	}
+	var err error
+	if idx.changeLog != nil {
+		err = idx.changeLog.store.AppendChanges(ctx, []storage.Change{toStorageChange(change)})
+		idx.pruneChanges(ctx, false)
+	}
+	idx.notify(change)
+	return err
+}
+
+// notify reports a change to every registered handler. It is called after the
+// change has been persisted.
+func (idx *Indexer) notify(change Change) {
+	if change.Kind == changeNone {
+		return
+	}
+	for _, handler := range idx.changeHandlers {
+		handler(change)
+	}
+}
+
+// pruneChanges applies the change log retention. Unless force is set it runs
+// at most once per changePruneInterval.
+func (idx *Indexer) pruneChanges(ctx context.Context, force bool) error {
+	log := idx.changeLog
+	if log == nil {
+		return nil
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if !force && time.Since(log.lastPruned) < changePruneInterval {
+		return nil
+	}
+	log.lastPruned = time.Now()
+	_, err := log.store.PruneChanges(ctx, time.Now().Add(-log.retention), log.maxEntries)
+	return err
+}
+
+func toStorageChange(change Change) storage.Change {
+	return storage.Change{
+		ID:      change.ID,
+		Time:    change.Time,
+		Kind:    string(change.Kind),
+		Path:    change.Path,
+		OldPath: change.OldPath,
+		Cause:   string(change.Cause),
+		ScanID:  change.ScanID,
+	}
+}
+
+func fromStorageChange(change storage.Change) Change {
+	return Change{
+		ID:      change.ID,
+		Time:    change.Time,
+		Kind:    ChangeKind(change.Kind),
+		Path:    change.Path,
+		OldPath: change.OldPath,
+		Cause:   ChangeCause(change.Cause),
+		ScanID:  change.ScanID,
+	}
+}
@@ -0,0 +1,168 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// changeKinds returns "kind name" for each change, naming files by base name.
+func changeKinds(changes []Change) []string {
+	kinds := make([]string, 0, len(changes))
+	for _, change := range changes {
+		kinds = append(kinds, fmt.Sprintf("%s %s", change.Kind, filepath.Base(change.Path)))
+	}
+	return kinds
+}
+
+// readChanges pages through the change feed from cursor, limit changes at a
+// time, and returns the changes along with the cursor to poll with next.
+func readChanges(t *testing.T, idx *Indexer, cursor string, limit int) ([]Change, string) {
+	t.Helper()
+	var changes []Change
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatal("the change feed does not end")
+		}
+		result, err := idx.Changes(t.Context(), ChangeQuery{Cursor: cursor, Limit: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Changes) > limit || (result.HasMore && len(result.Changes) != limit) {
+			t.Fatalf("page of %d changes with more to come, limit %d", len(result.Changes), limit)
+		}
+		changes = append(changes, result.Changes...)
+		cursor = result.NextCursor
+		if !result.HasMore {
+			return changes, cursor
+		}
+	}
+}
+
+func TestChangeFeedPaging(t *testing.T) {
+	idx, roots := newTestIndexer(t, 1, WithChangeLog(ChangeLogOptions{}))
+	root := roots[0]
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		writeFile(t, filepath.Join(root, name), name)
+	}
+	first := scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	changes, cursor := readChanges(t, idx, "", 2)
+	if len(changes) != 5 {
+		t.Fatalf("first scan logged %v, want five creations", changeKinds(changes))
+	}
+	for i, change := range changes {
+		if change.Kind != ChangeCreated || change.Cause != ChangeCauseScan || change.ScanID != first.ID {
+			t.Errorf("change %d = %+v, want a creation by scan %d", i, change, first.ID)
+		}
+		if i > 0 && change.ID <= changes[i-1].ID {
+			t.Errorf("change %d has ID %d after %d", i, change.ID, changes[i-1].ID)
+		}
+	}
+
+	// Polling at the end of the feed returns no changes and the same cursor.
+	page, err := idx.Changes(t.Context(), ChangeQuery{Cursor: cursor, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 0 || page.HasMore || page.NextCursor != cursor {
+		t.Fatalf("poll at the end = %+v, want an empty page with cursor %s", page, cursor)
+	}
+
+	// The next poll picks up exactly the changes made since.
+	since := time.Now()
+	writeFile(t, filepath.Join(root, "a.txt"), "longer")
+	if err := os.Remove(filepath.Join(root, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "c.txt"), filepath.Join(root, "f.txt")); err != nil {
+		t.Fatal(err)
+	}
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	changes, _ = readChanges(t, idx, cursor, 1)
+	got := changeKinds(changes)
+	slices.Sort(got)
+	if want := []string{"deleted b.txt", "modified a.txt", "moved f.txt"}; !slices.Equal(got, want) {
+		t.Fatalf("second scan logged %v, want %v", got, want)
+	}
+
+	// Without a cursor the feed starts at Since.
+	page, err = idx.Changes(t.Context(), ChangeQuery{Since: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 3 || page.HasMore {
+		t.Fatalf("changes since the second scan = %v, want its three changes", changeKinds(page.Changes))
+	}
+
+	for _, cursor := range []string{"x", "-1"} {
+		if _, err := idx.Changes(t.Context(), ChangeQuery{Cursor: cursor}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Changes with cursor %q: err = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestChangeFeedRoots(t *testing.T) {
+	idx, roots := newTestIndexer(t, 2, WithChangeLog(ChangeLogOptions{}))
+	for _, root := range roots {
+		for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+			writeFile(t, filepath.Join(root, name), name)
+		}
+	}
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	// Pages are filled from the changes within the roots.
+	page, err := idx.Changes(t.Context(), ChangeQuery{Roots: roots[1:], Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := idx.Changes(t.Context(), ChangeQuery{Cursor: page.NextCursor, Roots: roots[1:], Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 2 || !page.HasMore || len(next.Changes) != 1 || next.HasMore {
+		t.Fatalf("pages of the second root = %v and %v, want two changes and then one", changeKinds(page.Changes), changeKinds(next.Changes))
+	}
+	for _, change := range append(page.Changes, next.Changes...) {
+		if filepath.Dir(change.Path) != roots[1] {
+			t.Errorf("change to %s listed for %s", change.Path, roots[1])
+		}
+	}
+
+	page, err = idx.Changes(t.Context(), ChangeQuery{Roots: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 0 {
+		t.Errorf("changes within no roots = %v, want none", changeKinds(page.Changes))
+	}
+}
+
+func TestChangeLogMaxEntries(t *testing.T) {
+	idx, roots := newTestIndexer(t, 1, WithChangeLog(ChangeLogOptions{MaxEntries: 3}))
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		writeFile(t, filepath.Join(roots[0], name), name)
+	}
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	// Scans prune the log when they end, keeping the newest entries.
+	page, err := idx.Changes(t.Context(), ChangeQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 3 {
+		t.Fatalf("change log holds %v, want the three newest changes", changeKinds(page.Changes))
+	}
+}
+
+func TestChangeLogDisabled(t *testing.T) {
+	idx, _ := newTestIndexer(t, 1)
+	if _, err := idx.Changes(t.Context(), ChangeQuery{}); !errors.Is(err, ErrChangeLogDisabled) {
+		t.Fatalf("Changes without a change log: err = %v, want ErrChangeLogDisabled", err)
+	}
+}
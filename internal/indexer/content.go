@@ -0,0 +1,242 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"seekfile/internal/storage"
+)
+
+const (
+	// DefaultContentMaxFileSize caps the size of files whose text is indexed.
+	DefaultContentMaxFileSize = 1 << 20
+	// contentHitLimit bounds the number of full-text hits considered per search.
+	contentHitLimit = 1000
+	// binarySniffLength is how many leading bytes are inspected for NUL bytes
+	// when deciding whether a file is text.
+	binarySniffLength = 8000
+)
+
+// DefaultContentExtensions lists the file types whose text is indexed when no
+// explicit list is configured.
+var DefaultContentExtensions = []string{
+	".txt", ".md", ".markdown", ".rst", ".log", ".csv", ".tsv",
+	".json", ".xml", ".html", ".htm", ".yaml", ".yml", ".toml", ".ini", ".cfg", ".conf", ".properties",
+	".go", ".py", ".js", ".jsx", ".ts", ".tsx", ".java", ".kt", ".c", ".h", ".cc", ".cpp", ".hpp",
+	".cs", ".rs", ".rb", ".php", ".sh", ".bash", ".sql", ".css", ".scss", ".vue", ".swift", ".lua", ".pl", ".r", ".tex",
+}
+
+// markupExtensions are stripped of tags before indexing.
+var markupExtensions = map[string]struct{}{
+	".html": {},
+	".htm":  {},
+	".xml":  {},
+}
+
+var markupTag = regexp.MustCompile(`(?s)<!--.*?-->|<[^>]*>`)
+
+// ErrContentSearchDisabled is returned when a content query is issued while
+// content indexing is not enabled.
+var ErrContentSearchDisabled = errors.New("content indexing is disabled")
+
+// ContentStore is implemented by stores that can hold a full-text index of
+// file contents. Their batches must implement ContentBatch.
+type ContentStore interface {
+	// SearchContent returns up to limit hits, best first, among the records
+	// matching filter. The sort and paging fields of filter are ignored.
+	SearchContent(ctx context.Context, match string, filter storage.Query, limit int) ([]storage.ContentHit, error)
+}
+
+// ContentBatch writes the full-text index within a batch, so that the text of
+// a file is committed or rolled back together with its record.
+type ContentBatch interface {
+	UpsertContent(ctx context.Context, path, name, body string) error
+	DeleteContent(ctx context.Context, path string) error
+}
+
+// fileText is the text extracted from a file for the full-text index. A file
+// that is not eligible has none, which clears any text stored for its path.
+type fileText struct {
+	body string
+	ok   bool
+}
+
+// ContentOptions configures full-text content indexing.
+type ContentOptions struct {
+	// MaxFileSize skips files larger than this many bytes. Zero selects
+	// DefaultContentMaxFileSize.
+	MaxFileSize int64
+	// Extensions restricts extraction to these file extensions. Empty selects
+	// DefaultContentExtensions.
+	Extensions []string
+}
+
+type contentIndexer struct {
+	store       ContentStore
+	maxFileSize int64
+	extensions  map[string]struct{}
+}
+
+// ContentSearchEnabled reports whether content indexing is enabled.
+func (idx *Indexer) ContentSearchEnabled() bool {
+	return idx.content != nil
+}
+
+// needsContent reports whether a record still has to go through text
+// extraction.
+func (idx *Indexer) needsContent(record FileRecord) bool {
+	return idx.content != nil && !record.ContentIndexed
+}
+
+// storeFile persists a new or changed record through w, extracting its text
+// first when content indexing is enabled; the text is written in the same
+// transaction as the record. kind is reported once the record has been
+// written.
+func (idx *Indexer) storeFile(ctx context.Context, w recordWriter, record FileRecord, kind ChangeKind) error {
+	var text *fileText
+	if idx.needsContent(record) {
+		text = idx.content.extractText(&record)
+	}
+	return w.save(ctx, record, kind, text)
+}
+
+// extractText reads the text of an eligible file and marks the record as
+// processed. Ineligible or unreadable files are marked as well, so they are
+// not revisited until they change.
+func (c *contentIndexer) extractText(record *FileRecord) *fileText {
+	body, ok := c.extract(record.Path, record.Name, record.Size)
+	record.ContentIndexed = true
+	return &fileText{body: body, ok: ok}
+}
+
+// writeText stores or clears the text of record in batch.
+func writeText(ctx context.Context, batch storage.Batch, record FileRecord, text *fileText) error {
+	contents, ok := batch.(ContentBatch)
+	if !ok {
+		return errors.New("content indexing requires a store whose batches support full-text writes")
+	}
+	if text.ok {
+		return contents.UpsertContent(ctx, record.Path, record.Name, text.body)
+	}
+	return contents.DeleteContent(ctx, record.Path)
+}
+
+func (c *contentIndexer) extract(path, name string, size int64) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if _, ok := c.extensions[ext]; !ok {
+		return "", false
+	}
+	if size > c.maxFileSize {
+		return "", false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, c.maxFileSize))
+	if err != nil {
+		return "", false
+	}
+
+	sniff := data
+	if len(sniff) > binarySniffLength {
+		sniff = sniff[:binarySniffLength]
+	}
+	if bytes.IndexByte(sniff, 0) >= 0 {
+		return "", false
+	}
+
+	text := string(data)
+	if !utf8.ValidString(text) {
+		text = strings.ToValidUTF8(text, " ")
+	}
+	if _, ok := markupExtensions[ext]; ok {
+		text = html.UnescapeString(markupTag.ReplaceAllString(text, " "))
+	}
+	return text, true
+}
+
+// searchContent runs a full-text query over the records matching filter and
+// returns the best contentHitLimit hits keyed by path. truncated reports that
+// more records matched.
+func (idx *Indexer) searchContent(ctx context.Context, input string, filter storage.Query) (hits map[string]storage.ContentHit, truncated bool, err error) {
+	if idx.content == nil {
+		return nil, false, ErrContentSearchDisabled
+	}
+
+	match := buildContentMatch(input)
+	if match == "" {
+		return map[string]storage.ContentHit{}, false, nil
+	}
+
+	found, err := idx.content.store.SearchContent(ctx, match, filter, contentHitLimit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(found) > contentHitLimit {
+		found, truncated = found[:contentHitLimit], true
+	}
+
+	hits = make(map[string]storage.ContentHit, len(found))
+	for _, hit := range found {
+		hits[filepath.Clean(hit.Path)] = hit
+	}
+	return hits, truncated, nil
+}
+
+// buildContentMatch turns free-form user input into an FTS5 expression in
+// which every word must match. A trailing * requests a prefix match.
+func buildContentMatch(input string) string {
+	terms := make([]string, 0)
+	for _, field := range strings.Fields(input) {
+		prefix := strings.HasSuffix(field, "*")
+		field = strings.TrimRight(field, "*")
+		if field == "" {
+			continue
+		}
+		term := `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// renderSnippet converts a store snippet into HTML in which highlighted terms
+// are wrapped in <mark> and everything else is escaped.
+func renderSnippet(snippet string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(snippet, storage.SnippetStart)
+		if start < 0 {
+			b.WriteString(html.EscapeString(snippet))
+			return b.String()
+		}
+		b.WriteString(html.EscapeString(snippet[:start]))
+		snippet = snippet[start+len(storage.SnippetStart):]
+
+		end := strings.Index(snippet, storage.SnippetEnd)
+		if end < 0 {
+			end = len(snippet)
+		}
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(snippet[:end]))
+		b.WriteString("</mark>")
+		if end == len(snippet) {
+			return b.String()
+		}
+		snippet = snippet[end+len(storage.SnippetEnd):]
+	}
+}
@@ -0,0 +1,165 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+func TestBuildContentMatch(t *testing.T) {
+	for _, tc := range []struct{ input, want string }{
+		{"", ""},
+		{"  ", ""},
+		{"quick", `"quick"`},
+		{"quick brown", `"quick" "brown"`},
+		{"qui*", `"qui"*`},
+		{"* **", ""},
+		{`say "hi"`, `"say" """hi"""`},
+		{"NEAR(a b)", `"NEAR(a" "b)"`},
+	} {
+		if got := buildContentMatch(tc.input); got != tc.want {
+			t.Errorf("buildContentMatch(%q) = %s, want %s", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestRenderSnippet(t *testing.T) {
+	mark := func(s string) string { return storage.SnippetStart + s + storage.SnippetEnd }
+	for _, tc := range []struct{ snippet, want string }{
+		{"plain", "plain"},
+		{"a < b", "a &lt; b"},
+		{"the " + mark("quick") + " fox", "the <mark>quick</mark> fox"},
+		{mark("<b>") + " and " + mark("x"), "<mark>&lt;b&gt;</mark> and <mark>x</mark>"},
+		{"cut " + storage.SnippetStart + "off", "cut <mark>off</mark>"},
+	} {
+		if got := renderSnippet(tc.snippet); got != tc.want {
+			t.Errorf("renderSnippet(%q) = %s, want %s", tc.snippet, got, tc.want)
+		}
+	}
+}
+
+// contentPaths returns the base names of the files whose text matches
+// content, sorted.
+func contentPaths(t *testing.T, idx *Indexer, content string) []string {
+	t.Helper()
+	var names []string
+	for _, path := range searchPaths(t, idx, Query{Content: content}) {
+		names = append(names, filepath.Base(path))
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestContentSearch(t *testing.T) {
+	idx, roots := newTestIndexer(t, 1, WithContentIndexing(ContentOptions{MaxFileSize: 64}))
+	root := roots[0]
+	files := map[string]string{
+		"notes.md":  "the quick brown fox",
+		"page.html": "<p>quick &amp; <b>lazy</b> dog</p>",
+		"other.txt": "brown bear",
+		"big.txt":   "quick " + strings.Repeat("filler ", 20),
+		"null.txt":  "quick\x00fox",
+		"image.png": "quick fox",
+	}
+	for name, content := range files {
+		writeFile(t, filepath.Join(root, name), content)
+	}
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	// Only text files with an indexed extension and within the size limit
+	// are searchable; every word must match, and markup is reduced to its
+	// text.
+	for _, tc := range []struct {
+		content string
+		want    []string
+	}{
+		{"quick", []string{"notes.md", "page.html"}},
+		{"QUICK brown", []string{"notes.md"}},
+		{"bro*", []string{"notes.md", "other.txt"}},
+		{"lazy dog", []string{"page.html"}},
+		{"amp", nil},
+		{"fox", []string{"notes.md"}},
+	} {
+		if got := contentPaths(t, idx, tc.content); !slices.Equal(got, tc.want) {
+			t.Errorf("content search for %q = %v, want %v", tc.content, got, tc.want)
+		}
+	}
+
+	result, err := idx.Search(t.Context(), Query{Content: "brown", NamePattern: "notes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 || !strings.Contains(result.Files[0].Snippet, "<mark>brown</mark>") || result.Files[0].Score <= 0 {
+		t.Fatalf("content search with a name filter = %+v, want notes.md with a highlighted snippet", result.Files)
+	}
+
+	// Changed files are indexed again and removed files drop out.
+	notes := filepath.Join(root, "notes.md")
+	writeFile(t, notes, "a slow green turtle")
+	if err := os.Chtimes(notes, testTime.Add(time.Hour), testTime.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "other.txt")); err != nil {
+		t.Fatal(err)
+	}
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+	for _, tc := range []struct {
+		content string
+		want    []string
+	}{
+		{"quick", []string{"page.html"}},
+		{"turtle", []string{"notes.md"}},
+		{"brown", nil},
+	} {
+		if got := contentPaths(t, idx, tc.content); !slices.Equal(got, tc.want) {
+			t.Errorf("content search for %q after changes = %v, want %v", tc.content, got, tc.want)
+		}
+	}
+}
+
+func TestContentSearchDisabled(t *testing.T) {
+	idx, _ := newTestIndexer(t, 1)
+	if _, err := idx.Search(t.Context(), Query{Content: "quick"}); !errors.Is(err, ErrContentSearchDisabled) {
+		t.Fatalf("content search without content indexing: err = %v, want ErrContentSearchDisabled", err)
+	}
+}
+
+func TestContentSearchLimitAppliesAfterFilters(t *testing.T) {
+	idx, roots := newTestIndexer(t, 2, WithContentIndexing(ContentOptions{}))
+	// The files of the first root all outrank the one of the second.
+	for i := range contentHitLimit + 1 {
+		writeFile(t, filepath.Join(roots[0], fmt.Sprintf("%04d.txt", i)), "needle needle")
+	}
+	mine := filepath.Join(roots[1], "mine.txt")
+	writeFile(t, mine, "a needle lost among "+strings.Repeat("hay ", 50))
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	result, err := idx.Search(t.Context(), Query{Content: "needle", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Truncated || result.Total != contentHitLimit {
+		t.Fatalf("search of every root: truncated %t, total %d, want truncated at %d", result.Truncated, result.Total, contentHitLimit)
+	}
+
+	for filter, query := range map[string]Query{
+		"roots": {Content: "needle", Roots: roots[1:]},
+		"dir":   {Content: "needle", Dir: roots[1]},
+		"size":  {Content: "needle", MinSize: 100},
+	} {
+		result, err := idx.Search(t.Context(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Truncated || result.Total != 1 || result.Files[0].Path != mine {
+			t.Errorf("search filtered by %s: truncated %t, total %d, want only %s", filter, result.Truncated, result.Total, mine)
+		}
+	}
+}
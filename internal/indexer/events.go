@@ -0,0 +1,140 @@
+package indexer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// statusPublishInterval bounds how often progress snapshots are sent to
+	// status subscribers.
+	statusPublishInterval = 250 * time.Millisecond
+	// statusEventBuffer is the number of events queued for a subscriber
+	// before the oldest ones are dropped.
+	statusEventBuffer = 16
+)
+
+// StatusEventType classifies a StatusEvent.
+type StatusEventType string
+
+const (
+	// StatusEventProgress carries a periodic snapshot while the status
+	// changes, for example during a scan or when the watcher applies events.
+	StatusEventProgress StatusEventType = "progress"
+	// StatusEventScanStarted is sent when a scan starts.
+	StatusEventScanStarted StatusEventType = "scan-started"
+	// StatusEventScanFinished is sent when a scan completes successfully.
+	StatusEventScanFinished StatusEventType = "scan-finished"
+	// StatusEventScanFailed is sent when a scan ends with an error or is
+	// cancelled.
+	StatusEventScanFailed StatusEventType = "scan-failed"
+)
+
+// StatusEvent is published to status subscribers.
+type StatusEvent struct {
+	Type   StatusEventType `json:"type"`
+	Status ScanStatus      `json:"status"`
+	// Job is the job that started or ended for scan lifecycle events.
+	Job *ScanJob `json:"job,omitempty"`
+}
+
+// statusHub fans status events out to subscribers. Progress snapshots are
+// coalesced so that at most one is sent per statusPublishInterval, however
+// often the status changes; scan lifecycle events are sent immediately.
+type statusHub struct {
+	mu          sync.Mutex
+	subscribers map[chan StatusEvent]struct{}
+	lastSent    time.Time
+	// pending and listeners let statusChanged, which scans call for every
+	// file, return without taking mu while a snapshot is already scheduled
+	// or nobody listens.
+	pending   atomic.Bool
+	listeners atomic.Int32
+}
+
+// SubscribeStatus returns a channel that receives status events and a
+// function that ends the subscription and closes the channel. Subscribers
+// that fall behind lose their oldest events.
+func (idx *Indexer) SubscribeStatus() (<-chan StatusEvent, func()) {
+	hub := &idx.statusHub
+	ch := make(chan StatusEvent, statusEventBuffer)
+
+	hub.mu.Lock()
+	if hub.subscribers == nil {
+		hub.subscribers = make(map[chan StatusEvent]struct{})
+	}
+	hub.subscribers[ch] = struct{}{}
+	hub.listeners.Add(1)
+	hub.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			hub.mu.Lock()
+			delete(hub.subscribers, ch)
+			hub.listeners.Add(-1)
+			close(ch)
+			hub.mu.Unlock()
+		})
+	}
+}
+
+// statusChanged schedules a progress snapshot for subscribers.
+func (idx *Indexer) statusChanged() {
+	hub := &idx.statusHub
+	if hub.pending.Load() || hub.listeners.Load() == 0 {
+		return
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.pending.Load() || len(hub.subscribers) == 0 {
+		return
+	}
+	hub.pending.Store(true)
+	delay := statusPublishInterval - time.Since(hub.lastSent)
+	time.AfterFunc(max(delay, 0), func() {
+		status := idx.Status()
+		hub.mu.Lock()
+		hub.pending.Store(false)
+		hub.lastSent = time.Now()
+		hub.broadcast(StatusEvent{Type: StatusEventProgress, Status: status})
+		hub.mu.Unlock()
+	})
+}
+
+// publishStatus sends a lifecycle event of job with the current status right
+// away.
+func (idx *Indexer) publishStatus(eventType StatusEventType, job *ScanJob) {
+	hub := &idx.statusHub
+	hub.mu.Lock()
+	subscribed := len(hub.subscribers) > 0
+	hub.mu.Unlock()
+	if !subscribed {
+		return
+	}
+
+	status := idx.Status()
+	hub.mu.Lock()
+	hub.broadcast(StatusEvent{Type: eventType, Status: status, Job: job})
+	hub.mu.Unlock()
+}
+
+// broadcast delivers event to every subscriber, dropping a subscriber's
+// oldest queued event when its buffer is full. The caller must hold hub.mu.
+func (hub *statusHub) broadcast(event StatusEvent) {
+	for ch := range hub.subscribers {
+		for {
+			select {
+			case ch <- event:
+			default:
+				select {
+				case <-ch:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+// FacetValue counts the matches of a search that share a value.
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Facets summarises every match of a search, before pagination.
+type Facets struct {
+	// Extensions are lower-case with the leading dot; files without an
+	// extension are counted under "".
+	Extensions []FacetValue `json:"extensions"`
+	Roots      []FacetValue `json:"roots"`
+	// Sizes and Modified use the bucket names of SizeBuckets and
+	// ModifiedBuckets, in that order.
+	Sizes    []FacetValue `json:"sizes"`
+	Modified []FacetValue `json:"modified"`
+}
+
+// SizeBucket is a size range; Max is exclusive and zero means unbounded.
+type SizeBucket struct {
+	Name string
+	Min  int64
+	Max  int64
+}
+
+// SizeBuckets are the size ranges counted by Facets.Sizes.
+var SizeBuckets = []SizeBucket{
+	{Name: "tiny", Max: 1 << 10},
+	{Name: "small", Min: 1 << 10, Max: 1 << 20},
+	{Name: "medium", Min: 1 << 20, Max: 100 << 20},
+	{Name: "large", Min: 100 << 20, Max: 1 << 30},
+	{Name: "huge", Min: 1 << 30},
+}
+
+// ModifiedBucket is a range of file ages; MaxAge is exclusive and zero means
+// unbounded.
+type ModifiedBucket struct {
+	Name   string
+	MinAge time.Duration
+	MaxAge time.Duration
+}
+
+// ModifiedBuckets are the age ranges counted by Facets.Modified.
+var ModifiedBuckets = []ModifiedBucket{
+	{Name: "day", MaxAge: 24 * time.Hour},
+	{Name: "week", MinAge: 24 * time.Hour, MaxAge: 7 * 24 * time.Hour},
+	{Name: "month", MinAge: 7 * 24 * time.Hour, MaxAge: 30 * 24 * time.Hour},
+	{Name: "year", MinAge: 30 * 24 * time.Hour, MaxAge: 365 * 24 * time.Hour},
+	{Name: "older", MinAge: 365 * 24 * time.Hour},
+}
+
+// ApplySizeBucket narrows the size range of a query to the named bucket.
+func (q *Query) ApplySizeBucket(name string) bool {
+	for _, bucket := range SizeBuckets {
+		if bucket.Name != name {
+			continue
+		}
+		q.MinSize = max(q.MinSize, bucket.Min)
+		if bucket.Max > 0 && (q.MaxSize == 0 || q.MaxSize > bucket.Max-1) {
+			q.MaxSize = bucket.Max - 1
+		}
+		return true
+	}
+	return false
+}
+
+// ApplyModifiedBucket narrows the modification time range of a query to the
+// named bucket, measured from now.
+func (q *Query) ApplyModifiedBucket(name string, now time.Time) bool {
+	for _, bucket := range ModifiedBuckets {
+		if bucket.Name != name {
+			continue
+		}
+		if bucket.MaxAge > 0 {
+			after := now.Add(-bucket.MaxAge)
+			if after.After(q.ModifiedAfter) {
+				q.ModifiedAfter = after
+			}
+		}
+		if bucket.MinAge > 0 {
+			before := now.Add(-bucket.MinAge)
+			if q.ModifiedBefore.IsZero() || before.Before(q.ModifiedBefore) {
+				q.ModifiedBefore = before
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// facetCounter accumulates facets over records held in memory.
+type facetCounter struct {
+	now        time.Time
+	extensions map[string]*FacetValue
+	roots      map[string]*FacetValue
+	sizes      []FacetValue
+	modified   []FacetValue
+}
+
+func newFacetCounter(now time.Time) *facetCounter {
+	c := &facetCounter{
+		now:        now,
+		extensions: make(map[string]*FacetValue),
+		roots:      make(map[string]*FacetValue),
+		sizes:      make([]FacetValue, len(SizeBuckets)),
+		modified:   make([]FacetValue, len(ModifiedBuckets)),
+	}
+	for i, bucket := range SizeBuckets {
+		c.sizes[i].Value = bucket.Name
+	}
+	for i, bucket := range ModifiedBuckets {
+		c.modified[i].Value = bucket.Name
+	}
+	return c
+}
+
+func (c *facetCounter) add(record FileRecord) {
+	count := func(values map[string]*FacetValue, key string) {
+		value, ok := values[key]
+		if !ok {
+			value = &FacetValue{Value: key}
+			values[key] = value
+		}
+		value.Count++
+		value.Bytes += record.Size
+	}
+	count(c.extensions, strings.ToLower(filepath.Ext(record.Name)))
+	count(c.roots, record.RootPath)
+
+	if i := sizeBucketIndex(record.Size); i >= 0 {
+		c.sizes[i].Count++
+		c.sizes[i].Bytes += record.Size
+	}
+	if i := modifiedBucketIndex(c.now.Sub(record.ModTime)); i >= 0 {
+		c.modified[i].Count++
+		c.modified[i].Bytes += record.Size
+	}
+}
+
+func (c *facetCounter) facets() *Facets {
+	return &Facets{
+		Extensions: sortedFacetValues(c.extensions),
+		Roots:      sortedFacetValues(c.roots),
+		Sizes:      c.sizes,
+		Modified:   c.modified,
+	}
+}
+
+func sortedFacetValues(values map[string]*FacetValue) []FacetValue {
+	sorted := make([]FacetValue, 0, len(values))
+	for _, value := range values {
+		sorted = append(sorted, *value)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+	return sorted
+}
+
+func sizeBucketIndex(size int64) int {
+	for i, bucket := range SizeBuckets {
+		if size >= bucket.Min && (bucket.Max == 0 || size < bucket.Max) {
+			return i
+		}
+	}
+	return -1
+}
+
+func modifiedBucketIndex(age time.Duration) int {
+	for i, bucket := range ModifiedBuckets {
+		if age >= bucket.MinAge && (bucket.MaxAge == 0 || age < bucket.MaxAge) {
+			return i
+		}
+	}
+	// Files dated in the future count as modified today.
+	if age < 0 {
+		return 0
+	}
+	return -1
+}
+
+// recordFacets counts facets over matches held in memory.
+func recordFacets(matches []FileRecord, now time.Time) *Facets {
+	counter := newFacetCounter(now)
+	for _, record := range matches {
+		counter.add(record)
+	}
+	return counter.facets()
+}
+
+// storeFacets has the store aggregate the matches of a query.
+func (idx *Indexer) storeFacets(ctx context.Context, query Query, now time.Time) (*Facets, error) {
+	sizeEdges := make([]int64, 0, len(SizeBuckets)-1)
+	for _, bucket := range SizeBuckets[1:] {
+		sizeEdges = append(sizeEdges, bucket.Min)
+	}
+	// Modification time edges ascend, so they run from the oldest bucket to
+	// the newest.
+	timeEdges := make([]time.Time, 0, len(ModifiedBuckets)-1)
+	for i := len(ModifiedBuckets) - 1; i > 0; i-- {
+		timeEdges = append(timeEdges, now.Add(-ModifiedBuckets[i].MinAge))
+	}
+
+	stored, err := idx.query.Facets(ctx, storeQuery(query, nil), sizeEdges, timeEdges)
+	if err != nil {
+		return nil, err
+	}
+
+	facets := &Facets{
+		Extensions: fromStorageFacetValues(stored.Extensions),
+		Roots:      fromStorageFacetValues(stored.Roots),
+		Sizes:      make([]FacetValue, len(SizeBuckets)),
+		Modified:   make([]FacetValue, len(ModifiedBuckets)),
+	}
+	for i, bucket := range SizeBuckets {
+		facets.Sizes[i] = FacetValue{Value: bucket.Name, Count: stored.Sizes[i].Count, Bytes: stored.Sizes[i].Bytes}
+	}
+	last := len(ModifiedBuckets) - 1
+	for i, bucket := range ModifiedBuckets {
+		count := stored.Modified[last-i]
+		facets.Modified[i] = FacetValue{Value: bucket.Name, Count: count.Count, Bytes: count.Bytes}
+	}
+	return facets, nil
+}
+
+func fromStorageFacetValues(values []storage.FacetValue) []FacetValue {
+	converted := make([]FacetValue, 0, len(values))
+	for _, value := range values {
+		converted = append(converted, FacetValue{Value: value.Value, Count: value.Count, Bytes: value.Bytes})
+	}
+	sort.SliceStable(converted, func(i, j int) bool {
+		if converted[i].Count != converted[j].Count {
+			return converted[i].Count > converted[j].Count
+		}
+		return converted[i].Value < converted[j].Value
+	})
+	return converted
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package indexer
+
+import "io/fs"
+
+func identityOf(fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
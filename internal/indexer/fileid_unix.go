@@ -0,0 +1,18 @@
+//go:build unix
+
+package indexer
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// identityOf returns the device and inode numbers of the file described by
+// info.
+func identityOf(info fs.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{device: uint64(stat.Dev), inode: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package indexer
+
+import "golang.org/x/sys/unix"
+
+// isNetworkFS reports whether path lives on a network filesystem.
+func isNetworkFS(path string) bool {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return false
+	}
+	switch uint32(stat.Type) {
+	case unix.NFS_SUPER_MAGIC, unix.SMB_SUPER_MAGIC, unix.SMB2_SUPER_MAGIC, unix.CIFS_SUPER_MAGIC,
+		unix.AFS_SUPER_MAGIC, unix.AFS_FS_MAGIC, unix.CEPH_SUPER_MAGIC, unix.CODA_SUPER_MAGIC, unix.V9FS_MAGIC:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package indexer
+
+func isNetworkFS(string) bool {
+	return false
+}
@@ -0,0 +1,335 @@
+package indexer
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"seekfile/internal/querylang"
+)
+
+// fuzzyCandidateLimit bounds the names a store returns for a fuzzy search.
+const fuzzyCandidateLimit = 2000
+
+// Scores of the ways a word can match a name, best first. Typos and
+// subsequences score below every literal match.
+const (
+	scoreExact     = 1.0
+	scorePrefix    = 0.9
+	scoreWordStart = 0.8
+	scoreSubstring = 0.7
+	scoreTypo      = 0.6
+	scoreTypoStep  = 0.1
+	scoreScattered = 0.3
+)
+
+// fuzzyTerms takes the plain name terms of a query's top-level conjunction
+// as the words of a fuzzy search and returns the rest of the query, which
+// still filters exactly.
+func fuzzyTerms(expr querylang.Expr) ([]string, querylang.Expr) {
+	var operands []querylang.Expr
+	switch e := expr.(type) {
+	case nil:
+		return nil, nil
+	case querylang.And:
+		operands = e
+	default:
+		operands = []querylang.Expr{expr}
+	}
+
+	var (
+		words []string
+		rest  querylang.And
+	)
+	for _, operand := range operands {
+		if text, ok := operand.(querylang.Text); ok && text.Field == querylang.FieldName && text.Mode == querylang.Contains {
+			words = append(words, strings.Fields(text.Value)...)
+			continue
+		}
+		rest = append(rest, operand)
+	}
+	switch len(rest) {
+	case 0:
+		return words, nil
+	case 1:
+		return words, rest[0]
+	default:
+		return words, rest
+	}
+}
+
+// fuzzyScore rates how well a name matches every word, from 0 for no match
+// to 1 when each word is the whole name.
+func fuzzyScore(words []string, name string) float64 {
+	lower := strings.ToLower(name)
+	stem := strings.TrimSuffix(lower, filepath.Ext(lower))
+	tokens := nameTokens(lower)
+
+	var total float64
+	for _, word := range words {
+		score := wordScore(word, lower, stem, tokens)
+		if score == 0 {
+			return 0
+		}
+		total += score
+	}
+	return total / float64(len(words))
+}
+
+func wordScore(word, name, stem string, tokens []string) float64 {
+	switch {
+	case word == name || word == stem:
+		return scoreExact
+	case strings.HasPrefix(name, word):
+		return scorePrefix
+	}
+	for _, token := range tokens {
+		if strings.HasPrefix(token, word) {
+			return scoreWordStart
+		}
+	}
+	if strings.Contains(name, word) {
+		return scoreSubstring
+	}
+
+	if limit := maxEdits(word); limit > 0 {
+		best := limit + 1
+		for _, token := range tokens {
+			best = min(best, editDistance(word, token, limit))
+		}
+		if best <= limit {
+			return scoreTypo - scoreTypoStep*float64(best-1)
+		}
+	}
+
+	if span := subsequenceSpan(word, name); span > 0 {
+		return scoreScattered * float64(len(word)) / float64(span)
+	}
+	return 0
+}
+
+// maxEdits is the number of typos tolerated in a word of this length.
+func maxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// nameTokens splits a lower-case name into words at punctuation and at
+// changes between letters and digits.
+func nameTokens(name string) []string {
+	var tokens []string
+	start := -1
+	var prevDigit bool
+	for i, r := range name {
+		alnum := unicode.IsLetter(r) || unicode.IsDigit(r)
+		digit := unicode.IsDigit(r)
+		if start >= 0 && (!alnum || digit != prevDigit) {
+			tokens = append(tokens, name[start:i])
+			start = -1
+		}
+		if alnum && start < 0 {
+			start = i
+		}
+		prevDigit = digit
+	}
+	if start >= 0 {
+		tokens = append(tokens, name[start:])
+	}
+	return tokens
+}
+
+// editDistance is the optimal string alignment distance between a and b,
+// counting insertions, deletions, substitutions and transpositions. Any
+// distance above limit is reported as limit+1.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > limit {
+		return limit + 1
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return min(prev[len(rb)], limit+1)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// subsequenceSpan returns the length of the shortest stretch of s that
+// contains the letters of word in order, or 0 if there is none.
+func subsequenceSpan(word, s string) int {
+	w := []rune(word)
+	r := []rune(s)
+	if len(w) < 2 {
+		return 0
+	}
+	best := 0
+	for start := range r {
+		if r[start] != w[0] {
+			continue
+		}
+		k := 1
+		end := start + 1
+		for ; end < len(r) && k < len(w); end++ {
+			if r[end] == w[k] {
+				k++
+			}
+		}
+		if k < len(w) {
+			break
+		}
+		if span := end - start; best == 0 || span < best {
+			best = span
+		}
+	}
+	return best
+}
+
+// trigrams returns the distinct rune trigrams of a lower-case string, each
+// packed into a uint64.
+func trigrams(s string) []uint64 {
+	r := []rune(s)
+	if len(r) < 3 {
+		return nil
+	}
+	seen := make(map[uint64]struct{}, len(r)-2)
+	grams := make([]uint64, 0, len(r)-2)
+	for i := 0; i+3 <= len(r); i++ {
+		gram := uint64(r[i])<<42 | uint64(r[i+1])<<21 | uint64(r[i+2])
+		if _, ok := seen[gram]; ok {
+			continue
+		}
+		seen[gram] = struct{}{}
+		grams = append(grams, gram)
+	}
+	return grams
+}
+
+// nameIndex is an in-memory trigram index over file names that finds the
+// candidates of a fuzzy search without scanning every record. Removed
+// entries are only marked and are dropped when the index is compacted.
+type nameIndex struct {
+	ids     map[string]uint32
+	paths   []string
+	names   []string
+	grams   map[uint64][]uint32
+	removed int
+}
+
+func newNameIndex() *nameIndex {
+	return &nameIndex{
+		ids:   make(map[string]uint32),
+		grams: make(map[uint64][]uint32),
+	}
+}
+
+func (n *nameIndex) add(path, name string) {
+	name = strings.ToLower(name)
+	if id, ok := n.ids[path]; ok {
+		if n.names[id] == name {
+			return
+		}
+		n.remove(path)
+	}
+	id := uint32(len(n.paths))
+	n.ids[path] = id
+	n.paths = append(n.paths, path)
+	n.names = append(n.names, name)
+	for _, gram := range trigrams(name) {
+		n.grams[gram] = append(n.grams[gram], id)
+	}
+}
+
+func (n *nameIndex) remove(path string) {
+	id, ok := n.ids[path]
+	if !ok {
+		return
+	}
+	delete(n.ids, path)
+	n.paths[id] = ""
+	n.removed++
+	if n.removed > 1024 && n.removed > len(n.ids) {
+		n.compact()
+	}
+}
+
+func (n *nameIndex) compact() {
+	fresh := newNameIndex()
+	for id, path := range n.paths {
+		if path != "" {
+			fresh.add(path, n.names[id])
+		}
+	}
+	*n = *fresh
+}
+
+// candidates returns the paths whose names share enough trigrams with every
+// word to be within its edit limit. ok is false when a word is too short to
+// be looked up, in which case every name is a candidate.
+func (n *nameIndex) candidates(words []string) (paths []string, ok bool) {
+	var matched map[uint32]struct{}
+	for _, word := range words {
+		grams := trigrams(word)
+		if len(grams) == 0 {
+			return nil, false
+		}
+		// Each typo breaks at most three trigrams.
+		need := max(1, len(grams)-3*maxEdits(word))
+
+		counts := make(map[uint32]int)
+		for _, gram := range grams {
+			for _, id := range n.grams[gram] {
+				counts[id]++
+			}
+		}
+		next := make(map[uint32]struct{})
+		for id, count := range counts {
+			if count < need || n.paths[id] == "" {
+				continue
+			}
+			if _, ok := matched[id]; matched == nil || ok {
+				next[id] = struct{}{}
+			}
+		}
+		matched = next
+	}
+
+	paths = make([]string, 0, len(matched))
+	for id := range matched {
+		paths = append(paths, n.paths[id])
+	}
+	return paths, true
+}
@@ -0,0 +1,325 @@
+package indexer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+// partialHashSpan is the number of bytes read from both the head and the tail
+// of a file when computing its partial hash.
+const partialHashSpan = 64 * 1024
+
+// DuplicateQuery selects which duplicate groups are returned.
+type DuplicateQuery struct {
+	MinSize int64
+	// Roots restricts groups to files of these scan roots; see Query.Roots.
+	Roots  []string
+	Offset int
+	Limit  int
+}
+
+// DuplicateGroup lists files with identical contents.
+type DuplicateGroup struct {
+	Hash        string       `json:"hash"`
+	Size        int64        `json:"size"`
+	Count       int          `json:"count"`
+	WastedBytes int64        `json:"wastedBytes"`
+	Files       []FileRecord `json:"files"`
+}
+
+// DuplicateResult describes the outcome of a duplicate lookup.
+type DuplicateResult struct {
+	Groups      []DuplicateGroup
+	TotalGroups int
+	TotalFiles  int
+	WastedBytes int64
+}
+
+// HashingEnabled reports whether content hashing is enabled.
+func (idx *Indexer) HashingEnabled() bool {
+	return idx.hashContents
+}
+
+// Duplicates groups indexed files by content hash. Groups are ordered by the
+// space they waste, largest first; totals cover every group before paging.
+func (idx *Indexer) Duplicates(ctx context.Context, query DuplicateQuery) (DuplicateResult, error) {
+	if query.Roots != nil && len(query.Roots) == 0 {
+		return DuplicateResult{Groups: []DuplicateGroup{}}, nil
+	}
+	if !idx.memory {
+		return idx.storeDuplicates(ctx, query)
+	}
+
+	allowedRoots := rootSet(query.Roots)
+
+	idx.mu.RLock()
+	byHash := make(map[string][]FileRecord)
+	for _, record := range idx.files {
+		if ctx.Err() != nil {
+			break
+		}
+		if record.Hash == "" || record.Size < query.MinSize {
+			continue
+		}
+		if allowedRoots != nil {
+			if _, ok := allowedRoots[record.RootPath]; !ok {
+				continue
+			}
+		}
+		byHash[record.Hash] = append(byHash[record.Hash], record)
+	}
+	idx.mu.RUnlock()
+
+	result := DuplicateResult{}
+	groups := make([]DuplicateGroup, 0)
+	for hash, files := range byHash {
+		if len(files) < 2 {
+			continue
+		}
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Path < files[j].Path
+		})
+		size := files[0].Size
+		wasted := size * int64(len(files)-1)
+		groups = append(groups, DuplicateGroup{
+			Hash:        hash,
+			Size:        size,
+			Count:       len(files),
+			WastedBytes: wasted,
+			Files:       files,
+		})
+		result.TotalFiles += len(files)
+		result.WastedBytes += wasted
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].WastedBytes != groups[j].WastedBytes {
+			return groups[i].WastedBytes > groups[j].WastedBytes
+		}
+		return groups[i].Hash < groups[j].Hash
+	})
+
+	result.TotalGroups = len(groups)
+
+	offset := query.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(groups) {
+		offset = len(groups)
+	}
+	end := len(groups)
+	if query.Limit > 0 && offset+query.Limit < end {
+		end = offset + query.Limit
+	}
+	result.Groups = groups[offset:end]
+
+	return result, nil
+}
+
+func (idx *Indexer) storeDuplicates(ctx context.Context, query DuplicateQuery) (DuplicateResult, error) {
+	offset := query.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	stored, summary, err := idx.query.DuplicateGroups(ctx, query.MinSize, query.Roots, offset, query.Limit)
+	if err != nil {
+		return DuplicateResult{}, err
+	}
+
+	groups := make([]DuplicateGroup, 0, len(stored))
+	for _, group := range stored {
+		files := make([]FileRecord, 0, len(group.Records))
+		for _, record := range group.Records {
+			files = append(files, fromStorageRecord(record))
+		}
+		groups = append(groups, DuplicateGroup{
+			Hash:        group.Hash,
+			Size:        group.Size,
+			Count:       len(files),
+			WastedBytes: group.Size * int64(len(files)-1),
+			Files:       files,
+		})
+	}
+
+	return DuplicateResult{
+		Groups:      groups,
+		TotalGroups: summary.Groups,
+		TotalFiles:  summary.Files,
+		WastedBytes: summary.WastedBytes,
+	}, nil
+}
+
+// hashDuplicateCandidates fills in hashes for files that may have duplicates.
+// Files are bucketed by size; buckets with more than one entry get partial
+// hashes, and only files sharing a partial hash are hashed in full. Hashes
+// already stored for unchanged files are reused. Progress is reported on job.
+func (idx *Indexer) hashDuplicateCandidates(ctx context.Context, w recordWriter, job *scanJob) error {
+	if !idx.memory {
+		return idx.query.SizeBuckets(ctx, func(_ int64, stored []storage.Record) error {
+			records := make([]FileRecord, 0, len(stored))
+			for _, record := range stored {
+				records = append(records, fromStorageRecord(record))
+			}
+			return idx.hashBucket(ctx, w, job, records)
+		})
+	}
+
+	idx.mu.RLock()
+	buckets := make(map[int64][]FileRecord)
+	for _, record := range idx.files {
+		if record.Size <= 0 {
+			continue
+		}
+		buckets[record.Size] = append(buckets[record.Size], record)
+	}
+	idx.mu.RUnlock()
+
+	for _, records := range buckets {
+		if len(records) < 2 {
+			continue
+		}
+		if err := idx.hashBucket(ctx, w, job, records); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashBucket hashes records of equal size: partially first, then in full for
+// those whose partial hashes collide.
+func (idx *Indexer) hashBucket(ctx context.Context, w recordWriter, job *scanJob, records []FileRecord) error {
+	byPartial := make(map[string][]FileRecord)
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if record.PartialHash == "" {
+			updated, err := idx.hashRecord(ctx, w, job, record, false)
+			if err != nil {
+				return err
+			}
+			record = updated
+		}
+		if record.PartialHash != "" {
+			byPartial[record.PartialHash] = append(byPartial[record.PartialHash], record)
+		}
+	}
+
+	for _, candidates := range byPartial {
+		if len(candidates) < 2 {
+			continue
+		}
+		for _, record := range candidates {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if record.Hash != "" {
+				continue
+			}
+			if _, err := idx.hashRecord(ctx, w, job, record, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hashRecord computes the partial (and optionally full) hash of a record and
+// persists it. Files that cannot be read or that changed since they were
+// indexed are returned unchanged; only persistence failures are reported.
+func (idx *Indexer) hashRecord(ctx context.Context, w recordWriter, job *scanJob, record FileRecord, full bool) (FileRecord, error) {
+	job.current.Store(&record.Path)
+	idx.statusChanged()
+
+	partial, content, err := hashFile(record.Path, record.Size, record.ModTime, full)
+	if err != nil {
+		return record, nil
+	}
+
+	current, ok, err := idx.lookup(ctx, record.Path)
+	if err != nil {
+		return record, err
+	}
+	if !ok || current.Size != record.Size || !current.ModTime.Equal(record.ModTime) {
+		return record, nil
+	}
+
+	current.PartialHash = partial
+	if content != "" {
+		current.Hash = content
+	}
+	if err := w.save(ctx, current, changeNone, nil); err != nil {
+		return record, err
+	}
+	return current, nil
+}
+
+// errFileChanged indicates a file was modified while it was being hashed.
+var errFileChanged = errors.New("file changed while hashing")
+
+// hashFile returns the partial hash of a file and, when full is set or the
+// file is small enough that the partial hash already covers it, the SHA-256 of
+// the entire contents. The file must still match the expected size and
+// modification time.
+func hashFile(path string, size int64, modTime time.Time, full bool) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", "", err
+	}
+	if !info.Mode().IsRegular() || info.Size() != size || !info.ModTime().Equal(modTime) {
+		return "", "", errFileChanged
+	}
+
+	if size <= 2*partialHashSpan {
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, file); err != nil {
+			return "", "", err
+		}
+		sum := hex.EncodeToString(hasher.Sum(nil))
+		return sum, sum, nil
+	}
+
+	partialHasher := sha256.New()
+	if _, err := io.CopyN(partialHasher, file, partialHashSpan); err != nil {
+		return "", "", err
+	}
+	if _, err := file.Seek(size-partialHashSpan, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	if _, err := io.CopyN(partialHasher, file, partialHashSpan); err != nil {
+		return "", "", err
+	}
+	fmt.Fprintf(partialHasher, "%d", size)
+	partial := hex.EncodeToString(partialHasher.Sum(nil))
+
+	if !full {
+		return partial, "", nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	fullHasher := sha256.New()
+	if _, err := io.Copy(fullHasher, file); err != nil {
+		return "", "", err
+	}
+	return partial, hex.EncodeToString(fullHasher.Sum(nil)), nil
+}
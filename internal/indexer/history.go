@@ -0,0 +1,240 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+// scanRunHistory is the number of scan runs kept in the store.
+const scanRunHistory = 10000
+
+// ErrScanHistoryUnavailable is returned when the scan history is read from an
+// indexer whose store cannot persist it.
+var ErrScanHistoryUnavailable = errors.New("scan history requires a store with scan history support")
+
+// ScanRunStore is implemented by stores that can persist the scan history.
+// Indexers backed by such a store record every scan job that runs.
+type ScanRunStore interface {
+	AppendScanRun(ctx context.Context, run storage.ScanRun) error
+	ScanRuns(ctx context.Context, query storage.ScanRunQuery) ([]storage.ScanRun, error)
+	PruneScanRuns(ctx context.Context, keep int) (int64, error)
+}
+
+// ScanRun summarizes a scan job that ran, as kept in the scan history.
+type ScanRun struct {
+	// ID is the ID of the scan job.
+	ID    int64        `json:"id"`
+	Mode  string       `json:"mode"`
+	State ScanJobState `json:"state"`
+	Roots []string     `json:"roots"`
+	// Paths lists the subtrees scanned when the job did not cover whole
+	// roots.
+	Paths      []string  `json:"paths,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Processed  int64     `json:"processed"`
+	Added      int64     `json:"added"`
+	Modified   int64     `json:"modified"`
+	Moved      int64     `json:"moved"`
+	Deleted    int64     `json:"deleted"`
+	// Bytes is the total size of the files the scan processed.
+	Bytes  int64    `json:"bytes"`
+	Errors []string `json:"errors,omitempty"`
+	// WalkErrors reports the directories and files the scan could not read.
+	WalkErrors *WalkErrors `json:"walkErrors,omitempty"`
+}
+
+// ScanRunQuery selects a page of the scan history. Cursor continues from the
+// NextCursor of a previous page.
+type ScanRunQuery struct {
+	Cursor string
+	// Root, when set, keeps only runs that scanned this root.
+	Root string
+	// Roots, when not nil, keeps only runs that scanned no root outside it.
+	Roots []string
+	Limit int
+}
+
+// ScanRunPage is a page of the scan history, most recent run first.
+type ScanRunPage struct {
+	Runs []ScanRun
+	// NextCursor resumes after the last run of this page; it is empty on the
+	// final page.
+	NextCursor string
+}
+
+// ScanHistory returns a page of the persisted scan runs, most recent first.
+func (idx *Indexer) ScanHistory(ctx context.Context, query ScanRunQuery) (ScanRunPage, error) {
+	store, ok := idx.store.(ScanRunStore)
+	if !ok {
+		return ScanRunPage{}, ErrScanHistoryUnavailable
+	}
+
+	q := storage.ScanRunQuery{Root: query.Root, Roots: query.Roots}
+	if query.Cursor != "" {
+		before, err := strconv.ParseInt(query.Cursor, 10, 64)
+		if err != nil || before <= 0 {
+			return ScanRunPage{}, ErrInvalidCursor
+		}
+		q.BeforeID = before
+	}
+	if query.Limit > 0 {
+		q.Limit = query.Limit + 1
+	}
+
+	stored, err := store.ScanRuns(ctx, q)
+	if err != nil {
+		return ScanRunPage{}, err
+	}
+
+	var page ScanRunPage
+	if query.Limit > 0 && len(stored) > query.Limit {
+		stored = stored[:query.Limit]
+		page.NextCursor = strconv.FormatInt(stored[len(stored)-1].ID, 10)
+	}
+	page.Runs = make([]ScanRun, 0, len(stored))
+	for _, run := range stored {
+		page.Runs = append(page.Runs, fromStorageScanRun(run))
+	}
+	return page, nil
+}
+
+// recordScanRun adds a run to the scan history, if the store keeps one, and
+// drops the oldest runs beyond scanRunHistory.
+func (idx *Indexer) recordScanRun(ctx context.Context, run ScanRun) error {
+	store, ok := idx.store.(ScanRunStore)
+	if !ok {
+		return nil
+	}
+	if err := store.AppendScanRun(ctx, toStorageScanRun(run)); err != nil {
+		return err
+	}
+	_, err := store.PruneScanRuns(ctx, scanRunHistory)
+	return err
+}
+
+// newScanRun summarizes a job that ran with the changes committed by its
+// writer.
+func newScanRun(job *scanJob, state ScanJobState, finish time.Time, changes map[ChangeKind]int64, errs []string) ScanRun {
+	run := ScanRun{
+		ID:         job.ID,
+		Mode:       job.Mode,
+		State:      state,
+		StartedAt:  job.StartedAt,
+		FinishedAt: finish,
+		DurationMs: finish.Sub(job.StartedAt).Milliseconds(),
+		Processed:  job.processed.Load(),
+		Added:      changes[ChangeCreated],
+		Modified:   changes[ChangeModified],
+		Moved:      changes[ChangeMoved],
+		Deleted:    changes[ChangeDeleted],
+		Bytes:      job.bytes.Load(),
+		Errors:     errs,
+		WalkErrors: job.walk.walkErrors(),
+	}
+	for _, target := range job.Targets {
+		run.Roots = append(run.Roots, target.Root)
+		run.Paths = append(run.Paths, target.Paths...)
+	}
+	return run
+}
+
+// scanErrors lists the distinct errors that ended a scan of targets: those
+// of individual roots, then the one shared by every root and the
+// cancellation.
+func scanErrors(targets []ScanTarget, rootErrs map[string]error, shared, canceled error) []string {
+	var errs []string
+	add := func(err error) {
+		if err != nil && !slices.Contains(errs, err.Error()) {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, target := range targets {
+		if err := rootErrs[target.Root]; !errors.Is(err, context.Canceled) {
+			add(err)
+		}
+	}
+	add(shared)
+	add(canceled)
+	return errs
+}
+
+func toStorageScanRun(run ScanRun) storage.ScanRun {
+	converted := storage.ScanRun{
+		ID:         run.ID,
+		Mode:       run.Mode,
+		State:      string(run.State),
+		Roots:      slices.Clone(run.Roots),
+		Paths:      slices.Clone(run.Paths),
+		StartedAt:  run.StartedAt,
+		FinishedAt: run.FinishedAt,
+		Duration:   run.FinishedAt.Sub(run.StartedAt),
+		Processed:  run.Processed,
+		Added:      run.Added,
+		Modified:   run.Modified,
+		Moved:      run.Moved,
+		Deleted:    run.Deleted,
+		Bytes:      run.Bytes,
+		Errors:     slices.Clone(run.Errors),
+	}
+	if run.WalkErrors != nil {
+		converted.WalkErrorCount = run.WalkErrors.Count
+		converted.WalkErrorKinds = make(map[string]int64, len(run.WalkErrors.Kinds))
+		for kind, count := range run.WalkErrors.Kinds {
+			converted.WalkErrorKinds[string(kind)] = count
+		}
+		for _, walkErr := range run.WalkErrors.Errors {
+			converted.WalkErrors = append(converted.WalkErrors, storage.WalkError{
+				Path:  walkErr.Path,
+				Kind:  string(walkErr.Kind),
+				Error: walkErr.Error,
+			})
+		}
+	}
+	return converted
+}
+
+func fromStorageScanRun(run storage.ScanRun) ScanRun {
+	converted := ScanRun{
+		ID:         run.ID,
+		Mode:       run.Mode,
+		State:      ScanJobState(run.State),
+		Roots:      run.Roots,
+		StartedAt:  run.StartedAt,
+		FinishedAt: run.FinishedAt,
+		DurationMs: run.Duration.Milliseconds(),
+		Processed:  run.Processed,
+		Added:      run.Added,
+		Modified:   run.Modified,
+		Moved:      run.Moved,
+		Deleted:    run.Deleted,
+		Bytes:      run.Bytes,
+	}
+	if len(run.Paths) > 0 {
+		converted.Paths = run.Paths
+	}
+	if len(run.Errors) > 0 {
+		converted.Errors = run.Errors
+	}
+	if run.WalkErrorCount > 0 {
+		walkErrs := &WalkErrors{Count: run.WalkErrorCount, Kinds: make(map[WalkErrorKind]int64, len(run.WalkErrorKinds))}
+		for kind, count := range run.WalkErrorKinds {
+			walkErrs.Kinds[WalkErrorKind(kind)] = count
+		}
+		for _, walkErr := range run.WalkErrors {
+			walkErrs.Errors = append(walkErrs.Errors, WalkError{
+				Path:  walkErr.Path,
+				Kind:  WalkErrorKind(walkErr.Kind),
+				Error: walkErr.Error,
+			})
+		}
+		converted.WalkErrors = walkErrs
+	}
+	return converted
+}
@@ -0,0 +1,160 @@
+package indexer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"seekfile/internal/storage/sqlite"
+)
+
+// runIDs returns the IDs of runs.
+func runIDs(runs []ScanRun) []int64 {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+	}
+	return ids
+}
+
+func TestScanHistory(t *testing.T) {
+	roots := []string{t.TempDir(), t.TempDir()}
+	dbPath := filepath.Join(t.TempDir(), "seekfile.db")
+	open := func() (*Indexer, *sqlite.Store) {
+		t.Helper()
+		store, err := sqlite.Open(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx, err := New(roots, store, WithMemoryIndex(false))
+		if err != nil {
+			store.Close()
+			t.Fatal(err)
+		}
+		return idx, store
+	}
+	idx, store := open()
+	roots = idx.Roots()
+	sub := filepath.Join(roots[0], "sub")
+	writeFile(t, filepath.Join(roots[0], "a.txt"), "aa")
+	writeFile(t, filepath.Join(sub, "b.txt"), "bbb")
+	writeFile(t, filepath.Join(roots[1], "c.txt"), "cccc")
+	writeFile(t, filepath.Join(roots[1], "d.txt"), "d")
+	first := scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	writeFile(t, filepath.Join(sub, "b.txt"), "bbbbb")
+	if err := os.Chtimes(filepath.Join(sub, "b.txt"), testTime.Add(time.Hour), testTime.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	second := scan(t, idx, ScanRequest{Mode: ScanModeIncremental, Paths: []string{sub}})
+
+	if err := os.Remove(filepath.Join(roots[1], "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(roots[1], "d.txt"), filepath.Join(roots[1], "e.txt")); err != nil {
+		t.Fatal(err)
+	}
+	third := scan(t, idx, ScanRequest{Mode: ScanModeFull, Paths: []string{roots[1]}})
+	store.Close()
+
+	// The history outlives the indexer.
+	idx, store = open()
+	defer store.Close()
+	page, err := idx.ScanHistory(t.Context(), ScanRunQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := runIDs(page.Runs), []int64{third.ID, second.ID, first.ID}; !slices.Equal(got, want) {
+		t.Fatalf("scan history = %v, want %v", got, want)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("single page of history has cursor %q", page.NextCursor)
+	}
+
+	for _, tc := range []struct {
+		run  ScanRun
+		want ScanRun
+	}{
+		{page.Runs[2], ScanRun{Mode: "incremental", Roots: roots, Processed: 4, Bytes: 10, Added: 4}},
+		{page.Runs[1], ScanRun{Mode: "incremental", Roots: roots[:1], Paths: []string{sub}, Processed: 1, Bytes: 5, Modified: 1}},
+		{page.Runs[0], ScanRun{Mode: "full", Roots: roots[1:], Processed: 1, Bytes: 1, Moved: 1, Deleted: 1}},
+	} {
+		run, want := tc.run, tc.want
+		if run.State != ScanJobFinished || run.Mode != want.Mode || !slices.Equal(run.Roots, want.Roots) || !slices.Equal(run.Paths, want.Paths) {
+			t.Errorf("run %d = %s %s of %v %v, want finished %s of %v %v", run.ID, run.State, run.Mode, run.Roots, run.Paths, want.Mode, want.Roots, want.Paths)
+		}
+		if run.Processed != want.Processed || run.Bytes != want.Bytes || run.Added != want.Added || run.Modified != want.Modified || run.Moved != want.Moved || run.Deleted != want.Deleted {
+			t.Errorf("run %d counted %+v, want %+v", run.ID, run, want)
+		}
+		if run.StartedAt.IsZero() || run.FinishedAt.Before(run.StartedAt) || run.DurationMs != run.FinishedAt.Sub(run.StartedAt).Milliseconds() {
+			t.Errorf("run %d ran from %s to %s in %dms", run.ID, run.StartedAt, run.FinishedAt, run.DurationMs)
+		}
+	}
+
+	// Pages follow the cursor; the root filter keeps the runs that scanned
+	// the root.
+	page, err = idx.ScanHistory(t.Context(), ScanRunQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := idx.ScanHistory(t.Context(), ScanRunQuery{Limit: 2, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := append(runIDs(page.Runs), runIDs(next.Runs)...); !slices.Equal(got, []int64{third.ID, second.ID, first.ID}) || next.NextCursor != "" {
+		t.Errorf("paged history = %v, last cursor %q", got, next.NextCursor)
+	}
+	page, err = idx.ScanHistory(t.Context(), ScanRunQuery{Root: roots[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runIDs(page.Runs); !slices.Equal(got, []int64{third.ID, first.ID}) {
+		t.Errorf("history of %s = %v, want %v", roots[1], got, []int64{third.ID, first.ID})
+	}
+
+	// The roots filter keeps the runs that scanned no other root, before the
+	// page is cut.
+	for _, tc := range []struct {
+		roots []string
+		want  []int64
+	}{
+		{roots[:1], []int64{second.ID}},
+		{roots[1:], []int64{third.ID}},
+		{roots, []int64{third.ID, second.ID, first.ID}},
+		{[]string{}, []int64{}},
+	} {
+		page, err := idx.ScanHistory(t.Context(), ScanRunQuery{Roots: tc.roots, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := runIDs(page.Runs)
+		for page.NextCursor != "" {
+			if page, err = idx.ScanHistory(t.Context(), ScanRunQuery{Roots: tc.roots, Limit: 1, Cursor: page.NextCursor}); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, runIDs(page.Runs)...)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("history within %v = %v, want %v", tc.roots, got, tc.want)
+		}
+	}
+
+	for _, cursor := range []string{"x", "0"} {
+		if _, err := idx.ScanHistory(t.Context(), ScanRunQuery{Cursor: cursor}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("ScanHistory with cursor %q: err = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestScanHistoryUnavailable(t *testing.T) {
+	idx, err := New([]string{t.TempDir()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.ScanHistory(t.Context(), ScanRunQuery{}); !errors.Is(err, ErrScanHistoryUnavailable) {
+		t.Fatalf("ScanHistory without a store: err = %v, want ErrScanHistoryUnavailable", err)
+	}
+}
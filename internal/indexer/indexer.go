@@ -7,12 +7,13 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"seekfile/internal/querylang"
 	"seekfile/internal/storage"
 )
 
@@ -23,10 +24,30 @@ type FileRecord struct {
 	Size     int64     `json:"size"`
 	ModTime  time.Time `json:"modified"`
 	RootPath string    `json:"rootPath"`
+	// Hash is the SHA-256 of the file contents. It is only computed for files
+	// that share their size and partial hash with another file.
+	Hash string `json:"hash,omitempty"`
+	// PartialHash fingerprints the head and tail of the file and is used to
+	// narrow down duplicate candidates before hashing them in full.
+	PartialHash string `json:"-"`
+	// ContentIndexed records that text extraction ran for this version of the file.
+	ContentIndexed bool `json:"-"`
+	// Snippet holds an HTML excerpt with highlighted terms for content matches.
+	Snippet string `json:"snippet,omitempty"`
+	// Score is the relevance of a content or fuzzy name match; higher is
+	// better.
+	Score float64 `json:"score,omitempty"`
+	// Device and Inode identify the file independently of its path so that
+	// renames and moves can be told apart from a delete and a create.
+	Device uint64 `json:"-"`
+	Inode  uint64 `json:"-"`
 }
 
 // Query defines the search criteria supported by the indexer.
 type Query struct {
+	// NamePattern is a search box query in the querylang syntax; a plain word
+	// matches a substring of the name, or the whole name when it contains *
+	// or ?.
 	NamePattern    string
 	MinSize        int64
 	MaxSize        int64
@@ -37,12 +58,38 @@ type Query struct {
 	Offset         int
 	Limit          int
 	Extensions     []string
+	// Roots restricts results to files of these scan roots. Nil searches
+	// every root; an empty, non-nil slice matches nothing.
+	Roots []string
+	// Content restricts results to files whose indexed text matches every word.
+	Content string
+	// Cursor continues from the NextCursor of a previous result using keyset
+	// pagination. Offset is ignored when it is set.
+	Cursor string
+	// Facets requests facet counts over every match.
+	Facets bool
+	// Fuzzy matches the plain words of NamePattern with typo tolerance and
+	// scores each match for the relevance sort.
+	Fuzzy bool
+	// Dir restricts results to files directly inside this directory.
+	Dir string
+
+	// expr is NamePattern parsed by Search.
+	expr querylang.Expr
 }
 
 // SearchResult describes the outcome of a search request.
 type SearchResult struct {
 	Files []FileRecord
 	Total int
+	// NextCursor resumes after the last file of this page; it is empty on the
+	// final page.
+	NextCursor string
+	// Facets is set when the query asked for them.
+	Facets *Facets
+	// Truncated reports that a content or fuzzy search matched more files
+	// than it ranks, so Files and Total only cover the best matches.
+	Truncated bool
 }
 
 // ScanMode indicates how a scan should be executed.
@@ -55,48 +102,129 @@ const (
 	ScanModeFull ScanMode = "full"
 )
 
-// ErrScanInProgress is returned when attempting to start a scan while one is already running.
-var ErrScanInProgress = errors.New("scan already in progress")
+const (
+	scanPhaseWalking = "walking"
+	scanPhaseHashing = "hashing"
+)
+
+// ErrOutsideRoots is returned by QueueScan for a path that is not inside
+// a configured scan root.
+var ErrOutsideRoots = errors.New("path is not inside a configured scan root")
 
 // ScanStatus summarizes the current or most recent scan activity.
 type ScanStatus struct {
-	Mode              string    `json:"mode"`
-	Running           bool      `json:"running"`
-	CurrentPath       string    `json:"currentPath"`
-	Processed         int64     `json:"processed"`
-	KnownFiles        int       `json:"knownFiles"`
-	StartedAt         time.Time `json:"startedAt"`
-	FinishedAt        time.Time `json:"finishedAt"`
+	// ID identifies the most recently started scan job, which the fields up
+	// to WalkErrors describe; changes made by it are logged with this ID.
+	// Running is set while any job runs.
+	ID                int64       `json:"id,omitempty"`
+	Mode              string      `json:"mode"`
+	Running           bool        `json:"running"`
+	CurrentPath       string      `json:"currentPath"`
+	Processed         int64       `json:"processed"`
+	KnownFiles        int         `json:"knownFiles"`
+	StartedAt         time.Time   `json:"startedAt"`
+	FinishedAt        time.Time   `json:"finishedAt"`
+	LastSuccessfulRun time.Time   `json:"lastSuccessfulRun"`
+	Error             string      `json:"error,omitempty"`
+	Phase             string      `json:"phase,omitempty"`
+	WalkErrors        *WalkErrors `json:"walkErrors,omitempty"`
+	// Roots reports the current or most recent scan of each root; roots left
+	// out of a scan keep the entry of the last scan that covered them.
+	Roots []RootScanStatus `json:"roots,omitempty"`
+	// Jobs lists the running and queued scan jobs in queue order.
+	Jobs      []ScanJob        `json:"jobs,omitempty"`
+	Watch     []WatchStatus    `json:"watch,omitempty"`
+	Schedules []ScheduleStatus `json:"schedules,omitempty"`
+}
+
+// RootScanStatus reports the current or most recent scan of a single root.
+type RootScanStatus struct {
+	Root string `json:"root"`
+	// Paths lists the subtrees that were scanned when the scan did not cover
+	// the whole root.
+	Paths       []string  `json:"paths,omitempty"`
+	Mode        string    `json:"mode,omitempty"`
+	Running     bool      `json:"running"`
+	CurrentPath string    `json:"currentPath"`
+	Processed   int64     `json:"processed"`
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	// LastSuccessfulRun is when a scan of the whole root last succeeded.
 	LastSuccessfulRun time.Time `json:"lastSuccessfulRun"`
 	Error             string    `json:"error,omitempty"`
 }
 
+// root returns the entry of a scan root, or nil when there is none.
+func (s *ScanStatus) root(path string) *RootScanStatus {
+	for i := range s.Roots {
+		if s.Roots[i].Root == path {
+			return &s.Roots[i]
+		}
+	}
+	return nil
+}
+
 // RecordStore describes the persistence operations required by the indexer.
 type RecordStore interface {
 	LoadAll(ctx context.Context) ([]storage.Record, error)
 	Upsert(ctx context.Context, record storage.Record) error
 	Delete(ctx context.Context, path string) error
+	// Move renames the record at from to the path of record, replacing any
+	// record already stored there.
+	Move(ctx context.Context, from string, record storage.Record) error
 	ScanState(ctx context.Context, root string) (storage.ScanState, error)
 	UpdateScanState(ctx context.Context, state storage.ScanState) error
+	// Begin starts a transaction used to write scan results in batches.
+	Begin(ctx context.Context) (storage.Batch, error)
 }
 
 // Indexer builds and maintains an in-memory representation of files on disk.
+// When the in-memory index is disabled, queries are answered by the store.
 type Indexer struct {
 	mu        sync.RWMutex
 	files     map[string]FileRecord
+	names     *nameIndex
+	dirs      *dirTree
 	scanRoots []string
 
 	store RecordStore
 
-	statusMu sync.RWMutex
-	status   ScanStatus
+	memory bool
+	query  QueryStore
+	count  recordCount
+
+	statusMu  sync.RWMutex
+	status    ScanStatus
+	statusHub statusHub
 
-	scanMu     sync.Mutex
-	scanCancel context.CancelFunc
+	// jobs holds the queued and running scans in queue order and history the
+	// most recently ended ones; both are guarded by statusMu. lastJob is the
+	// most recently started job.
+	jobs      []*scanJob
+	history   []ScanJob
+	lastJob   *scanJob
+	lastJobID int64
+	// hashMu serializes duplicate hashing, which covers the whole index.
+	hashMu sync.Mutex
+
+	scheduleMu sync.Mutex
+	schedules  []*scheduledScan
+
+	watchMu sync.RWMutex
+	watches map[string]*WatchStatus
+
+	roots map[string]*rootConfig
+
+	hashContents bool
+	content      *contentIndexer
+	batchSize    int
+
+	changeHandlers []func(Change)
+	changeLog      *changeLog
 }
 
 // New constructs an Indexer for the provided root directories backed by the supplied store.
-func New(scanRoots []string, store RecordStore) (*Indexer, error) {
+func New(scanRoots []string, store RecordStore, opts ...Option) (*Indexer, error) {
 	if len(scanRoots) == 0 {
 		return nil, errors.New("at least one scan root is required")
 	}
@@ -116,11 +244,29 @@ func New(scanRoots []string, store RecordStore) (*Indexer, error) {
 		return nil, errors.New("no valid scan roots provided")
 	}
 
-	return &Indexer{
+	idx := &Indexer{
 		files:     make(map[string]FileRecord),
+		names:     newNameIndex(),
+		dirs:      newDirTree(),
 		scanRoots: normalized,
 		store:     store,
-	}, nil
+		memory:    true,
+		batchSize: DefaultBatchSize,
+		watches:   make(map[string]*WatchStatus),
+		roots:     make(map[string]*rootConfig, len(normalized)),
+	}
+	for _, root := range normalized {
+		idx.roots[root] = &rootConfig{path: root}
+		idx.status.Roots = append(idx.status.Roots, RootScanStatus{Root: root})
+	}
+
+	for _, opt := range opts {
+		if err := opt(idx); err != nil {
+			return nil, err
+		}
+	}
+
+	return idx, nil
 }
 
 // LoadFromStore restores the in-memory index from the persistent cache.
@@ -129,188 +275,267 @@ func (idx *Indexer) LoadFromStore(ctx context.Context) (int, error) {
 		return 0, nil
 	}
 
-	records, err := idx.store.LoadAll(ctx)
-	if err != nil {
-		return 0, err
-	}
+	var loaded int
+	if idx.memory {
+		records, err := idx.store.LoadAll(ctx)
+		if err != nil {
+			return 0, err
+		}
 
-	data := make(map[string]FileRecord, len(records))
-	for _, record := range records {
-		normalized := filepath.Clean(record.Path)
-		data[normalized] = FileRecord{
-			Path:     normalized,
-			Name:     record.Name,
-			Size:     record.Size,
-			ModTime:  record.ModTime,
-			RootPath: record.RootPath,
+		data := make(map[string]FileRecord, len(records))
+		names := newNameIndex()
+		dirs := newDirTree()
+		for _, record := range records {
+			converted := fromStorageRecord(record)
+			data[converted.Path] = converted
+			names.add(converted.Path, converted.Name)
+			dirs.add(converted)
 		}
-	}
 
-	idx.mu.Lock()
-	idx.files = data
-	idx.mu.Unlock()
+		idx.mu.Lock()
+		idx.files = data
+		idx.names = names
+		idx.dirs = dirs
+		idx.mu.Unlock()
+		loaded = len(records)
+	} else {
+		count, err := idx.query.Count(ctx)
+		if err != nil {
+			return 0, err
+		}
+		loaded = count
+	}
 
 	var lastRun time.Time
+	rootRuns := make(map[string]time.Time, len(idx.scanRoots))
 	if idx.store != nil {
 		for _, root := range idx.scanRoots {
 			state, stateErr := idx.store.ScanState(ctx, root)
 			if stateErr != nil {
 				continue
 			}
-			if state.LastFullScan.After(lastRun) {
-				lastRun = state.LastFullScan
+			// Full scans also advance the incremental scan time.
+			rootRun, ok := lastScan(state, ScanModeIncremental)
+			if !ok {
+				continue
 			}
-			if state.LastIncrementalScan.After(lastRun) {
-				lastRun = state.LastIncrementalScan
+			rootRuns[root] = rootRun
+			if rootRun.After(lastRun) {
+				lastRun = rootRun
 			}
 		}
 	}
 
 	idx.updateStatus(func(status *ScanStatus) {
+		for root, rootRun := range rootRuns {
+			if entry := status.root(root); entry != nil {
+				entry.LastSuccessfulRun = rootRun
+			}
+		}
 		status.LastSuccessfulRun = lastRun
 		status.Mode = string(ScanModeIncremental)
 		status.Processed = 0
 		status.Error = ""
 	})
 
-	return len(records), nil
+	return loaded, nil
 }
 
-// StartScan triggers a background scan using the provided mode. Only one scan may run at a time.
+// StartScan queues a scan of every root using the provided mode; see
+// QueueScan.
 func (idx *Indexer) StartScan(ctx context.Context, mode ScanMode) error {
-	if ctx == nil {
-		ctx = context.Background()
-	}
-
-	idx.statusMu.Lock()
-	if idx.status.Running {
-		idx.statusMu.Unlock()
-		return ErrScanInProgress
-	}
-	idx.status = ScanStatus{
-		Mode:        string(mode),
-		Running:     true,
-		StartedAt:   time.Now(),
-		Processed:   0,
-		KnownFiles:  idx.countFiles(),
-		FinishedAt:  time.Time{},
-		Error:       "",
-		CurrentPath: "",
-	}
-	idx.statusMu.Unlock()
-
-	scanCtx, cancel := context.WithCancel(ctx)
-
-	idx.scanMu.Lock()
-	if idx.scanCancel != nil {
-		idx.scanCancel()
-	}
-	idx.scanCancel = cancel
-	idx.scanMu.Unlock()
-
-	go idx.runScan(scanCtx, mode)
-	return nil
+	_, err := idx.QueueScan(ctx, ScanRequest{Mode: mode})
+	return err
 }
 
-// StopScan cancels an in-flight scan if one is running.
+// StopScan cancels every queued and running scan.
 func (idx *Indexer) StopScan() {
-	idx.scanMu.Lock()
-	defer idx.scanMu.Unlock()
-	if idx.scanCancel != nil {
-		idx.scanCancel()
-		idx.scanCancel = nil
+	idx.statusMu.Lock()
+	for _, job := range slices.Clone(idx.jobs) {
+		if job.State == ScanJobQueued {
+			job.State = ScanJobCanceled
+			job.Error = context.Canceled.Error()
+			idx.endJob(job)
+			continue
+		}
+		job.cancel()
 	}
+	idx.statusMu.Unlock()
+	idx.statusChanged()
 }
 
 // Status returns a snapshot of the current scan status along with the number of indexed files.
 func (idx *Indexer) Status() ScanStatus {
 	idx.statusMu.RLock()
 	status := idx.status
+	status.Roots = slices.Clone(status.Roots)
+	if job := idx.lastJob; job != nil {
+		last := job.snapshot()
+		status.ID = last.ID
+		status.Mode = last.Mode
+		status.CurrentPath = last.CurrentPath
+		status.Processed = last.Processed
+		status.StartedAt = last.StartedAt
+		status.FinishedAt = last.FinishedAt
+		status.Error = last.Error
+		status.Phase = last.Phase
+		status.WalkErrors = last.WalkErrors
+	}
+	for _, job := range idx.jobs {
+		status.Jobs = append(status.Jobs, job.snapshot())
+		if job.State != ScanJobRunning {
+			continue
+		}
+		status.Running = true
+		for root, progress := range job.roots {
+			if entry := status.root(root); entry != nil {
+				entry.Processed = progress.processed.Load()
+				if path := progress.current.Load(); path != nil {
+					entry.CurrentPath = *path
+				}
+			}
+		}
+	}
 	idx.statusMu.RUnlock()
 
 	status.KnownFiles = idx.countFiles()
+	status.Watch = idx.watchStatuses()
+	status.Schedules = idx.scheduleStatuses()
 	return status
 }
 
 // Search returns a slice of FileRecord that match the query parameters.
-func (idx *Indexer) Search(ctx context.Context, query Query) SearchResult {
+func (idx *Indexer) Search(ctx context.Context, query Query) (SearchResult, error) {
+	var err error
+	if query.expr, err = querylang.Parse(query.NamePattern); err != nil {
+		return SearchResult{}, err
+	}
+	var words []string
+	if query.Fuzzy {
+		words, query.expr = fuzzyTerms(query.expr)
+	}
+	ranked := strings.TrimSpace(query.Content) != "" || len(words) > 0
+	if !idx.memory && !ranked && normalizeSortField(query.SortField) == "relevance" {
+		// The store has no scores to rank by, so relevance falls back to the
+		// name order. This happens before the cursor is checked, so that the
+		// cursors of such pages carry the order they were produced with.
+		query.SortField = "name"
+	}
+	cursor, err := decodeCursor(query)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	if query.Roots != nil && len(query.Roots) == 0 {
+		result := SearchResult{Files: []FileRecord{}}
+		if query.Facets {
+			result.Facets = recordFacets(nil, time.Now())
+		}
+		return result, nil
+	}
+
+	// The other filters, fuzzy name candidates included, are applied by the
+	// full-text query, so that its hit limit only cuts among the records the
+	// search can return.
+	var (
+		contentHits map[string]storage.ContentHit
+		truncated   bool
+	)
+	if strings.TrimSpace(query.Content) != "" {
+		filter := storeQuery(query, nil)
+		filter.Offset, filter.Limit = 0, 0
+		filter.NameWords = words
+		contentHits, truncated, err = idx.searchContent(ctx, query.Content, filter)
+		if err != nil {
+			return SearchResult{}, err
+		}
+	}
+
+	if !idx.memory {
+		if ranked {
+			result, err := idx.searchStoreRanked(ctx, query, cursor, contentHits, words)
+			result.Truncated = result.Truncated || truncated
+			return result, err
+		}
+		return idx.searchStore(ctx, query, cursor)
+	}
+
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	nameMatcher := buildNameMatcher(query.NamePattern)
-
 	allowedExts := make(map[string]struct{})
-	if len(query.Extensions) > 0 {
-		for _, ext := range query.Extensions {
-			normalized := strings.ToLower(strings.TrimSpace(ext))
-			if normalized == "" {
-				continue
-			}
-			if !strings.HasPrefix(normalized, ".") {
-				normalized = "." + normalized
+	for _, ext := range normalizeExtensions(query.Extensions) {
+		allowedExts[ext] = struct{}{}
+	}
+	allowedRoots := rootSet(query.Roots)
+
+	// Content hits, fuzzy name candidates and the files of a directory narrow
+	// the records to look at; otherwise every record is checked.
+	var candidates []string
+	if contentHits != nil {
+		candidates = make([]string, 0, len(contentHits))
+		for path := range contentHits {
+			candidates = append(candidates, path)
+		}
+	} else if len(words) > 0 {
+		if paths, ok := idx.names.candidates(words); ok {
+			candidates = paths
+		}
+	} else if query.Dir != "" {
+		candidates = make([]string, 0)
+		if node, ok := idx.dirs.nodes[filepath.Clean(query.Dir)]; ok {
+			for path := range node.files {
+				candidates = append(candidates, path)
 			}
-			allowedExts[normalized] = struct{}{}
 		}
 	}
 
 	matches := make([]FileRecord, 0)
-	for _, record := range idx.files {
-		if ctx.Err() != nil {
-			break
+	match := func(record FileRecord) {
+		if !matchesQuery(record, query, allowedExts, allowedRoots) {
+			return
 		}
-		if !matchesQuery(record, query, nameMatcher, allowedExts) {
-			continue
+		if hit, ok := contentHits[record.Path]; ok {
+			record.Snippet = renderSnippet(hit.Snippet)
+			record.Score = -hit.Rank
+		}
+		if len(words) > 0 {
+			score := fuzzyScore(words, record.Name)
+			if score == 0 {
+				return
+			}
+			record.Score += score
 		}
 		matches = append(matches, record)
 	}
-
-	sort.Slice(matches, func(i, j int) bool {
-		cmp := compareRecords(matches[i], matches[j], query.SortField)
-		if cmp == 0 {
-			cmp = strings.Compare(strings.ToLower(matches[i].Name), strings.ToLower(matches[j].Name))
-			if cmp == 0 {
-				if matches[i].Path == matches[j].Path {
-					return false
-				}
-				return matches[i].Path < matches[j].Path
+	if candidates != nil {
+		for _, path := range candidates {
+			if record, ok := idx.files[path]; ok {
+				match(record)
 			}
 		}
-
-		if query.SortDescending {
-			return cmp > 0
+	} else {
+		for _, record := range idx.files {
+			if ctx.Err() != nil {
+				break
+			}
+			match(record)
 		}
-		return cmp < 0
-	})
-
-	total := len(matches)
-
-	offset := query.Offset
-	if offset < 0 {
-		offset = 0
-	}
-	if offset > total {
-		offset = total
-	}
-
-	limit := query.Limit
-	if limit <= 0 || offset+limit > total {
-		limit = total - offset
 	}
 
-	paged := matches
-	if offset != 0 || limit != total {
-		paged = matches[offset : offset+limit]
+	result := pageRecords(matches, query, cursor)
+	result.Truncated = truncated
+	if query.Facets {
+		result.Facets = recordFacets(matches, time.Now())
 	}
-
-	return SearchResult{Files: paged, Total: total}
+	return result, nil
 }
 
 // Lookup returns a FileRecord by its full path.
 func (idx *Indexer) Lookup(path string) (FileRecord, bool) {
-	normalized := filepath.Clean(path)
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
-	record, ok := idx.files[normalized]
+	record, ok, err := idx.lookup(context.Background(), path)
+	if err != nil {
+		return FileRecord{}, false
+	}
 	return record, ok
 }
 
@@ -323,114 +548,318 @@ func (idx *Indexer) Roots() []string {
 	return roots
 }
 
-// UpdateFile updates metadata for a single file. It is intended to be used by
-// filesystem watchers to keep the index fresh.
+// UpdateFile updates metadata for a single file. It is intended for external
+// integrations that learn about changes outside of scans and the built-in
+// watcher.
 func (idx *Indexer) UpdateFile(record FileRecord) {
-	_ = idx.saveRecord(context.Background(), record)
+	ctx := context.Background()
+	kind := ChangeCreated
+	if _, ok, err := idx.lookup(ctx, record.Path); err == nil && ok {
+		kind = ChangeModified
+	}
+	_ = idx.saveRecord(ctx, record, kind, nil, changeOrigin{cause: ChangeCauseAPI})
 }
 
 // RemoveFile removes a file from the index by its path.
 func (idx *Indexer) RemoveFile(path string) {
-	_ = idx.deleteRecord(context.Background(), path)
+	_ = idx.deleteRecord(context.Background(), path, changeOrigin{cause: ChangeCauseAPI})
 }
 
-func (idx *Indexer) runScan(ctx context.Context, mode ScanMode) {
-	defer func() {
-		idx.scanMu.Lock()
-		idx.scanCancel = nil
-		idx.scanMu.Unlock()
-	}()
-
-	var firstErr error
-	processed := int64(0)
-	seen := make(map[string]struct{})
-	scannedRoots := make(map[string]struct{})
-	rootStates := make(map[string]storage.ScanState)
+// runScan performs a job started by dispatch, then ends it and launches the
+// jobs that were waiting for its roots.
+func (idx *Indexer) runScan(job *scanJob) {
+	ctx, targets := job.ctx, job.Targets
+
+	var (
+		resultMu     sync.Mutex
+		firstErr     error
+		sharedErr    error
+		wg           sync.WaitGroup
+		state        = newWalkState()
+		ids          = idx.newIdentityIndex()
+		scannedRoots = make(map[string]struct{})
+		scannedPaths []string
+		rootErrs     = make(map[string]error, len(targets))
+		rootStates   = make(map[string]storage.ScanState)
+	)
+	idx.updateStatus(func(*ScanStatus) {
+		job.walk = state
+	})
 	if idx.store != nil {
-		for _, root := range idx.scanRoots {
-			state, err := idx.store.ScanState(ctx, root)
+		for _, target := range targets {
+			state, err := idx.store.ScanState(ctx, target.Root)
 			if err != nil {
 				continue
 			}
-			rootStates[root] = state
+			rootStates[target.Root] = state
 		}
 	}
 
-	for _, root := range idx.scanRoots {
-		select {
-		case <-ctx.Done():
-			firstErr = ctx.Err()
-			idx.updateStatus(func(status *ScanStatus) {
-				status.Error = ctx.Err().Error()
-			})
-			break
-		default:
+	var scope moveScope
+	for _, target := range targets {
+		if target.Paths == nil {
+			scope.paths = append(scope.paths, target.Root)
+		} else {
+			scope.paths = append(scope.paths, target.Paths...)
 		}
+	}
 
-		if err := idx.walkRoot(ctx, root, mode, seen, &processed); err != nil {
-			if errors.Is(err, context.Canceled) {
-				firstErr = ctx.Err()
-				break
-			}
-			if firstErr == nil {
+	writer := idx.newBatchWriter(changeOrigin{cause: ChangeCauseScan, scanID: job.ID})
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target ScanTarget) {
+			defer wg.Done()
+			err := idx.scanRoot(ctx, writer, job, target, state, ids, scope, rootStates[target.Root])
+
+			resultMu.Lock()
+			defer resultMu.Unlock()
+			rootErrs[target.Root] = err
+			switch {
+			case err == nil && target.Paths == nil:
+				scannedRoots[target.Root] = struct{}{}
+			case err == nil:
+				scannedPaths = append(scannedPaths, target.Paths...)
+			case firstErr == nil && !errors.Is(err, context.Canceled):
 				firstErr = err
 			}
-			continue
-		}
+		}(target)
+	}
+	wg.Wait()
 
-		scannedRoots[root] = struct{}{}
+	if ctx.Err() != nil {
+		firstErr = ctx.Err()
+		idx.updateStatus(func(*ScanStatus) {
+			job.Error = ctx.Err().Error()
+		})
+	}
 
-		if idx.store != nil {
-			timestamp := time.Now()
-			state := rootStates[root]
-			switch mode {
-			case ScanModeFull:
-				state.LastFullScan = timestamp
-				state.LastIncrementalScan = timestamp
-			default:
-				state.LastIncrementalScan = timestamp
-			}
-			state.RootPath = root
-			if err := idx.store.UpdateScanState(ctx, state); err != nil && firstErr == nil {
-				firstErr = err
-			}
+	// Errors past this point concern every root of the scan. Only the parts
+	// that were walked to the end can settle deferred moves; candidates in
+	// the others are checked on disk.
+	if ctx.Err() == nil {
+		walked := moveScope{paths: slices.Clone(scannedPaths), done: true}
+		for root := range scannedRoots {
+			walked.paths = append(walked.paths, root)
+		}
+		if err := idx.resolveDeferredMoves(ctx, writer, state, ids, walked); err != nil && sharedErr == nil {
+			sharedErr = err
 		}
 	}
+	if len(scannedRoots) > 0 || len(scannedPaths) > 0 {
+		if err := idx.removeMissing(ctx, writer, state, scannedRoots, scannedPaths); err != nil && sharedErr == nil {
+			sharedErr = err
+		}
+	}
+	if err := writer.flush(ctx); err != nil && sharedErr == nil {
+		sharedErr = err
+	}
 
-	if len(scannedRoots) > 0 {
-		if err := idx.removeMissing(ctx, seen, scannedRoots); err != nil && firstErr == nil {
-			firstErr = err
+	if idx.hashContents && ctx.Err() == nil && firstErr == nil && sharedErr == nil {
+		idx.updateStatus(func(*ScanStatus) {
+			job.Phase = scanPhaseHashing
+		})
+		idx.hashMu.Lock()
+		if err := idx.hashDuplicateCandidates(ctx, writer, job); err != nil && sharedErr == nil {
+			sharedErr = err
 		}
+		idx.hashMu.Unlock()
+		if err := writer.flush(ctx); err != nil && sharedErr == nil {
+			sharedErr = err
+		}
+	}
+
+	if err := idx.pruneChanges(context.WithoutCancel(ctx), true); err != nil && sharedErr == nil {
+		sharedErr = err
+	}
+	if firstErr == nil {
+		firstErr = sharedErr
 	}
 
 	finish := time.Now()
+	idx.count.invalidate()
+
+	outcome := ScanJobFinished
+	switch {
+	case ctx.Err() != nil:
+		outcome = ScanJobCanceled
+	case firstErr != nil:
+		outcome = ScanJobFailed
+	}
+	run := newScanRun(job, outcome, finish, writer.changeCounts(), scanErrors(targets, rootErrs, sharedErr, ctx.Err()))
+	if err := idx.recordScanRun(context.WithoutCancel(ctx), run); err != nil && firstErr == nil {
+		firstErr, sharedErr = err, err
+		outcome = ScanJobFailed
+	}
 
+	var (
+		snapshot ScanJob
+		started  []*scanJob
+	)
 	idx.updateStatus(func(status *ScanStatus) {
-		status.Running = false
-		status.FinishedAt = finish
-		status.Processed = processed
-		status.CurrentPath = ""
-		if firstErr != nil {
-			status.Error = firstErr.Error()
-		} else {
-			status.Error = ""
+		job.FinishedAt = finish
+		job.Processed = job.processed.Load()
+		job.CurrentPath = ""
+		job.Phase = ""
+		job.State = outcome
+		job.WalkErrors = run.WalkErrors
+		job.walk = nil
+		switch outcome {
+		case ScanJobCanceled:
+			job.Error = ctx.Err().Error()
+		case ScanJobFailed:
+			job.Error = firstErr.Error()
+		default:
+			job.Error = ""
 			status.LastSuccessfulRun = finish
 		}
+
+		for _, target := range targets {
+			entry := status.root(target.Root)
+			if entry == nil {
+				continue
+			}
+			entry.Running = false
+			entry.FinishedAt = finish
+			entry.CurrentPath = ""
+			if progress := job.roots[target.Root]; progress != nil {
+				entry.Processed = progress.processed.Load()
+			}
+
+			err := rootErrs[target.Root]
+			if err == nil {
+				err = sharedErr
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				entry.Error = err.Error()
+				continue
+			}
+			entry.Error = ""
+			if target.Paths == nil {
+				entry.LastSuccessfulRun = finish
+			}
+		}
+
+		idx.endJob(job)
+		snapshot = job.snapshot()
+		started = idx.dispatch()
 	})
+
+	if firstErr != nil {
+		idx.publishStatus(StatusEventScanFailed, &snapshot)
+	} else {
+		idx.publishStatus(StatusEventScanFinished, &snapshot)
+	}
+	idx.launch(started)
+}
+
+// scanRoot walks a target's root, or only its subtrees when it has any. Once
+// a walk of the whole root completes, the root's new scan state is queued
+// behind the changes it made so that the state is committed together with,
+// or after, the data.
+func (idx *Indexer) scanRoot(ctx context.Context, w *batchWriter, job *scanJob, target ScanTarget, state *walkState, ids *identityIndex, scope moveScope, rootState storage.ScanState) error {
+	if target.Paths != nil {
+		for _, path := range target.Paths {
+			if err := idx.walkRoot(ctx, w, job, target.Root, path, state, ids, scope); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := idx.walkRoot(ctx, w, job, target.Root, target.Root, state, ids, scope); err != nil {
+		return err
+	}
+
+	if idx.store == nil {
+		return nil
+	}
+	timestamp := time.Now()
+	switch ScanMode(job.Mode) {
+	case ScanModeFull:
+		rootState.LastFullScan = timestamp
+		rootState.LastIncrementalScan = timestamp
+	default:
+		rootState.LastIncrementalScan = timestamp
+	}
+	rootState.RootPath = target.Root
+	return w.updateScanState(ctx, rootState)
 }
 
-func (idx *Indexer) walkRoot(ctx context.Context, root string, mode ScanMode, seen map[string]struct{}, processed *int64) error {
-	walker := func(path string, entry fs.DirEntry, err error) error {
+// walkRoot indexes every file beneath start, which is root or a path inside
+// it, using the root's worker pool, and reports progress on job. Files that
+// appear under a new path with the identity of an indexed file whose old path
+// is gone are recorded as moves; when the old path lies in a part of scope
+// not walked yet, the file is left in state for resolveDeferredMoves.
+func (idx *Indexer) walkRoot(ctx context.Context, w recordWriter, job *scanJob, root, start string, state *walkState, ids *identityIndex, scope moveScope) error {
+	mode := ScanMode(job.Mode)
+	workers := idx.rootConfig(root).concurrency()
+	return idx.walkTreeParallel(ctx, root, start, state, workers, func(path string, entry fs.DirEntry) error {
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			// A file removed since its directory was read is simply gone.
+			if !errors.Is(infoErr, fs.ErrNotExist) {
+				state.markUnreadable(path, false, infoErr)
+			}
+			return nil
+		}
+
+		job.processed.Add(1)
+		job.bytes.Add(info.Size())
+		state.markSeen(path)
+		job.track(root, path)
+		idx.statusChanged()
+
+		record := newFileRecord(root, path, info)
+		existing, ok, err := idx.lookup(ctx, path)
 		if err != nil {
+			return err
+		}
+		if ok {
+			switch {
+			case existing.Size != info.Size() || !existing.ModTime.Equal(info.ModTime()):
+				return idx.storeFile(ctx, w, record, ChangeModified)
+			case mode == ScanModeFull:
+				// The file is unchanged, so its hashes and extracted text
+				// still apply.
+				return idx.storeFile(ctx, w, carryOver(existing, record), changeNone)
+			case existing.Inode == 0 && record.Inode != 0:
+				existing.Device, existing.Inode = record.Device, record.Inode
+				return idx.storeFile(ctx, w, existing, changeNone)
+			case idx.needsContent(existing):
+				return idx.storeFile(ctx, w, existing, changeNone)
+			}
 			return nil
 		}
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+		previous, match, err := idx.movedRecord(ctx, ids, state, scope, record)
+		if err != nil {
+			return err
 		}
+		switch match {
+		case moveFound:
+			return w.move(ctx, previous.Path, carryOver(previous, record))
+		case moveDeferred:
+			state.deferMove(record)
+			return nil
+		}
+		return idx.storeFile(ctx, w, record, ChangeCreated)
+	})
+}
 
+// syncTree brings the index in line with the current contents of dir, which
+// must live beneath root, writing through w. Unlike a scan it does not touch
+// the scan status, so it is suitable for targeted refreshes triggered by the
+// watcher.
+func (idx *Indexer) syncTree(ctx context.Context, w recordWriter, root, dir string) error {
+	state := newWalkState()
+	err := idx.walkTree(ctx, root, dir, state, func(path string, entry fs.DirEntry) error {
 		if entry.IsDir() {
 			return nil
 		}
@@ -440,63 +869,155 @@ func (idx *Indexer) walkRoot(ctx context.Context, root string, mode ScanMode, se
 			return nil
 		}
 
-		normalized := filepath.Clean(path)
-		*processed++
-		seen[normalized] = struct{}{}
-
-		idx.updateStatus(func(status *ScanStatus) {
-			status.Processed = *processed
-			status.CurrentPath = normalized
-		})
+		state.markSeen(path)
+		return idx.refreshRecord(ctx, w, root, path, info)
+	})
+	if err != nil {
+		return err
+	}
 
-		if mode == ScanModeIncremental {
-			if existing, ok := idx.Lookup(normalized); ok {
-				if existing.Size == info.Size() && existing.ModTime.Equal(info.ModTime()) {
-					return nil
-				}
+	indexed, err := idx.pathsWithin(ctx, dir, 0)
+	if err != nil {
+		return err
+	}
+	for _, path := range indexed {
+		if state.wasSeen(path) {
+			continue
+		}
+		if !state.excluded(path) {
+			if _, err := os.Lstat(path); err == nil || !errors.Is(err, fs.ErrNotExist) {
+				continue
 			}
 		}
-
-		record := FileRecord{
-			Path:     normalized,
-			Name:     info.Name(),
-			Size:     info.Size(),
-			ModTime:  info.ModTime(),
-			RootPath: root,
+		if err := w.remove(ctx, path); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		if err := idx.saveRecord(ctx, record); err != nil {
+// refreshRecord saves the file described by info through w unless the index
+// already holds a record with the same size and modification time.
+func (idx *Indexer) refreshRecord(ctx context.Context, w recordWriter, root, path string, info fs.FileInfo) error {
+	normalized := filepath.Clean(path)
+	existing, ok, err := idx.lookup(ctx, normalized)
+	if err != nil {
+		return err
+	}
+	record := newFileRecord(root, normalized, info)
+	if !ok {
+		return idx.storeFile(ctx, w, record, ChangeCreated)
+	}
+	if existing.Size != info.Size() || !existing.ModTime.Equal(info.ModTime()) {
+		return idx.storeFile(ctx, w, record, ChangeModified)
+	}
+	if existing.Inode == 0 && record.Inode != 0 {
+		existing.Device, existing.Inode = record.Device, record.Inode
+		return idx.storeFile(ctx, w, existing, changeNone)
+	}
+	if idx.needsContent(existing) {
+		return idx.storeFile(ctx, w, existing, changeNone)
+	}
+	return nil
+}
+
+// deleteTree removes path and, if it was a directory, every record beneath it.
+func (idx *Indexer) deleteTree(ctx context.Context, w recordWriter, path string) error {
+	candidates, err := idx.pathsWithin(ctx, filepath.Clean(path), 0)
+	if err != nil {
+		return err
+	}
+	for _, candidate := range candidates {
+		if err := w.remove(ctx, candidate); err != nil {
 			return err
 		}
-
-		return nil
 	}
+	return nil
+}
 
-	return filepath.WalkDir(root, walker)
+// FilesWithin lists the indexed paths equal to or nested beneath dir in
+// lexical order. A positive limit stops the listing after that many paths,
+// which are then not necessarily the lexically first ones.
+func (idx *Indexer) FilesWithin(ctx context.Context, dir string, limit int) ([]string, error) {
+	paths, err := idx.pathsWithin(ctx, filepath.Clean(dir), limit)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
 }
 
-func (idx *Indexer) removeMissing(ctx context.Context, seen map[string]struct{}, scannedRoots map[string]struct{}) error {
+// pathsWithin lists indexed paths equal to or nested beneath dir, up to limit
+// paths when limit is positive.
+func (idx *Indexer) pathsWithin(ctx context.Context, dir string, limit int) ([]string, error) {
+	if !idx.memory {
+		return idx.query.PathsWithin(ctx, dir, limit)
+	}
+
 	idx.mu.RLock()
-	candidates := make([]string, 0)
-	for path, record := range idx.files {
-		if _, ok := scannedRoots[record.RootPath]; !ok {
-			continue
+	defer idx.mu.RUnlock()
+	paths := make([]string, 0)
+	for path := range idx.files {
+		if limit > 0 && len(paths) >= limit {
+			break
 		}
-		if _, ok := seen[path]; ok {
-			continue
+		if withinDir(dir, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// removeMissing removes the records under the scanned roots and subtrees
+// that the walk did not visit, unless they still exist on disk. Records
+// beneath entries the walk could not read are kept or removed according to
+// their root's unreadable policy.
+func (idx *Indexer) removeMissing(ctx context.Context, w recordWriter, state *walkState, scannedRoots map[string]struct{}, scannedPaths []string) error {
+	candidates, err := idx.unseenPaths(ctx, state, scannedRoots)
+	if err != nil {
+		return err
+	}
+	for _, dir := range scannedPaths {
+		paths, err := idx.pathsWithin(ctx, dir, 0)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if !state.wasSeen(path) {
+				candidates = append(candidates, path)
+			}
 		}
-		candidates = append(candidates, path)
 	}
-	idx.mu.RUnlock()
 
 	for _, path := range candidates {
+		if state.wasMoved(path) {
+			continue
+		}
+		if state.excluded(path) {
+			if err := w.remove(ctx, path); err != nil {
+				return err
+			}
+			continue
+		}
+		if state.unreadable(path) {
+			root, _ := rootOf(idx.scanRoots, path)
+			if idx.rootConfig(root).unreadablePolicy() == UnreadableKeep {
+				continue
+			}
+			if err := w.remove(ctx, path); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if _, err := os.Stat(path); err == nil {
 			continue
 		} else if !errors.Is(err, fs.ErrNotExist) {
 			continue
 		}
 
-		if err := idx.deleteRecord(ctx, path); err != nil {
+		if err := w.remove(ctx, path); err != nil {
 			return err
 		}
 	}
@@ -504,57 +1025,239 @@ func (idx *Indexer) removeMissing(ctx context.Context, seen map[string]struct{},
 	return nil
 }
 
-func (idx *Indexer) saveRecord(ctx context.Context, record FileRecord) error {
+// unseenPaths lists indexed paths under the scanned roots that the walk did
+// not visit.
+func (idx *Indexer) unseenPaths(ctx context.Context, state *walkState, scannedRoots map[string]struct{}) ([]string, error) {
+	candidates := make([]string, 0)
+	if !idx.memory {
+		for root := range scannedRoots {
+			err := idx.query.RootPaths(ctx, root, func(path string) error {
+				if !state.wasSeen(path) {
+					candidates = append(candidates, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return candidates, nil
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for path, record := range idx.files {
+		if _, ok := scannedRoots[record.RootPath]; !ok {
+			continue
+		}
+		if state.wasSeen(path) {
+			continue
+		}
+		candidates = append(candidates, path)
+	}
+	return candidates, nil
+}
+
+// putFile stores a record in memory; idx.mu must be held.
+func (idx *Indexer) putFile(record FileRecord) {
+	if previous, ok := idx.files[record.Path]; ok {
+		idx.dirs.remove(previous)
+	}
+	idx.files[record.Path] = record
+	idx.names.add(record.Path, record.Name)
+	idx.dirs.add(record)
+}
+
+// removeFile drops a record from memory; idx.mu must be held.
+func (idx *Indexer) removeFile(path string) {
+	if previous, ok := idx.files[path]; ok {
+		idx.dirs.remove(previous)
+	}
+	delete(idx.files, path)
+	idx.names.remove(path)
+}
+
+// saveRecord writes record and, unless text is nil, its text for the
+// full-text index in one transaction.
+func (idx *Indexer) saveRecord(ctx context.Context, record FileRecord, kind ChangeKind, text *fileText, origin changeOrigin) error {
 	normalized := filepath.Clean(record.Path)
 	record.Path = normalized
 
-	idx.mu.Lock()
-	idx.files[normalized] = record
-	idx.mu.Unlock()
-
-	if idx.store == nil {
-		return nil
+	if idx.memory {
+		idx.mu.Lock()
+		idx.putFile(record)
+		idx.mu.Unlock()
 	}
 
-	storageRecord := storage.Record{
-		Path:     record.Path,
-		Name:     record.Name,
-		Size:     record.Size,
-		ModTime:  record.ModTime,
-		RootPath: record.RootPath,
+	switch {
+	case idx.store != nil && text != nil:
+		batch, err := idx.store.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		err = batch.Upsert(ctx, toStorageRecord(record))
+		if err == nil {
+			err = writeText(ctx, batch, record, text)
+		}
+		if err == nil {
+			err = batch.Commit()
+		}
+		if err != nil {
+			batch.Rollback()
+			return err
+		}
+	case idx.store != nil:
+		if err := idx.store.Upsert(ctx, toStorageRecord(record)); err != nil {
+			return err
+		}
 	}
 
-	return idx.store.Upsert(ctx, storageRecord)
+	return idx.recordChange(ctx, origin.change(kind, normalized, ""))
 }
 
-func (idx *Indexer) deleteRecord(ctx context.Context, path string) error {
+func (idx *Indexer) deleteRecord(ctx context.Context, path string, origin changeOrigin) error {
 	normalized := filepath.Clean(path)
 
-	idx.mu.Lock()
-	delete(idx.files, normalized)
-	idx.mu.Unlock()
+	if idx.memory {
+		idx.mu.Lock()
+		idx.removeFile(normalized)
+		idx.mu.Unlock()
+	}
 
-	if idx.store == nil {
-		return nil
+	if idx.store != nil {
+		if err := idx.store.Delete(ctx, normalized); err != nil {
+			return err
+		}
+	}
+
+	return idx.recordChange(ctx, origin.change(ChangeDeleted, normalized, ""))
+}
+
+// moveRecord renames the record at from to record's path, keeping the data
+// stored with it.
+func (idx *Indexer) moveRecord(ctx context.Context, from string, record FileRecord, origin changeOrigin) error {
+	from = filepath.Clean(from)
+	record.Path = filepath.Clean(record.Path)
+
+	if idx.memory {
+		idx.mu.Lock()
+		idx.removeFile(from)
+		idx.putFile(record)
+		idx.mu.Unlock()
+	}
+
+	if idx.store != nil {
+		if err := idx.store.Move(ctx, from, toStorageRecord(record)); err != nil {
+			return err
+		}
 	}
 
-	return idx.store.Delete(ctx, normalized)
+	return idx.recordChange(ctx, origin.change(ChangeMoved, record.Path, from))
+}
+
+func toStorageRecord(record FileRecord) storage.Record {
+	return storage.Record{
+		Path:           record.Path,
+		Name:           record.Name,
+		Size:           record.Size,
+		ModTime:        record.ModTime,
+		RootPath:       record.RootPath,
+		PartialHash:    record.PartialHash,
+		ContentHash:    record.Hash,
+		ContentIndexed: record.ContentIndexed,
+		Device:         record.Device,
+		Inode:          record.Inode,
+	}
+}
+
+func fromStorageRecord(record storage.Record) FileRecord {
+	return FileRecord{
+		Path:           filepath.Clean(record.Path),
+		Name:           record.Name,
+		Size:           record.Size,
+		ModTime:        record.ModTime,
+		RootPath:       record.RootPath,
+		PartialHash:    record.PartialHash,
+		Hash:           record.ContentHash,
+		ContentIndexed: record.ContentIndexed,
+		Device:         record.Device,
+		Inode:          record.Inode,
+	}
+}
+
+func newFileRecord(root, path string, info fs.FileInfo) FileRecord {
+	record := FileRecord{
+		Path:     path,
+		Name:     info.Name(),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		RootPath: root,
+	}
+	if id, ok := identityOf(info); ok {
+		record.Device, record.Inode = id.device, id.inode
+	}
+	return record
+}
+
+// withinDir reports whether path is dir itself or nested beneath it. Both
+// arguments must be cleaned.
+func withinDir(dir, path string) bool {
+	if path == dir {
+		return true
+	}
+	prefix := dir
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, prefix)
 }
 
 func (idx *Indexer) countFiles() int {
+	if !idx.memory {
+		return idx.storeCount()
+	}
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 	return len(idx.files)
 }
 
+// CountFiles returns the number of indexed files in roots, read from the
+// directory aggregates of each root.
+func (idx *Indexer) CountFiles(ctx context.Context, roots []string) (int, error) {
+	count := 0
+	for _, root := range roots {
+		stats, _, err := idx.directoryStats(ctx, root)
+		if err != nil {
+			return 0, err
+		}
+		count += stats.Files
+	}
+	return count, nil
+}
+
 func (idx *Indexer) updateStatus(update func(*ScanStatus)) {
 	idx.statusMu.Lock()
 	update(&idx.status)
 	idx.statusMu.Unlock()
+	idx.statusChanged()
 }
 
-func matchesQuery(record FileRecord, query Query, matchName func(string) bool, allowedExts map[string]struct{}) bool {
-	if matchName != nil && !matchName(record.Name) {
+func matchesQuery(record FileRecord, query Query, allowedExts, allowedRoots map[string]struct{}) bool {
+	if query.expr != nil && !querylang.Match(query.expr, querylang.Record{
+		Name:    record.Name,
+		Path:    record.Path,
+		Root:    record.RootPath,
+		Size:    record.Size,
+		ModTime: record.ModTime,
+	}) {
+		return false
+	}
+	if allowedRoots != nil {
+		if _, ok := allowedRoots[record.RootPath]; !ok {
+			return false
+		}
+	}
+	if query.Dir != "" && filepath.Dir(record.Path) != filepath.Clean(query.Dir) {
 		return false
 	}
 	if len(allowedExts) > 0 {
@@ -581,37 +1284,16 @@ func matchesQuery(record FileRecord, query Query, matchName func(string) bool, a
 	return true
 }
 
-func buildNameMatcher(pattern string) func(string) bool {
-	trimmed := strings.TrimSpace(pattern)
-	if trimmed == "" {
+// rootSet returns the roots as a set, or nil when roots is nil.
+func rootSet(roots []string) map[string]struct{} {
+	if roots == nil {
 		return nil
 	}
-
-	lowered := strings.ToLower(trimmed)
-	if strings.ContainsAny(lowered, "*?") {
-		regexPattern := wildcardToRegex(lowered)
-		if regexPattern != "" {
-			if re, err := regexp.Compile("^" + regexPattern + "$"); err == nil {
-				return func(name string) bool {
-					return re.MatchString(strings.ToLower(name))
-				}
-			}
-		}
-	}
-
-	return func(name string) bool {
-		return strings.Contains(strings.ToLower(name), lowered)
+	set := make(map[string]struct{}, len(roots))
+	for _, root := range roots {
+		set[root] = struct{}{}
 	}
-}
-
-func wildcardToRegex(pattern string) string {
-	if pattern == "" {
-		return ""
-	}
-	escaped := regexp.QuoteMeta(pattern)
-	escaped = strings.ReplaceAll(escaped, "\\*", ".*")
-	escaped = strings.ReplaceAll(escaped, "\\?", ".")
-	return escaped
+	return set
 }
 
 func compareRecords(a, b FileRecord, field string) int {
@@ -635,9 +1317,18 @@ func compareRecords(a, b FileRecord, field string) int {
 			return 0
 		}
 	case "path":
-		return strings.Compare(strings.ToLower(a.Path), strings.ToLower(b.Path))
+		return strings.Compare(a.Path, b.Path)
+	case "relevance":
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		default:
+			return 0
+		}
 	default:
-		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		return compareNoCase(a.Name, b.Name)
 	}
 }
 
@@ -0,0 +1,302 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"seekfile/internal/storage/sqlite"
+)
+
+// testTime is the modification time given to the files of the tests.
+var testTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newTestIndexer returns a store-backed indexer over the given number of
+// fresh roots, along with the roots.
+func newTestIndexer(t *testing.T, roots int, opts ...Option) (*Indexer, []string) {
+	t.Helper()
+	dirs := make([]string, roots)
+	for i := range dirs {
+		dirs[i] = t.TempDir()
+	}
+	idx := newStoreIndexer(t, dirs, opts...)
+	return idx, idx.Roots()
+}
+
+// newStoreIndexer returns an indexer over roots backed by a fresh store.
+func newStoreIndexer(t *testing.T, roots []string, opts ...Option) *Indexer {
+	t.Helper()
+	store, err := sqlite.Open(filepath.Join(t.TempDir(), "seekfile.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	idx, err := New(roots, store, append([]Option{WithMemoryIndex(false)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+// writeFile creates path and its parent directories with the given content
+// and testTime as its modification time.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, testTime, testTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// scan queues a scan and waits for it to end.
+func scan(t *testing.T, idx *Indexer, req ScanRequest) ScanJob {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	job, err := idx.QueueScan(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err = idx.WaitScan(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != ScanJobFinished {
+		t.Fatalf("scan %d ended %s: %s", job.ID, job.State, job.Error)
+	}
+	return job
+}
+
+// searchPaths returns the paths of the files matching query, in order.
+func searchPaths(t *testing.T, idx *Indexer, query Query) []string {
+	t.Helper()
+	result, err := idx.Search(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, 0, len(result.Files))
+	for _, file := range result.Files {
+		paths = append(paths, file.Path)
+	}
+	return paths
+}
+
+func TestFullScanKeepsStateOfUnchangedFiles(t *testing.T) {
+	idx, roots := newTestIndexer(t, 1, WithContentHashing(true), WithContentIndexing(ContentOptions{}))
+	first := filepath.Join(roots[0], "first.txt")
+	second := filepath.Join(roots[0], "second.txt")
+	writeFile(t, first, "alpha bravo")
+	writeFile(t, second, "alpha bravo")
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	before, ok := idx.Lookup(first)
+	if !ok || before.Hash == "" || before.PartialHash == "" || !before.ContentIndexed {
+		t.Fatalf("Lookup(%s) = %+v, %t, want a hashed and content indexed record", first, before, ok)
+	}
+
+	// Rewrite the file without changing its size or modification time, which
+	// scans take to mean the file is unchanged.
+	writeFile(t, first, "gamma delta")
+	scan(t, idx, ScanRequest{Mode: ScanModeFull})
+
+	after, ok := idx.Lookup(first)
+	if !ok {
+		t.Fatalf("Lookup(%s) found nothing after a full scan", first)
+	}
+	if after.Hash != before.Hash || after.PartialHash != before.PartialHash || !after.ContentIndexed {
+		t.Fatalf("full scan replaced the state of an unchanged file: %+v, want %+v", after, before)
+	}
+	if got := searchPaths(t, idx, Query{Content: "bravo"}); !slices.Equal(got, []string{first, second}) {
+		t.Fatalf("content search after a full scan = %v, want both files", got)
+	}
+}
+
+func TestScanRecordsMoves(t *testing.T) {
+	var changes changeRecorder
+	idx, roots := newTestIndexer(t, 2, WithChangeHandler(changes.record))
+	late := filepath.Join(roots[0], "z-late.txt")
+	cross := filepath.Join(roots[0], "cross.txt")
+	kept := filepath.Join(roots[0], "kept.txt")
+	writeFile(t, late, "late")
+	writeFile(t, cross, "cross")
+	writeFile(t, kept, "kept")
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	// The new path of the first file is walked before its old one, the second
+	// file moves to the other root, and the third gains a hard link.
+	early := filepath.Join(roots[0], "a-early.txt")
+	crossed := filepath.Join(roots[1], "cross.txt")
+	link := filepath.Join(roots[0], "link.txt")
+	if err := os.Rename(late, early); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(cross, crossed); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(kept, link); err != nil {
+		t.Fatal(err)
+	}
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	// Each file was created by the first scan.
+	for path, want := range map[string][]ChangeKind{
+		late:  {ChangeCreated, ChangeMoved},
+		cross: {ChangeCreated, ChangeMoved},
+		kept:  {ChangeCreated},
+		link:  {ChangeCreated},
+	} {
+		if got := changes.kinds(ChangeCauseScan, path); !slices.Equal(got, want) {
+			t.Errorf("changes of %s = %v, want %v", path, got, want)
+		}
+	}
+	for _, path := range []string{early, crossed, kept, link} {
+		if _, ok := idx.Lookup(path); !ok {
+			t.Errorf("%s is not indexed", path)
+		}
+	}
+	for _, path := range []string{late, cross} {
+		if _, ok := idx.Lookup(path); ok {
+			t.Errorf("%s is still indexed after it moved", path)
+		}
+	}
+}
+
+func TestUnreadablePolicy(t *testing.T) {
+	for _, policy := range []UnreadablePolicy{UnreadableKeep, UnreadableRemove} {
+		// newIndexer indexes a root holding the given files.
+		newIndexer := func(t *testing.T, root string, files ...string) *Indexer {
+			for _, file := range files {
+				writeFile(t, filepath.Join(root, file), file)
+			}
+			idx := newStoreIndexer(t, []string{root}, WithRootOptions(root, RootOptions{Unreadable: policy}))
+			scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+			return idx
+		}
+		// wantIndexed checks that the paths are indexed as the policy says.
+		wantIndexed := func(t *testing.T, idx *Indexer, paths ...string) {
+			for _, path := range paths {
+				if _, ok := idx.Lookup(path); ok != (policy == UnreadableKeep) {
+					t.Errorf("Lookup(%s) = %t with policy %s", path, ok, policy)
+				}
+			}
+		}
+
+		t.Run(string(policy)+"/directory", func(t *testing.T) {
+			root := t.TempDir()
+			idx := newIndexer(t, root, "open/a.txt", "locked/b.txt", "locked/sub/c.txt")
+			locked := filepath.Join(root, "locked")
+			if err := os.Chmod(locked, 0); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.Chmod(locked, 0o755) })
+			if _, err := os.ReadDir(locked); err == nil {
+				t.Skip("directory permissions are not enforced for this user")
+			}
+
+			job := scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+			if job.WalkErrors == nil || job.WalkErrors.Kinds[WalkErrorPermission] != 1 || job.WalkErrors.Errors[0].Path != locked {
+				t.Fatalf("walk errors = %+v, want a permission error for %s", job.WalkErrors, locked)
+			}
+			if _, ok := idx.Lookup(filepath.Join(root, "open", "a.txt")); !ok {
+				t.Error("the readable file was removed")
+			}
+			wantIndexed(t, idx, filepath.Join(locked, "b.txt"), filepath.Join(locked, "sub", "c.txt"))
+		})
+
+		// A root whose parent turned into a file cannot be read, yet does not
+		// count as gone; unlike permissions, this holds for every user.
+		t.Run(string(policy)+"/root", func(t *testing.T) {
+			parent := filepath.Join(t.TempDir(), "parent")
+			root := filepath.Join(parent, "root")
+			idx := newIndexer(t, root, "a.txt", "sub/b.txt")
+			if err := os.RemoveAll(parent); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, parent, "not a directory")
+
+			job := scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+			if job.WalkErrors == nil || job.WalkErrors.Count != 1 || job.WalkErrors.Errors[0].Path != root {
+				t.Fatalf("walk errors = %+v, want an error for %s", job.WalkErrors, root)
+			}
+			wantIndexed(t, idx, filepath.Join(root, "a.txt"), filepath.Join(root, "sub", "b.txt"))
+		})
+	}
+}
+
+func TestSubtreeScan(t *testing.T) {
+	idx, roots := newTestIndexer(t, 2)
+	root := roots[0]
+	sub := filepath.Join(root, "sub")
+	first := filepath.Join(sub, "first.txt")
+	other := filepath.Join(root, "other", "other.txt")
+	writeFile(t, first, "first")
+	writeFile(t, other, "other")
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+	before, err := idx.store.ScanState(t.Context(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := idx.QueueScan(t.Context(), ScanRequest{Paths: []string{t.TempDir()}}); !errors.Is(err, ErrOutsideRoots) {
+		t.Fatalf("scan of a path outside the roots: err = %v, want ErrOutsideRoots", err)
+	}
+
+	// Only the subtree is brought up to date; nested paths fold into it.
+	second := filepath.Join(sub, "second.txt")
+	writeFile(t, second, "second")
+	for _, path := range []string{first, other} {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job := scan(t, idx, ScanRequest{Mode: ScanModeIncremental, Paths: []string{filepath.Join(sub, "nested"), sub, roots[1]}})
+	if len(job.Targets) != 2 || !slices.Equal(job.Targets[0].Paths, []string{sub}) || job.Targets[1].Root != roots[1] || job.Targets[1].Paths != nil {
+		t.Fatalf("targets = %+v, want %s within the first root and the whole second root", job.Targets, sub)
+	}
+	for path, want := range map[string]bool{first: false, second: true, other: true} {
+		if _, ok := idx.Lookup(path); ok != want {
+			t.Errorf("Lookup(%s) = %t after the subtree scan, want %t", path, ok, want)
+		}
+	}
+
+	// The scan times of a root only advance when all of it is scanned.
+	after, err := idx.store.ScanState(t.Context(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.LastIncrementalScan.Equal(before.LastIncrementalScan) {
+		t.Errorf("subtree scan moved the last incremental scan from %s to %s", before.LastIncrementalScan, after.LastIncrementalScan)
+	}
+	otherRoot, err := idx.store.ScanState(t.Context(), roots[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !otherRoot.LastIncrementalScan.After(before.LastIncrementalScan) {
+		t.Errorf("scan of the whole second root left its last incremental scan at %s", otherRoot.LastIncrementalScan)
+	}
+
+	// A subtree that is gone takes its records with it.
+	if err := os.RemoveAll(sub); err != nil {
+		t.Fatal(err)
+	}
+	job = scan(t, idx, ScanRequest{Mode: ScanModeIncremental, Paths: []string{sub}})
+	if job.WalkErrors != nil {
+		t.Errorf("scan of a removed subtree reported %+v", job.WalkErrors)
+	}
+	if _, ok := idx.Lookup(second); ok {
+		t.Errorf("%s is still indexed after its directory was removed", second)
+	}
+	if _, ok := idx.Lookup(other); !ok {
+		t.Errorf("%s was removed by a scan of another subtree", other)
+	}
+}
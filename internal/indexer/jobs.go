@@ -0,0 +1,532 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// scanJobHistory is the number of ended jobs kept for ScanJobs and
+// LookupScan.
+const scanJobHistory = 50
+
+var (
+	// ErrScanNotFound is returned for a scan job ID that is neither queued,
+	// running nor among the recently ended jobs.
+	ErrScanNotFound = errors.New("scan job not found")
+	// ErrScanEnded is returned when cancelling a job that has already ended.
+	ErrScanEnded = errors.New("scan job already ended")
+)
+
+// ScanJobState is the lifecycle state of a scan job.
+type ScanJobState string
+
+const (
+	// ScanJobQueued jobs wait for their roots to be free of other scans.
+	ScanJobQueued   ScanJobState = "queued"
+	ScanJobRunning  ScanJobState = "running"
+	ScanJobFinished ScanJobState = "finished"
+	ScanJobFailed   ScanJobState = "failed"
+	// ScanJobCanceled jobs were cancelled while queued or running.
+	ScanJobCanceled ScanJobState = "canceled"
+	// ScanJobSuperseded jobs were dropped from the queue because a job queued
+	// after them covers all of their roots.
+	ScanJobSuperseded ScanJobState = "superseded"
+	// ScanJobSkipped jobs made up for a scheduled run that was missed, but the
+	// roots were scanned before they could start.
+	ScanJobSkipped ScanJobState = "skipped"
+)
+
+// ScanRequest describes a scan to queue.
+type ScanRequest struct {
+	Mode ScanMode
+	// Paths limits the scan to these scan roots or paths inside them. Empty
+	// scans every root. Records beneath the paths that no longer exist on disk
+	// are removed, while the rest of the index is left alone; the recorded
+	// scan times of a root, which incremental scans and the scheduler rely on,
+	// only advance when the whole root is scanned.
+	Paths []string
+}
+
+// ScanTarget is a root scanned by a job and, when the job does not cover all
+// of it, the subtrees it walks.
+type ScanTarget struct {
+	Root  string   `json:"root"`
+	Paths []string `json:"paths,omitempty"`
+}
+
+// ScanJob is a snapshot of a queued, running or ended scan.
+type ScanJob struct {
+	// ID identifies the job; changes made by it are logged with this ID.
+	ID          int64        `json:"id"`
+	Mode        string       `json:"mode"`
+	State       ScanJobState `json:"state"`
+	Targets     []ScanTarget `json:"targets"`
+	Phase       string       `json:"phase,omitempty"`
+	CurrentPath string       `json:"currentPath"`
+	Processed   int64        `json:"processed"`
+	QueuedAt    time.Time    `json:"queuedAt"`
+	StartedAt   time.Time    `json:"startedAt"`
+	FinishedAt  time.Time    `json:"finishedAt"`
+	Error       string       `json:"error,omitempty"`
+	// SupersededBy is the job that took over the roots of a superseded job.
+	SupersededBy int64 `json:"supersededBy,omitempty"`
+	// WalkErrors reports the directories and files the job could not read.
+	WalkErrors *WalkErrors `json:"walkErrors,omitempty"`
+}
+
+// scanJob is a job tracked by the indexer. The embedded snapshot is guarded
+// by idx.statusMu.
+type scanJob struct {
+	ScanJob
+	ctx       context.Context
+	cancel    context.CancelFunc
+	processed atomic.Int64
+	// bytes totals the size of the processed files.
+	bytes atomic.Int64
+	// since is set for runs made up for a missed schedule: roots scanned in
+	// the job's mode since then are dropped when the job starts.
+	since time.Time
+	// walk is the walk state of a running job, from which WalkErrors is
+	// taken until the job ends.
+	walk *walkState
+	// current and roots track the progress of a running job without
+	// idx.statusMu; snapshots copy them into CurrentPath and Processed.
+	current atomic.Pointer[string]
+	roots   map[string]*rootProgress
+	done    chan struct{}
+}
+
+// rootProgress is the progress of a running job in one of its roots.
+type rootProgress struct {
+	processed atomic.Int64
+	current   atomic.Pointer[string]
+}
+
+// track records that the job has reached path in root.
+func (job *scanJob) track(root, path string) {
+	job.current.Store(&path)
+	if progress := job.roots[root]; progress != nil {
+		progress.processed.Add(1)
+		progress.current.Store(&path)
+	}
+}
+
+func (job *scanJob) snapshot() ScanJob {
+	snapshot := job.ScanJob
+	snapshot.Targets = slices.Clone(job.Targets)
+	snapshot.WalkErrors = job.walkErrors()
+	if job.State == ScanJobRunning {
+		snapshot.Processed = job.processed.Load()
+		if path := job.current.Load(); path != nil {
+			snapshot.CurrentPath = *path
+		}
+	}
+	return snapshot
+}
+
+// walkErrors returns the walk errors of the job, as far as it has got.
+func (job *scanJob) walkErrors() *WalkErrors {
+	if job.walk != nil {
+		return job.walk.walkErrors()
+	}
+	return job.WalkErrors
+}
+
+// QueueScan queues a scan and returns the job that performs it. Jobs start
+// in the order they were queued as soon as none of their roots is being
+// scanned by another job, so scans of different roots run concurrently.
+// When jobs already queued cover the request, the first of them is returned
+// instead of a new job; a new job in turn supersedes the queued scans of the
+// same roots that it covers, such as a full scan replacing an incremental
+// one. The job runs until it ends or ctx is cancelled.
+func (idx *Indexer) QueueScan(ctx context.Context, req ScanRequest) (ScanJob, error) {
+	targets := wholeRoots(idx.scanRoots)
+	if len(req.Paths) > 0 {
+		var err error
+		if targets, err = idx.scanTargets(req.Paths); err != nil {
+			return ScanJob{}, err
+		}
+	}
+	return idx.queueScan(ctx, req.Mode, targets, time.Time{}), nil
+}
+
+// queueScan queues a job scanning targets in mode; see QueueScan. A job with
+// a since time never supersedes other jobs, as it may turn out to be
+// skipped.
+func (idx *Indexer) queueScan(ctx context.Context, mode ScanMode, targets []ScanTarget, since time.Time) ScanJob {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	idx.statusMu.Lock()
+	var covering *scanJob
+	remaining := make([]ScanTarget, 0, len(targets))
+	for _, target := range targets {
+		if job := idx.coveringJob(mode, target); job != nil {
+			if covering == nil {
+				covering = job
+			}
+			continue
+		}
+		remaining = append(remaining, target)
+	}
+	if len(remaining) == 0 {
+		snapshot := covering.snapshot()
+		idx.statusMu.Unlock()
+		return snapshot
+	}
+
+	// IDs follow the clock in milliseconds so that they stay unique across
+	// restarts, as the change log keeps them, and exact as JavaScript numbers.
+	now := time.Now()
+	idx.lastJobID = max(idx.lastJobID+1, now.UnixMilli())
+	job := &scanJob{
+		ScanJob: ScanJob{
+			ID:       idx.lastJobID,
+			Mode:     string(mode),
+			State:    ScanJobQueued,
+			Targets:  remaining,
+			QueuedAt: now,
+		},
+		since: since,
+		done:  make(chan struct{}),
+	}
+	job.ctx, job.cancel = context.WithCancel(ctx)
+	if since.IsZero() {
+		idx.supersede(job)
+	}
+	idx.jobs = append(idx.jobs, job)
+	started := idx.dispatch()
+	snapshot := job.snapshot()
+	idx.statusMu.Unlock()
+
+	idx.statusChanged()
+	idx.launch(started)
+	return snapshot
+}
+
+// scanTargets groups paths by scan root in root order, folding paths nested
+// in another one into it.
+func (idx *Indexer) scanTargets(paths []string) ([]ScanTarget, error) {
+	byRoot := make(map[string]map[string]struct{})
+	for _, raw := range paths {
+		abs, err := filepath.Abs(raw)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Clean(abs)
+		root, ok := rootOf(idx.scanRoots, path)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrOutsideRoots, path)
+		}
+		if byRoot[root] == nil {
+			byRoot[root] = make(map[string]struct{})
+		}
+		byRoot[root][path] = struct{}{}
+	}
+
+	targets := make([]ScanTarget, 0, len(byRoot))
+	for _, root := range idx.scanRoots {
+		subtrees, ok := byRoot[root]
+		if !ok {
+			continue
+		}
+		target := ScanTarget{Root: root}
+		if _, whole := subtrees[root]; !whole {
+			target.Paths = collapseDirs(subtrees)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// wholeRoots returns targets covering every one of roots.
+func wholeRoots(roots []string) []ScanTarget {
+	targets := make([]ScanTarget, 0, len(roots))
+	for _, root := range roots {
+		targets = append(targets, ScanTarget{Root: root})
+	}
+	return targets
+}
+
+// covers reports whether scanning a in aMode does everything scanning b in
+// bMode would: a full scan covers an incremental one, and a whole root
+// covers its subtrees.
+func covers(aMode ScanMode, a ScanTarget, bMode ScanMode, b ScanTarget) bool {
+	if a.Root != b.Root || (aMode == ScanModeIncremental && bMode == ScanModeFull) {
+		return false
+	}
+	if a.Paths == nil {
+		return true
+	}
+	if b.Paths == nil {
+		return false
+	}
+	for _, path := range b.Paths {
+		if !slices.ContainsFunc(a.Paths, func(dir string) bool { return withinDir(dir, path) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// coveringJob returns the first queued job that covers target. The caller
+// must hold idx.statusMu.
+func (idx *Indexer) coveringJob(mode ScanMode, target ScanTarget) *scanJob {
+	for _, job := range idx.jobs {
+		if job.State != ScanJobQueued {
+			continue
+		}
+		for _, queued := range job.Targets {
+			if covers(ScanMode(job.Mode), queued, mode, target) {
+				return job
+			}
+		}
+	}
+	return nil
+}
+
+// supersede drops the targets of queued jobs that job covers. Jobs left
+// without targets end, keeping their targets for the record. The caller must
+// hold idx.statusMu.
+func (idx *Indexer) supersede(job *scanJob) {
+	var ended []*scanJob
+	for _, queued := range idx.jobs {
+		if queued.State != ScanJobQueued {
+			continue
+		}
+		remaining := slices.DeleteFunc(slices.Clone(queued.Targets), func(target ScanTarget) bool {
+			return slices.ContainsFunc(job.Targets, func(covering ScanTarget) bool {
+				return covers(ScanMode(job.Mode), covering, ScanMode(queued.Mode), target)
+			})
+		})
+		if len(remaining) == 0 {
+			queued.State = ScanJobSuperseded
+			queued.SupersededBy = job.ID
+			ended = append(ended, queued)
+			continue
+		}
+		queued.Targets = remaining
+	}
+	for _, queued := range ended {
+		idx.endJob(queued)
+	}
+}
+
+// dispatch marks the queued jobs whose roots are free as running and returns
+// them for launch. A queued job holds its roots against the jobs queued after
+// it, so that scans of a root run in the order they were queued. The caller
+// must hold idx.statusMu.
+func (idx *Indexer) dispatch() []*scanJob {
+	busy := make(map[string]struct{})
+	for _, job := range idx.jobs {
+		if job.State == ScanJobRunning {
+			for _, target := range job.Targets {
+				busy[target.Root] = struct{}{}
+			}
+		}
+	}
+
+	var started, ended []*scanJob
+	for _, job := range idx.jobs {
+		if job.State != ScanJobQueued {
+			continue
+		}
+		free := !slices.ContainsFunc(job.Targets, func(target ScanTarget) bool {
+			_, ok := busy[target.Root]
+			return ok
+		})
+		if free && !job.since.IsZero() {
+			remaining := idx.unscannedTargets(job)
+			if len(remaining) == 0 {
+				job.State = ScanJobSkipped
+				ended = append(ended, job)
+				continue
+			}
+			job.Targets = remaining
+		}
+		for _, target := range job.Targets {
+			busy[target.Root] = struct{}{}
+		}
+		if !free {
+			continue
+		}
+
+		now := time.Now()
+		job.State = ScanJobRunning
+		job.Phase = scanPhaseWalking
+		job.StartedAt = now
+		job.roots = make(map[string]*rootProgress, len(job.Targets))
+		for _, target := range job.Targets {
+			job.roots[target.Root] = &rootProgress{}
+			if entry := idx.status.root(target.Root); entry != nil {
+				*entry = RootScanStatus{
+					Root:              target.Root,
+					Paths:             target.Paths,
+					Mode:              job.Mode,
+					Running:           true,
+					StartedAt:         now,
+					LastSuccessfulRun: entry.LastSuccessfulRun,
+				}
+			}
+		}
+		idx.lastJob = job
+		started = append(started, job)
+	}
+	for _, job := range ended {
+		idx.endJob(job)
+	}
+	return started
+}
+
+// unscannedTargets returns the targets of a job whose roots were not scanned
+// in the job's mode since its since time. The caller must hold idx.statusMu.
+func (idx *Indexer) unscannedTargets(job *scanJob) []ScanTarget {
+	if idx.store == nil {
+		return job.Targets
+	}
+	return slices.DeleteFunc(slices.Clone(job.Targets), func(target ScanTarget) bool {
+		state, err := idx.store.ScanState(job.ctx, target.Root)
+		last, ok := lastScan(state, ScanMode(job.Mode))
+		return err == nil && ok && !last.Before(job.since)
+	})
+}
+
+// launch runs the jobs returned by dispatch.
+func (idx *Indexer) launch(jobs []*scanJob) {
+	for _, job := range jobs {
+		idx.statusMu.RLock()
+		snapshot := job.snapshot()
+		idx.statusMu.RUnlock()
+		idx.publishStatus(StatusEventScanStarted, &snapshot)
+		go idx.runScan(job)
+	}
+}
+
+// endJob moves a job from the queue to the history. The caller must hold
+// idx.statusMu.
+func (idx *Indexer) endJob(job *scanJob) {
+	if job.FinishedAt.IsZero() {
+		job.FinishedAt = time.Now()
+	}
+	job.cancel()
+	close(job.done)
+	idx.jobs = slices.DeleteFunc(idx.jobs, func(other *scanJob) bool { return other == job })
+	idx.history = append(idx.history, job.snapshot())
+	if len(idx.history) > scanJobHistory {
+		idx.history = slices.Delete(idx.history, 0, len(idx.history)-scanJobHistory)
+	}
+}
+
+// ScanJobs lists the running and queued jobs in queue order, followed by the
+// recently ended jobs, most recent first.
+func (idx *Indexer) ScanJobs() []ScanJob {
+	idx.statusMu.RLock()
+	defer idx.statusMu.RUnlock()
+	jobs := make([]ScanJob, 0, len(idx.jobs)+len(idx.history))
+	for _, job := range idx.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	for i := len(idx.history) - 1; i >= 0; i-- {
+		jobs = append(jobs, idx.history[i])
+	}
+	return jobs
+}
+
+// LookupScan returns a queued, running or recently ended job.
+func (idx *Indexer) LookupScan(id int64) (ScanJob, bool) {
+	idx.statusMu.RLock()
+	defer idx.statusMu.RUnlock()
+	if job := idx.activeJob(id); job != nil {
+		return job.snapshot(), true
+	}
+	for _, job := range idx.history {
+		if job.ID == id {
+			return job, true
+		}
+	}
+	return ScanJob{}, false
+}
+
+// CancelScan cancels a queued or running job. A running job ends once its
+// workers notice; the returned snapshot may still show it running.
+func (idx *Indexer) CancelScan(id int64) (ScanJob, error) {
+	idx.statusMu.Lock()
+	job := idx.activeJob(id)
+	if job == nil {
+		ended := slices.ContainsFunc(idx.history, func(job ScanJob) bool { return job.ID == id })
+		idx.statusMu.Unlock()
+		if ended {
+			return ScanJob{}, ErrScanEnded
+		}
+		return ScanJob{}, ErrScanNotFound
+	}
+
+	var started []*scanJob
+	if job.State == ScanJobQueued {
+		job.State = ScanJobCanceled
+		job.Error = context.Canceled.Error()
+		idx.endJob(job)
+		started = idx.dispatch()
+	} else {
+		job.cancel()
+	}
+	snapshot := job.snapshot()
+	idx.statusMu.Unlock()
+
+	idx.statusChanged()
+	idx.launch(started)
+	return snapshot, nil
+}
+
+// WaitScan blocks until the job ends or ctx is cancelled and returns its
+// final snapshot.
+func (idx *Indexer) WaitScan(ctx context.Context, id int64) (ScanJob, error) {
+	idx.statusMu.RLock()
+	job := idx.activeJob(id)
+	idx.statusMu.RUnlock()
+	if job != nil {
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return ScanJob{}, ctx.Err()
+		}
+	}
+	snapshot, ok := idx.LookupScan(id)
+	if !ok {
+		return ScanJob{}, ErrScanNotFound
+	}
+	return snapshot, nil
+}
+
+// activeJob returns the queued or running job with the given ID. The caller
+// must hold idx.statusMu.
+func (idx *Indexer) activeJob(id int64) *scanJob {
+	for _, job := range idx.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// queuedFor reports whether a queued job scans the whole root in mode or in
+// a mode covering it.
+func (idx *Indexer) queuedFor(root string, mode ScanMode) bool {
+	idx.statusMu.RLock()
+	defer idx.statusMu.RUnlock()
+	target := ScanTarget{Root: root}
+	for _, job := range idx.jobs {
+		if job.State == ScanJobQueued && slices.ContainsFunc(job.Targets, func(queued ScanTarget) bool {
+			return covers(ScanMode(job.Mode), queued, mode, target)
+		}) {
+			return true
+		}
+	}
+	return false
+}
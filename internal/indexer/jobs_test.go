@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// holdRoots marks roots as busy with a running job, so that the jobs queued
+// after it stay in the queue. The returned function ends the job and starts
+// the queued ones; the test waits for them to end before it is cleaned up.
+func holdRoots(t *testing.T, idx *Indexer, roots []string) func() {
+	t.Helper()
+	job := &scanJob{
+		ScanJob: ScanJob{ID: 1, State: ScanJobRunning, Targets: wholeRoots(roots)},
+		done:    make(chan struct{}),
+	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	idx.statusMu.Lock()
+	idx.jobs = append(idx.jobs, job)
+	idx.statusMu.Unlock()
+	t.Cleanup(func() {
+		for _, job := range idx.ScanJobs() {
+			waitScan(t, idx, job.ID)
+		}
+	})
+
+	return func() {
+		idx.statusMu.Lock()
+		job.State = ScanJobFinished
+		idx.endJob(job)
+		started := idx.dispatch()
+		idx.statusMu.Unlock()
+		idx.launch(started)
+	}
+}
+
+// queue queues a scan of paths in mode.
+func queue(t *testing.T, idx *Indexer, mode ScanMode, paths ...string) ScanJob {
+	t.Helper()
+	job, err := idx.QueueScan(context.Background(), ScanRequest{Mode: mode, Paths: paths})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// lookupScan returns the snapshot of a job the test queued.
+func lookupScan(t *testing.T, idx *Indexer, id int64) ScanJob {
+	t.Helper()
+	job, ok := idx.LookupScan(id)
+	if !ok {
+		t.Fatalf("scan %d not found", id)
+	}
+	return job
+}
+
+// waitScan waits for a job the test queued to end.
+func waitScan(t *testing.T, idx *Indexer, id int64) ScanJob {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	job, err := idx.WaitScan(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestQueueReturnsCoveringJob(t *testing.T) {
+	idx, roots := newTestIndexer(t, 2)
+	sub := filepath.Join(roots[0], "sub")
+	other := filepath.Join(roots[1], "other")
+	writeFile(t, filepath.Join(sub, "file.txt"), "file")
+	writeFile(t, filepath.Join(other, "file.txt"), "file")
+	release := holdRoots(t, idx, roots)
+
+	incremental := queue(t, idx, ScanModeIncremental, roots[0])
+	for _, path := range []string{roots[0], sub} {
+		if job := queue(t, idx, ScanModeIncremental, path); job.ID != incremental.ID {
+			t.Errorf("incremental scan of %s queued job %d, want the covering job %d", path, job.ID, incremental.ID)
+		}
+	}
+
+	// Only the roots no queued job covers are left to a new job.
+	both := queue(t, idx, ScanModeIncremental)
+	if both.ID == incremental.ID || len(both.Targets) != 1 || both.Targets[0].Root != roots[1] {
+		t.Errorf("scan of both roots queued job %d with targets %v, want a new job for %s", both.ID, both.Targets, roots[1])
+	}
+
+	// An incremental scan does not cover a full one, and a subtree does not
+	// cover its root.
+	if job := queue(t, idx, ScanModeFull, sub); job.ID == incremental.ID {
+		t.Errorf("full scan of %s returned the incremental job", sub)
+	}
+	subtree := queue(t, idx, ScanModeFull, other)
+	whole := queue(t, idx, ScanModeFull, roots[1])
+	if whole.ID == subtree.ID {
+		t.Errorf("full scan of %s returned the job of its subtree", roots[1])
+	}
+
+	release()
+	for _, id := range []int64{incremental.ID, whole.ID} {
+		if job := waitScan(t, idx, id); job.State != ScanJobFinished {
+			t.Errorf("job %d ended %s: %s", id, job.State, job.Error)
+		}
+	}
+}
+
+func TestQueueSupersedesCoveredJobs(t *testing.T) {
+	idx, roots := newTestIndexer(t, 2)
+	sub := filepath.Join(roots[0], "sub")
+	writeFile(t, filepath.Join(sub, "file.txt"), "file")
+	release := holdRoots(t, idx, roots)
+
+	subtree := queue(t, idx, ScanModeFull, sub)
+	both := queue(t, idx, ScanModeIncremental)
+	full := queue(t, idx, ScanModeFull, roots[0])
+
+	// The full scan of the first root covers the subtree job entirely and the
+	// scan of both roots in the first root only.
+	if job := lookupScan(t, idx, subtree.ID); job.State != ScanJobSuperseded || job.SupersededBy != full.ID {
+		t.Errorf("subtree job = %s superseded by %d, want superseded by %d", job.State, job.SupersededBy, full.ID)
+	}
+	job := lookupScan(t, idx, both.ID)
+	if job.State != ScanJobQueued || len(job.Targets) != 1 || job.Targets[0].Root != roots[1] || job.Targets[0].Paths != nil {
+		t.Errorf("job of both roots = %s with targets %v, want it queued for %s only", job.State, job.Targets, roots[1])
+	}
+
+	// Made-up runs for a missed schedule never supersede, as they may yet be
+	// skipped.
+	missed := idx.queueScan(context.Background(), ScanModeFull, wholeRoots(roots), time.Now())
+	if job := lookupScan(t, idx, both.ID); job.State != ScanJobQueued {
+		t.Errorf("job of both roots = %s after a made-up run was queued, want queued", job.State)
+	}
+
+	release()
+	for _, id := range []int64{both.ID, full.ID, missed.ID} {
+		job := waitScan(t, idx, id)
+		if job.State != ScanJobFinished && job.State != ScanJobSkipped {
+			t.Errorf("job %d ended %s: %s", id, job.State, job.Error)
+		}
+	}
+	if job := lookupScan(t, idx, subtree.ID); job.State != ScanJobSuperseded {
+		t.Errorf("superseded job ended %s", job.State)
+	}
+}
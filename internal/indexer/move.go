@@ -0,0 +1,191 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// fileID identifies a file by device and inode number, which stay the same
+// when the file is renamed or moved within a filesystem.
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+func (r FileRecord) identity() (fileID, bool) {
+	return fileID{device: r.Device, inode: r.Inode}, r.Inode != 0
+}
+
+// identityIndex finds indexed records by file identity during a scan. With
+// the in-memory index it is a snapshot taken when the scan starts; otherwise
+// it queries the store.
+type identityIndex struct {
+	idx   *Indexer
+	paths map[fileID][]string
+}
+
+func (idx *Indexer) newIdentityIndex() *identityIndex {
+	ids := &identityIndex{idx: idx}
+	if !idx.memory {
+		return ids
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	ids.paths = make(map[fileID][]string)
+	for path, record := range idx.files {
+		if id, ok := record.identity(); ok {
+			ids.paths[id] = append(ids.paths[id], path)
+		}
+	}
+	return ids
+}
+
+func (ids *identityIndex) lookup(ctx context.Context, id fileID) ([]FileRecord, error) {
+	if !ids.idx.memory {
+		stored, err := ids.idx.query.LookupIdentity(ctx, id.device, id.inode)
+		if err != nil {
+			return nil, err
+		}
+		records := make([]FileRecord, 0, len(stored))
+		for _, record := range stored {
+			records = append(records, fromStorageRecord(record))
+		}
+		return records, nil
+	}
+
+	records := make([]FileRecord, 0, len(ids.paths[id]))
+	for _, path := range ids.paths[id] {
+		record, ok, err := ids.idx.lookup(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if ok && record.Inode == id.inode && record.Device == id.device {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// moveMatch is the outcome of looking for the previous location of a file.
+type moveMatch int
+
+const (
+	moveNone     moveMatch = iota // the file is new
+	moveFound                     // the file moved from an indexed path
+	moveDeferred                  // the walk has yet to reach a candidate
+)
+
+// moveScope holds the paths a scan walks. Whether a move candidate beneath
+// them still exists is settled by the walk itself: while it runs, a candidate
+// it has not reached yet may still turn up, and once it is done, a candidate
+// it did not see is gone. Candidates elsewhere are checked on disk.
+type moveScope struct {
+	paths []string
+	done  bool
+}
+
+// movedRecord looks for an indexed file with the same identity as record
+// whose path no longer exists, meaning it was renamed or moved to record's
+// path. The previous record is claimed in state so that it is neither matched
+// twice nor removed as missing. It reports moveDeferred when the answer
+// depends on a part of scope the walk has not reached yet; the caller asks
+// again with a done scope once the walk has finished.
+func (idx *Indexer) movedRecord(ctx context.Context, ids *identityIndex, state *walkState, scope moveScope, record FileRecord) (FileRecord, moveMatch, error) {
+	id, ok := record.identity()
+	if !ok {
+		return FileRecord{}, moveNone, nil
+	}
+	candidates, err := ids.lookup(ctx, id)
+	if err != nil {
+		return FileRecord{}, moveNone, err
+	}
+	match := moveNone
+	for _, candidate := range candidates {
+		if candidate.Path == record.Path || state.wasSeen(candidate.Path) {
+			continue
+		}
+		if _, walked := rootOf(scope.paths, candidate.Path); walked {
+			if !scope.done {
+				match = moveDeferred
+				continue
+			}
+			// Paths the walk skipped or could not read may still exist.
+			if state.excluded(candidate.Path) || state.unreadable(candidate.Path) {
+				continue
+			}
+		} else if _, err := os.Lstat(candidate.Path); !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if state.claimMove(candidate.Path) {
+			return candidate, moveFound, nil
+		}
+	}
+	return FileRecord{}, match, nil
+}
+
+// resolveDeferredMoves records the files whose move check was deferred during
+// the walk, now that scope is done, as moves or creations.
+func (idx *Indexer) resolveDeferredMoves(ctx context.Context, w recordWriter, state *walkState, ids *identityIndex, scope moveScope) error {
+	for _, record := range state.deferredMoves() {
+		previous, match, err := idx.movedRecord(ctx, ids, state, scope, record)
+		if err != nil {
+			return err
+		}
+		if match == moveFound {
+			err = w.move(ctx, previous.Path, carryOver(previous, record))
+		} else {
+			err = idx.storeFile(ctx, w, record, ChangeCreated)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// carryOver returns current with the hashes and content state of previous
+// when the file itself did not change.
+func carryOver(previous, current FileRecord) FileRecord {
+	if previous.Size == current.Size && previous.ModTime.Equal(current.ModTime) {
+		current.PartialHash = previous.PartialHash
+		current.Hash = previous.Hash
+		current.ContentIndexed = previous.ContentIndexed
+	}
+	return current
+}
+
+// moveTree renames the records at or beneath from so that they live beneath
+// to, as reported by the watcher. The caller refreshes to afterwards, which
+// picks up any other changes and drops entries that are gone or excluded at
+// their new location.
+func (idx *Indexer) moveTree(ctx context.Context, w recordWriter, root, from, to string) error {
+	paths, err := idx.pathsWithin(ctx, filepath.Clean(from), 0)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			continue
+		}
+		record, ok, err := idx.lookup(ctx, path)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		record.Path = filepath.Join(to, rel)
+		record.Name = filepath.Base(record.Path)
+		record.RootPath = root
+		if err := w.move(ctx, path, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"seekfile/internal/cron"
+	"seekfile/internal/ignore"
+)
+
+// RootOptions customizes how an individual scan root is indexed.
+type RootOptions struct {
+	// Exclude lists gitignore-style patterns, relative to the root, for
+	// entries that are skipped. Excluded directories are not descended into.
+	Exclude []string
+	// Include, when non-empty, limits indexing to files matching at least one
+	// of these gitignore-style patterns.
+	Include []string
+	// IgnoreFiles names per-directory ignore files (for example .gitignore)
+	// whose rules apply to the directory they live in and below.
+	IgnoreFiles []string
+	// Concurrency bounds how many directories are read in parallel while
+	// scanning the root. Zero selects a default based on the filesystem:
+	// NetworkScanConcurrency for network mounts, otherwise the number of CPUs
+	// clamped to LocalScanConcurrency.
+	Concurrency int
+	// IncrementalSchedule and FullSchedule are cron expressions for the
+	// periodic scans run by StartScheduler. Empty expressions disable them.
+	IncrementalSchedule string
+	FullSchedule        string
+	// Unreadable decides what scans do with the records beneath directories
+	// and files of the root that they cannot read. Empty selects
+	// UnreadableKeep.
+	Unreadable UnreadablePolicy
+}
+
+const (
+	// LocalScanConcurrency caps the default worker count for local disks.
+	LocalScanConcurrency = 8
+	// NetworkScanConcurrency is the default worker count for network mounts,
+	// where many requests in flight hide the round-trip latency.
+	NetworkScanConcurrency = 16
+)
+
+// Option configures optional Indexer behavior at construction time.
+type Option func(*Indexer) error
+
+// WithRootOptions applies per-root settings to the given scan root.
+func WithRootOptions(root string, opts RootOptions) Option {
+	return func(idx *Indexer) error {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		normalized := filepath.Clean(abs)
+		if _, ok := idx.roots[normalized]; !ok {
+			return fmt.Errorf("root options for %s: not a configured scan root", normalized)
+		}
+		if opts.Concurrency < 0 {
+			return fmt.Errorf("root options for %s: concurrency must not be negative", normalized)
+		}
+
+		unreadable, err := ParseUnreadablePolicy(string(opts.Unreadable))
+		if err != nil {
+			return fmt.Errorf("root options for %s: %w", normalized, err)
+		}
+
+		exclude, err := ignore.Parse(normalized, opts.Exclude)
+		if err != nil {
+			return fmt.Errorf("exclude rules for %s: %w", normalized, err)
+		}
+		include, err := ignore.Parse(normalized, opts.Include)
+		if err != nil {
+			return fmt.Errorf("include rules for %s: %w", normalized, err)
+		}
+
+		schedules := make(map[ScanMode]*cron.Schedule, 2)
+		for _, entry := range []struct {
+			mode ScanMode
+			expr string
+		}{
+			{ScanModeIncremental, opts.IncrementalSchedule},
+			{ScanModeFull, opts.FullSchedule},
+		} {
+			if strings.TrimSpace(entry.expr) == "" {
+				continue
+			}
+			if schedules[entry.mode], err = cron.Parse(entry.expr); err != nil {
+				return fmt.Errorf("%s scan schedule for %s: %w", entry.mode, normalized, err)
+			}
+		}
+
+		idx.roots[normalized] = &rootConfig{
+			path:        normalized,
+			exclude:     exclude,
+			include:     ignore.NewMatcher(include),
+			ignoreFiles: append([]string(nil), opts.IgnoreFiles...),
+			workers:     opts.Concurrency,
+			unreadable:  unreadable,
+			schedules:   schedules,
+		}
+		return nil
+	}
+}
+
+// WithContentHashing enables content hashing for duplicate detection. Files
+// are bucketed by size, then by a partial hash of their head and tail, and
+// only the remaining candidates are hashed in full with SHA-256.
+func WithContentHashing(enabled bool) Option {
+	return func(idx *Indexer) error {
+		idx.hashContents = enabled
+		return nil
+	}
+}
+
+// WithContentIndexing enables extraction of file text into the store's
+// full-text index. The store must implement ContentStore.
+func WithContentIndexing(opts ContentOptions) Option {
+	return func(idx *Indexer) error {
+		store, ok := idx.store.(ContentStore)
+		if !ok {
+			return errors.New("content indexing requires a store with full-text support")
+		}
+
+		maxSize := opts.MaxFileSize
+		if maxSize <= 0 {
+			maxSize = DefaultContentMaxFileSize
+		}
+
+		extensions := opts.Extensions
+		if len(extensions) == 0 {
+			extensions = DefaultContentExtensions
+		}
+		allowed := make(map[string]struct{}, len(extensions))
+		for _, ext := range extensions {
+			normalized := strings.ToLower(strings.TrimSpace(ext))
+			if normalized == "" {
+				continue
+			}
+			if !strings.HasPrefix(normalized, ".") {
+				normalized = "." + normalized
+			}
+			allowed[normalized] = struct{}{}
+		}
+
+		idx.content = &contentIndexer{store: store, maxFileSize: maxSize, extensions: allowed}
+		return nil
+	}
+}
+
+// WithMemoryIndex controls whether records are mirrored in memory. Disabling
+// it answers searches from the store, which must implement QueryStore.
+func WithMemoryIndex(enabled bool) Option {
+	return func(idx *Indexer) error {
+		if enabled {
+			idx.memory = true
+			idx.query = nil
+			return nil
+		}
+		store, ok := idx.store.(QueryStore)
+		if !ok {
+			return errors.New("disabling the in-memory index requires a store with query support")
+		}
+		idx.memory = false
+		idx.query = store
+		return nil
+	}
+}
+
+// WithChangeLog persists every change in the store's change log so that it
+// can be read back with Changes. The store must implement ChangeStore.
+func WithChangeLog(opts ChangeLogOptions) Option {
+	return func(idx *Indexer) error {
+		store, ok := idx.store.(ChangeStore)
+		if !ok {
+			return errors.New("the change log requires a store with change log support")
+		}
+		if opts.Retention < 0 || opts.MaxEntries < 0 {
+			return errors.New("change log retention must not be negative")
+		}
+		retention := opts.Retention
+		if retention == 0 {
+			retention = DefaultChangeRetention
+		}
+		idx.changeLog = &changeLog{store: store, retention: retention, maxEntries: opts.MaxEntries}
+		return nil
+	}
+}
+
+// WithChangeHandler registers fn to be called for every file that is created,
+// modified, moved or deleted in the index, whether by a scan, the watcher or
+// UpdateFile and RemoveFile. fn runs synchronously once the change has been
+// persisted, possibly from several goroutines at once, and must not block.
+func WithChangeHandler(fn func(Change)) Option {
+	return func(idx *Indexer) error {
+		if fn == nil {
+			return errors.New("change handler must not be nil")
+		}
+		idx.changeHandlers = append(idx.changeHandlers, fn)
+		return nil
+	}
+}
+
+// WithBatchSize sets how many writes a scan groups into one store
+// transaction. Zero selects DefaultBatchSize.
+func WithBatchSize(size int) Option {
+	return func(idx *Indexer) error {
+		if size < 0 {
+			return errors.New("batch size must not be negative")
+		}
+		if size == 0 {
+			size = DefaultBatchSize
+		}
+		idx.batchSize = size
+		return nil
+	}
+}
@@ -0,0 +1,363 @@
+package indexer
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+// storeCountTTL bounds how often the record count is recomputed when the
+// in-memory index is disabled.
+const storeCountTTL = 5 * time.Second
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or
+// does not belong to the query it is used with.
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+// QueryStore is implemented by stores that can evaluate searches themselves,
+// allowing the indexer to run without holding every record in memory.
+type QueryStore interface {
+	Search(ctx context.Context, query storage.Query) (storage.SearchPage, error)
+	Facets(ctx context.Context, query storage.Query, sizeEdges []int64, timeEdges []time.Time) (storage.Facets, error)
+	// NameCandidates returns up to limit paths of records matching filter
+	// whose names are likely to match the words of a fuzzy search, best
+	// first. The sort and paging fields of filter are ignored.
+	NameCandidates(ctx context.Context, words []string, filter storage.Query, limit int) ([]string, error)
+	Lookup(ctx context.Context, path string) (storage.Record, bool, error)
+	// Directory and Subdirectories return the aggregates of a directory and
+	// of the directories directly inside it.
+	Directory(ctx context.Context, path string) (storage.Directory, bool, error)
+	Subdirectories(ctx context.Context, path string) ([]storage.Directory, error)
+	LookupIdentity(ctx context.Context, device, inode uint64) ([]storage.Record, error)
+	Count(ctx context.Context) (int, error)
+	// PathsWithin lists the paths equal to or nested beneath dir, up to
+	// limit paths when limit is positive.
+	PathsWithin(ctx context.Context, dir string, limit int) ([]string, error)
+	RootPaths(ctx context.Context, root string, fn func(path string) error) error
+	SizeBuckets(ctx context.Context, fn func(size int64, records []storage.Record) error) error
+	DuplicateGroups(ctx context.Context, minSize int64, roots []string, offset, limit int) ([]storage.DuplicateGroup, storage.DuplicateSummary, error)
+}
+
+// recordCount caches the store's record count.
+type recordCount struct {
+	mu      sync.Mutex
+	value   int
+	expires time.Time
+}
+
+// searchCursor is the decoded form of Query.Cursor. It carries the sort
+// position of the last record on the previous page.
+type searchCursor struct {
+	Field      string  `json:"f"`
+	Descending bool    `json:"d,omitempty"`
+	Name       string  `json:"n"`
+	Size       int64   `json:"s"`
+	ModTime    int64   `json:"m"`
+	Path       string  `json:"p"`
+	Score      float64 `json:"r,omitempty"`
+}
+
+func encodeCursor(record FileRecord, query Query) string {
+	data, err := json.Marshal(searchCursor{
+		Field:      normalizeSortField(query.SortField),
+		Descending: query.SortDescending,
+		Name:       record.Name,
+		Size:       record.Size,
+		ModTime:    record.ModTime.UnixNano(),
+		Path:       record.Path,
+		Score:      record.Score,
+	})
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses the cursor of query, if any, and checks that it was
+// produced for the same ordering.
+func decodeCursor(query Query) (*searchCursor, error) {
+	if query.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor searchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Field != normalizeSortField(query.SortField) || cursor.Descending != query.SortDescending {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func (c *searchCursor) record() FileRecord {
+	return FileRecord{
+		Name:    c.Name,
+		Size:    c.Size,
+		ModTime: time.Unix(0, c.ModTime),
+		Path:    c.Path,
+		Score:   c.Score,
+	}
+}
+
+func normalizeSortField(field string) string {
+	switch strings.ToLower(field) {
+	case "size", "path", "relevance":
+		return strings.ToLower(field)
+	case "modified", "time":
+		return "modified"
+	default:
+		return "name"
+	}
+}
+
+// lessRecords orders records for a query: by the sort field and then by path
+// so that the order is total. It matches the order of the store's Search.
+func lessRecords(a, b FileRecord, query Query) bool {
+	order := compareRecords(a, b, query.SortField)
+	if order == 0 {
+		order = strings.Compare(a.Path, b.Path)
+	}
+	if query.SortDescending {
+		return order > 0
+	}
+	return order < 0
+}
+
+// compareNoCase orders strings like SQLite's NOCASE collation, which the
+// store sorts names with: ASCII letters compare regardless of case and all
+// other bytes compare as they are.
+func compareNoCase(a, b string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if order := cmp.Compare(lowerASCII(a[i]), lowerASCII(b[i])); order != 0 {
+			return order
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// pageRecords sorts matches and cuts out the page selected by the query's
+// cursor or offset and limit.
+func pageRecords(matches []FileRecord, query Query, cursor *searchCursor) SearchResult {
+	sort.Slice(matches, func(i, j int) bool {
+		return lessRecords(matches[i], matches[j], query)
+	})
+
+	total := len(matches)
+
+	offset := query.Offset
+	if cursor != nil {
+		last := cursor.record()
+		offset = sort.Search(total, func(i int) bool {
+			return lessRecords(last, matches[i], query)
+		})
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+
+	end := total
+	if query.Limit > 0 && offset+query.Limit < total {
+		end = offset + query.Limit
+	}
+
+	result := SearchResult{Files: matches[offset:end], Total: total}
+	if end < total && end > offset {
+		result.NextCursor = encodeCursor(matches[end-1], query)
+	}
+	return result
+}
+
+// storeQuery translates a search into a store query.
+func storeQuery(query Query, cursor *searchCursor) storage.Query {
+	q := storage.Query{
+		Expr:           query.expr,
+		Extensions:     normalizeExtensions(query.Extensions),
+		Roots:          query.Roots,
+		MinSize:        query.MinSize,
+		MaxSize:        query.MaxSize,
+		ModifiedAfter:  query.ModifiedAfter,
+		ModifiedBefore: query.ModifiedBefore,
+		SortField:      normalizeSortField(query.SortField),
+		SortDescending: query.SortDescending,
+		Offset:         query.Offset,
+		Limit:          query.Limit,
+	}
+	if query.Dir != "" {
+		q.Dir = filepath.Clean(query.Dir)
+	}
+	if cursor != nil {
+		q.After = &storage.Cursor{
+			Name:    cursor.Name,
+			Size:    cursor.Size,
+			ModTime: time.Unix(0, cursor.ModTime),
+			Path:    cursor.Path,
+		}
+	}
+	return q
+}
+
+// searchStore evaluates a search entirely in the store. An extra row is
+// requested to find out whether another page follows.
+func (idx *Indexer) searchStore(ctx context.Context, query Query, cursor *searchCursor) (SearchResult, error) {
+	q := storeQuery(query, cursor)
+	if q.Limit > 0 {
+		q.Limit++
+	}
+
+	page, err := idx.query.Search(ctx, q)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	files := make([]FileRecord, 0, len(page.Records))
+	for _, record := range page.Records {
+		files = append(files, fromStorageRecord(record))
+	}
+
+	result := SearchResult{Files: files, Total: page.Total}
+	if query.Limit > 0 && len(files) > query.Limit {
+		result.Files = files[:query.Limit]
+		result.NextCursor = encodeCursor(result.Files[query.Limit-1], query)
+	}
+	if query.Facets {
+		if result.Facets, err = idx.storeFacets(ctx, query, time.Now()); err != nil {
+			return SearchResult{}, err
+		}
+	}
+	return result, nil
+}
+
+// searchStoreRanked resolves content hits or fuzzy name candidates against
+// the store and ranks them in memory; their number is bounded by
+// contentHitLimit and fuzzyCandidateLimit. Content hits are already limited
+// to the name candidates.
+func (idx *Indexer) searchStoreRanked(ctx context.Context, query Query, cursor *searchCursor, hits map[string]storage.ContentHit, words []string) (SearchResult, error) {
+	q := storeQuery(query, nil)
+	q.Offset, q.Limit = 0, 0
+
+	var truncated bool
+	if hits != nil {
+		q.Paths = make([]string, 0, len(hits))
+		for path := range hits {
+			q.Paths = append(q.Paths, path)
+		}
+	} else {
+		names, err := idx.query.NameCandidates(ctx, words, q, fuzzyCandidateLimit+1)
+		if err != nil {
+			return SearchResult{}, err
+		}
+		if len(names) > fuzzyCandidateLimit {
+			names, truncated = names[:fuzzyCandidateLimit], true
+		}
+		q.Paths = names
+	}
+
+	page, err := idx.query.Search(ctx, q)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	matches := make([]FileRecord, 0, len(page.Records))
+	for _, stored := range page.Records {
+		record := fromStorageRecord(stored)
+		if hit, ok := hits[record.Path]; ok {
+			record.Snippet = renderSnippet(hit.Snippet)
+			record.Score = -hit.Rank
+		}
+		if len(words) > 0 {
+			score := fuzzyScore(words, record.Name)
+			if score == 0 {
+				continue
+			}
+			record.Score += score
+		}
+		matches = append(matches, record)
+	}
+	result := pageRecords(matches, query, cursor)
+	result.Truncated = truncated
+	if query.Facets {
+		result.Facets = recordFacets(matches, time.Now())
+	}
+	return result, nil
+}
+
+// lookup returns the record stored for path from memory or, when the
+// in-memory index is disabled, from the store.
+func (idx *Indexer) lookup(ctx context.Context, path string) (FileRecord, bool, error) {
+	normalized := filepath.Clean(path)
+	if idx.memory {
+		idx.mu.RLock()
+		defer idx.mu.RUnlock()
+		record, ok := idx.files[normalized]
+		return record, ok, nil
+	}
+
+	record, ok, err := idx.query.Lookup(ctx, normalized)
+	if err != nil || !ok {
+		return FileRecord{}, false, err
+	}
+	return fromStorageRecord(record), true, nil
+}
+
+// storeCount returns the number of stored records, recomputing it at most
+// once per storeCountTTL.
+func (idx *Indexer) storeCount() int {
+	idx.count.mu.Lock()
+	defer idx.count.mu.Unlock()
+	if time.Now().Before(idx.count.expires) {
+		return idx.count.value
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeCountTTL)
+	defer cancel()
+	if count, err := idx.query.Count(ctx); err == nil {
+		idx.count.value = count
+	}
+	idx.count.expires = time.Now().Add(storeCountTTL)
+	return idx.count.value
+}
+
+// invalidate forces the next read to query the store again.
+func (c *recordCount) invalidate() {
+	c.mu.Lock()
+	c.expires = time.Time{}
+	c.mu.Unlock()
+}
+
+func normalizeExtensions(extensions []string) []string {
+	normalized := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		normalized = append(normalized, ext)
+	}
+	return normalized
+}
@@ -0,0 +1,125 @@
+package indexer
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// pagedPaths collects every page of query by following NextCursor.
+func pagedPaths(t *testing.T, idx *Indexer, query Query) []string {
+	t.Helper()
+	var paths []string
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatal("pagination does not end")
+		}
+		result, err := idx.Search(t.Context(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range result.Files {
+			paths = append(paths, file.Path)
+		}
+		if result.NextCursor == "" {
+			return paths
+		}
+		query.Cursor = result.NextCursor
+	}
+}
+
+func TestSortOrderMatchesAcrossModes(t *testing.T) {
+	stored, roots := newTestIndexer(t, 1)
+	root := roots[0]
+	memory, err := New(roots, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"a.txt":        "1",
+		"B.txt":        "22",
+		"b2.txt":       "22",
+		"_under.txt":   "1",
+		"Zeta.txt":     "333",
+		"zeta.txt":     "333",
+		"Ärger.txt":    "1",
+		"äpfel.txt":    "22",
+		"Sub/a.txt":    "1",
+		"sub/a.txt":    "1",
+		"sub/Deep.txt": "4444",
+	}
+	for name, content := range files {
+		writeFile(t, filepath.Join(root, name), content)
+	}
+	scan(t, stored, ScanRequest{Mode: ScanModeIncremental})
+	scan(t, memory, ScanRequest{Mode: ScanModeIncremental})
+
+	for _, field := range []string{"name", "path", "size", "modified"} {
+		for _, descending := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/descending=%t", field, descending), func(t *testing.T) {
+				query := Query{SortField: field, SortDescending: descending, Limit: 3}
+				want := pagedPaths(t, memory, query)
+				if len(want) != len(files) {
+					t.Fatalf("memory mode returned %d files, want %d", len(want), len(files))
+				}
+				if got := pagedPaths(t, stored, query); !slices.Equal(got, want) {
+					t.Fatalf("store order differs from memory order:\n got %v\nwant %v", got, want)
+				}
+			})
+		}
+	}
+
+	// Names ignore the case of ASCII letters only, so the umlauts sort after
+	// every ASCII name; ties are broken by path.
+	var names []string
+	for _, path := range pagedPaths(t, stored, Query{SortField: "name"}) {
+		rel, _ := filepath.Rel(root, path)
+		names = append(names, rel)
+	}
+	want := []string{
+		"_under.txt", "Sub/a.txt", "a.txt", "sub/a.txt", "B.txt", "b2.txt",
+		"sub/Deep.txt", "Zeta.txt", "zeta.txt", "Ärger.txt", "äpfel.txt",
+	}
+	if !slices.Equal(names, want) {
+		t.Fatalf("name order = %s, want %s", strings.Join(names, " "), strings.Join(want, " "))
+	}
+}
+
+func TestFuzzyCandidateLimitAppliesAfterFilters(t *testing.T) {
+	idx, roots := newTestIndexer(t, 2, WithContentIndexing(ContentOptions{}))
+	// The names of the first root outrank the typo of the second, and its
+	// texts outrank the text of the second.
+	for i := range fuzzyCandidateLimit + 1 {
+		writeFile(t, filepath.Join(roots[0], fmt.Sprintf("report-%04d.txt", i)), "x")
+	}
+	for i := range contentHitLimit + 1 {
+		writeFile(t, filepath.Join(roots[0], fmt.Sprintf("other-%04d.txt", i)), "needle needle")
+	}
+	typo := filepath.Join(roots[1], "reprot.txt")
+	writeFile(t, typo, "a needle lost among "+strings.Repeat("hay ", 50))
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	result, err := idx.Search(t.Context(), Query{NamePattern: "report", Fuzzy: true, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Truncated || result.Total > fuzzyCandidateLimit {
+		t.Fatalf("fuzzy search of every root: truncated %t, total %d, want truncated at %d", result.Truncated, result.Total, fuzzyCandidateLimit)
+	}
+
+	for name, query := range map[string]Query{
+		"roots":   {NamePattern: "report", Fuzzy: true, Roots: roots[1:]},
+		"content": {NamePattern: "report", Fuzzy: true, Content: "needle"},
+	} {
+		result, err := idx.Search(t.Context(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Truncated || !slices.Equal(searchPaths(t, idx, query), []string{typo}) {
+			t.Errorf("fuzzy search filtered by %s: truncated %t, total %d, want only %s", name, result.Truncated, result.Total, typo)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"seekfile/internal/cron"
+	"seekfile/internal/ignore"
+)
+
+// rootConfig is the compiled form of RootOptions.
+type rootConfig struct {
+	path        string
+	exclude     *ignore.Rules
+	include     *ignore.Matcher
+	ignoreFiles []string
+	workers     int
+	unreadable  UnreadablePolicy
+	// schedules holds the cron schedule of each periodic scan mode.
+	schedules map[ScanMode]*cron.Schedule
+}
+
+// concurrency returns the number of scan workers for the root.
+func (rc *rootConfig) concurrency() int {
+	if rc.workers > 0 {
+		return rc.workers
+	}
+	if isNetworkFS(rc.path) {
+		return NetworkScanConcurrency
+	}
+	return min(max(runtime.NumCPU(), 2), LocalScanConcurrency)
+}
+
+func (rc *rootConfig) baseMatcher() *ignore.Matcher {
+	return ignore.NewMatcher(rc.exclude)
+}
+
+// dirMatcher extends parent with the ignore files found in dir. Unreadable or
+// malformed ignore files are skipped.
+func (rc *rootConfig) dirMatcher(parent *ignore.Matcher, dir string) *ignore.Matcher {
+	matcher := parent
+	for _, name := range rc.ignoreFiles {
+		rules, err := ignore.ParseFile(dir, name)
+		if err != nil || rules == nil {
+			continue
+		}
+		matcher = matcher.With(rules)
+	}
+	return matcher
+}
+
+// excludes reports whether an entry is filtered out by the exclude rules in
+// matcher or, for files, by the root's include rules.
+func (rc *rootConfig) excludes(matcher *ignore.Matcher, path string, isDir bool) bool {
+	if matcher.Match(path, isDir) {
+		return true
+	}
+	if !isDir && rc.include != nil && !rc.include.Match(path, false) {
+		return true
+	}
+	return false
+}
+
+// isIgnoreFile reports whether name is one of the root's ignore files.
+func (rc *rootConfig) isIgnoreFile(name string) bool {
+	for _, candidate := range rc.ignoreFiles {
+		if candidate == name {
+			return true
+		}
+	}
+	return false
+}
+
+// treeMatcher builds the matcher that applies inside dir by replaying the
+// ignore files from root down to dir. It reports false when dir itself or one
+// of its ancestors is excluded.
+func (rc *rootConfig) treeMatcher(dir string) (*ignore.Matcher, bool) {
+	matcher := rc.dirMatcher(rc.baseMatcher(), rc.path)
+	if dir == rc.path {
+		return matcher, true
+	}
+
+	rel, err := filepath.Rel(rc.path, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return matcher, false
+	}
+
+	current := rc.path
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		if rc.excludes(matcher, current, true) {
+			return nil, false
+		}
+		matcher = rc.dirMatcher(matcher, current)
+	}
+	return matcher, true
+}
+
+// isExcluded reports whether path, beneath root, is filtered out by the
+// root's rules.
+func (idx *Indexer) isExcluded(root, path string, isDir bool) bool {
+	rc := idx.rootConfig(root)
+	parent := filepath.Dir(path)
+	if path == root {
+		return false
+	}
+	matcher, ok := rc.treeMatcher(parent)
+	if !ok {
+		return true
+	}
+	return rc.excludes(matcher, path, isDir)
+}
+
+func (idx *Indexer) rootConfig(root string) *rootConfig {
+	if rc, ok := idx.roots[root]; ok {
+		return rc
+	}
+	return &rootConfig{path: root}
+}
+
+// unreadablePolicy returns what becomes of the records beneath entries of
+// the root that a scan could not read.
+func (rc *rootConfig) unreadablePolicy() UnreadablePolicy {
+	if rc.unreadable == "" {
+		return UnreadableKeep
+	}
+	return rc.unreadable
+}
+
+// walkState accumulates the bookkeeping of a walk across one or more roots.
+// It is safe for concurrent use by the walker's workers.
+type walkState struct {
+	mu              sync.Mutex
+	seen            map[string]struct{}
+	excludedDirs    map[string]struct{}
+	excludedFiles   map[string]struct{}
+	unreadableDirs  map[string]struct{}
+	unreadableFiles map[string]struct{}
+	moved           map[string]struct{}
+	deferred        []FileRecord
+	errors          WalkErrors
+}
+
+func newWalkState() *walkState {
+	return &walkState{
+		seen:            make(map[string]struct{}),
+		excludedDirs:    make(map[string]struct{}),
+		excludedFiles:   make(map[string]struct{}),
+		unreadableDirs:  make(map[string]struct{}),
+		unreadableFiles: make(map[string]struct{}),
+		moved:           make(map[string]struct{}),
+	}
+}
+
+func (s *walkState) markSeen(path string) {
+	s.mu.Lock()
+	s.seen[path] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *walkState) wasSeen(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.seen[path]
+	return ok
+}
+
+// claimMove marks the indexed path as the source of a move. It reports false
+// if another file already claimed it.
+func (s *walkState) claimMove(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.moved[path]; ok {
+		return false
+	}
+	s.moved[path] = struct{}{}
+	return true
+}
+
+func (s *walkState) wasMoved(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.moved[path]
+	return ok
+}
+
+// deferMove sets record aside until the walk has finished, because whether
+// it moved depends on paths the walk has not reached yet.
+func (s *walkState) deferMove(record FileRecord) {
+	s.mu.Lock()
+	s.deferred = append(s.deferred, record)
+	s.mu.Unlock()
+}
+
+// deferredMoves returns the records set aside by deferMove.
+func (s *walkState) deferredMoves() []FileRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.deferred)
+}
+
+func (s *walkState) markExcluded(path string, isDir bool) {
+	s.mu.Lock()
+	if isDir {
+		s.excludedDirs[path] = struct{}{}
+	} else {
+		s.excludedFiles[path] = struct{}{}
+	}
+	s.mu.Unlock()
+}
+
+// excluded reports whether path was skipped by rules during the walk, either
+// directly or because one of its parent directories was.
+func (s *walkState) excluded(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return withinAny(s.excludedFiles, s.excludedDirs, path)
+}
+
+// markUnreadable records that the walk could not read path, and everything
+// beneath it if it is a directory, because of err.
+func (s *walkState) markUnreadable(path string, isDir bool, err error) {
+	s.mu.Lock()
+	if isDir {
+		s.unreadableDirs[path] = struct{}{}
+	} else {
+		s.unreadableFiles[path] = struct{}{}
+	}
+	s.errors.add(path, err)
+	s.mu.Unlock()
+}
+
+// unreadable reports whether path, or one of its parent directories, could
+// not be read during the walk.
+func (s *walkState) unreadable(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return withinAny(s.unreadableFiles, s.unreadableDirs, path)
+}
+
+// walkErrors returns the errors met during the walk, or nil if there were
+// none.
+func (s *walkState) walkErrors() *WalkErrors {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.errors.clone()
+}
+
+// withinAny reports whether path is in files or is, or lies beneath, one of dirs.
+func withinAny(files, dirs map[string]struct{}, path string) bool {
+	if _, ok := files[path]; ok {
+		return true
+	}
+	for dir := path; ; {
+		if _, ok := dirs[dir]; ok {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// walkTree visits every entry beneath start (which must live under root) that
+// survives the root's exclude and include rules, in lexical order. Excluded
+// entries are recorded in state; excluded directories are skipped entirely.
+// Entries that cannot be read are recorded in state as unreadable and the
+// walk goes on; a start path other than root that does not exist is not an
+// error.
+func (idx *Indexer) walkTree(ctx context.Context, root, start string, state *walkState, visit func(path string, entry fs.DirEntry) error) error {
+	return idx.walkTreeParallel(ctx, root, start, state, 1, visit)
+}
+
+// walkTreeParallel is walkTree with up to workers directories read
+// concurrently. With more than one worker, visit is called from multiple
+// goroutines and entries are no longer visited in order. A directory is
+// always visited before its contents. Returning filepath.SkipDir from visit
+// for a directory skips it.
+func (idx *Indexer) walkTreeParallel(ctx context.Context, root, start string, state *walkState, workers int, visit func(path string, entry fs.DirEntry) error) error {
+	rc := idx.rootConfig(root)
+
+	startMatcher, ok := rc.treeMatcher(filepath.Dir(start))
+	if start == root {
+		startMatcher, ok = rc.baseMatcher(), true
+	}
+	if !ok {
+		state.markExcluded(start, true)
+		return nil
+	}
+
+	info, err := os.Lstat(start)
+	if err != nil {
+		if start == root || !errors.Is(err, fs.ErrNotExist) {
+			state.markUnreadable(start, true, err)
+		}
+		return nil
+	}
+	entry := fs.FileInfoToDirEntry(info)
+	if start != root && rc.excludes(startMatcher, start, entry.IsDir()) {
+		state.markExcluded(start, entry.IsDir())
+		return nil
+	}
+	if err := visit(start, entry); err != nil {
+		if errors.Is(err, filepath.SkipDir) {
+			return nil
+		}
+		return err
+	}
+	if !entry.IsDir() {
+		return nil
+	}
+
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &treeWalker{
+		ctx:   walkCtx,
+		rc:    rc,
+		state: state,
+		visit: visit,
+		sem:   make(chan struct{}, max(workers, 1)-1),
+	}
+	w.fail = func(err error) {
+		w.errOnce.Do(func() {
+			w.err = err
+			cancel()
+		})
+	}
+
+	w.readDir(start, rc.dirMatcher(startMatcher, start))
+	w.wg.Wait()
+
+	if w.err != nil {
+		return w.err
+	}
+	return ctx.Err()
+}
+
+// treeWalker holds the shared state of a single walkTreeParallel call. The
+// semaphore holds one slot per additional worker; when it is full, the
+// current goroutine descends into subdirectories itself.
+type treeWalker struct {
+	ctx   context.Context
+	rc    *rootConfig
+	state *walkState
+	visit func(path string, entry fs.DirEntry) error
+	sem   chan struct{}
+	wg    sync.WaitGroup
+
+	errOnce sync.Once
+	err     error
+	fail    func(error)
+}
+
+func (w *treeWalker) readDir(dir string, matcher *ignore.Matcher) {
+	if w.ctx.Err() != nil {
+		return
+	}
+
+	// The entries read before an error are still walked, but the directory
+	// is marked unreadable since it may hold more. A directory removed since
+	// it was listed is simply gone.
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		w.state.markUnreadable(dir, true, err)
+	}
+
+	for _, entry := range entries {
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		isDir := entry.IsDir()
+		if w.rc.excludes(matcher, path, isDir) {
+			w.state.markExcluded(path, isDir)
+			continue
+		}
+
+		if err := w.visit(path, entry); err != nil {
+			if isDir && errors.Is(err, filepath.SkipDir) {
+				continue
+			}
+			w.fail(err)
+			return
+		}
+		if !isDir {
+			continue
+		}
+
+		child := w.rc.dirMatcher(matcher, path)
+		select {
+		case w.sem <- struct{}{}:
+			w.wg.Add(1)
+			go func() {
+				defer w.wg.Done()
+				defer func() { <-w.sem }()
+				w.readDir(path, child)
+			}()
+		default:
+			w.readDir(path, child)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"seekfile/internal/cron"
+	"seekfile/internal/storage"
+)
+
+// schedulerMaxSleep bounds how long the scheduler sleeps between checks so
+// that changes to the wall clock are noticed.
+const schedulerMaxSleep = time.Minute
+
+// ScheduleStatus reports the next run of a periodic scan of a root.
+type ScheduleStatus struct {
+	Root     string    `json:"root"`
+	Mode     string    `json:"mode"`
+	Schedule string    `json:"schedule"`
+	NextRun  time.Time `json:"nextRun"`
+	// Queued is set while a job scanning the whole root in this mode, or a
+	// full scan of it, waits in the scan queue.
+	Queued bool `json:"queued"`
+}
+
+// scheduledScan tracks one periodic scan of a root.
+type scheduledScan struct {
+	root     string
+	mode     ScanMode
+	schedule *cron.Schedule
+	// next is the next activation; it is zero when the schedule never fires
+	// again.
+	next time.Time
+}
+
+// StartScheduler runs the periodic scans configured with the
+// IncrementalSchedule and FullSchedule root options until ctx is cancelled.
+// Each run is queued as a job of its own, so roots due at the same time are
+// scanned concurrently. A run missed while the process was down, judged by
+// the scan times kept in the store, is made up once unless the root is
+// scanned before the run starts.
+func (idx *Indexer) StartScheduler(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	now := time.Now()
+	var (
+		schedules []*scheduledScan
+		missed    []*scheduledScan
+		since     []time.Time
+	)
+	for _, root := range idx.scanRoots {
+		rc := idx.rootConfig(root)
+		if len(rc.schedules) == 0 {
+			continue
+		}
+		var state storage.ScanState
+		if idx.store != nil {
+			var err error
+			if state, err = idx.store.ScanState(ctx, root); err != nil {
+				return fmt.Errorf("scan state for %s: %w", root, err)
+			}
+		}
+
+		for _, mode := range []ScanMode{ScanModeIncremental, ScanModeFull} {
+			schedule, ok := rc.schedules[mode]
+			if !ok {
+				continue
+			}
+			entry := &scheduledScan{root: root, mode: mode, schedule: schedule, next: schedule.Next(now)}
+			if last, ok := lastScan(state, mode); ok {
+				if due := schedule.Next(last); !due.IsZero() && !due.After(now) {
+					missed = append(missed, entry)
+					since = append(since, due)
+				}
+			}
+			schedules = append(schedules, entry)
+		}
+	}
+	if len(schedules) == 0 {
+		return nil
+	}
+
+	idx.scheduleMu.Lock()
+	if idx.schedules != nil {
+		idx.scheduleMu.Unlock()
+		return errors.New("scan scheduler already running")
+	}
+	idx.schedules = schedules
+	idx.scheduleMu.Unlock()
+
+	for i, entry := range missed {
+		idx.queueScan(ctx, entry.mode, []ScanTarget{{Root: entry.root}}, since[i])
+	}
+	go idx.runScheduler(ctx)
+	return nil
+}
+
+func (idx *Indexer) runScheduler(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			idx.scheduleMu.Lock()
+			idx.schedules = nil
+			idx.scheduleMu.Unlock()
+			return
+		case <-timer.C:
+		}
+
+		wake := idx.runDueScans(ctx, time.Now())
+		sleep := schedulerMaxSleep
+		if !wake.IsZero() {
+			sleep = min(sleep, time.Until(wake))
+		}
+		timer.Reset(sleep)
+	}
+}
+
+// runDueScans queues the runs that came due by now and returns the next
+// activation of any schedule.
+func (idx *Indexer) runDueScans(ctx context.Context, now time.Time) time.Time {
+	var due []*scheduledScan
+	var wake time.Time
+	idx.scheduleMu.Lock()
+	for _, entry := range idx.schedules {
+		if !entry.next.IsZero() && !entry.next.After(now) {
+			due = append(due, entry)
+			entry.next = entry.schedule.Next(now)
+		}
+		if !entry.next.IsZero() && (wake.IsZero() || entry.next.Before(wake)) {
+			wake = entry.next
+		}
+	}
+	idx.scheduleMu.Unlock()
+
+	for _, entry := range due {
+		idx.queueScan(ctx, entry.mode, []ScanTarget{{Root: entry.root}}, time.Time{})
+	}
+	return wake
+}
+
+// scheduleStatuses returns the state of every periodic scan, or nil when the
+// scheduler is not running.
+func (idx *Indexer) scheduleStatuses() []ScheduleStatus {
+	idx.scheduleMu.Lock()
+	defer idx.scheduleMu.Unlock()
+	if len(idx.schedules) == 0 {
+		return nil
+	}
+
+	statuses := make([]ScheduleStatus, 0, len(idx.schedules))
+	for _, entry := range idx.schedules {
+		statuses = append(statuses, ScheduleStatus{
+			Root:     entry.root,
+			Mode:     string(entry.mode),
+			Schedule: entry.schedule.String(),
+			NextRun:  entry.next,
+			Queued:   idx.queuedFor(entry.root, entry.mode),
+		})
+	}
+	return statuses
+}
+
+// lastScan returns when the root was last scanned in mode. A full scan also
+// counts as an incremental one. Modes a root was never scanned in are stored
+// as times before the Unix epoch.
+func lastScan(state storage.ScanState, mode ScanMode) (time.Time, bool) {
+	last := state.LastIncrementalScan
+	if mode == ScanModeFull {
+		last = state.LastFullScan
+	}
+	return last, last.After(time.Unix(0, 0))
+}
@@ -0,0 +1,90 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// buildTree creates a synthetic tree beneath root with fanout directories
+// per level, depth levels deep, and files files in every directory. It
+// returns the number of files created.
+func buildTree(tb testing.TB, root string, fanout, depth, files int) int {
+	tb.Helper()
+	created := 0
+	var build func(dir string, level int)
+	build = func(dir string, level int) {
+		for i := 0; i < files; i++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%03d.txt", i)), []byte("x"), 0o644); err != nil {
+				tb.Fatal(err)
+			}
+			created++
+		}
+		if level == depth {
+			return
+		}
+		for i := 0; i < fanout; i++ {
+			child := filepath.Join(dir, fmt.Sprintf("dir-%02d", i))
+			if err := os.Mkdir(child, 0o755); err != nil {
+				tb.Fatal(err)
+			}
+			build(child, level+1)
+		}
+	}
+	build(root, 0)
+	return created
+}
+
+// BenchmarkWalk compares walkTree with walkTreeParallel on a synthetic tree
+// of roughly 11,000 files in 1,100 directories. The tree is built once, so
+// the walks run against a warm cache.
+func BenchmarkWalk(b *testing.B) {
+	root := b.TempDir()
+	want := buildTree(b, root, 10, 3, 10)
+	idx, err := New([]string{root}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	root = idx.Roots()[0]
+
+	b.Run("walkTree", func(b *testing.B) {
+		benchmarkWalk(b, idx, root, want, 1)
+	})
+	for _, workers := range []int{2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("parallel/workers=%d", workers), func(b *testing.B) {
+			benchmarkWalk(b, idx, root, want, workers)
+		})
+	}
+}
+
+// benchmarkWalk walks root with the given number of workers; one worker
+// uses walkTree.
+func benchmarkWalk(b *testing.B, idx *Indexer, root string, want, workers int) {
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		var visited atomic.Int64
+		visit := func(path string, entry fs.DirEntry) error {
+			if !entry.IsDir() {
+				visited.Add(1)
+			}
+			return nil
+		}
+		state := newWalkState()
+		var err error
+		if workers == 1 {
+			err = idx.walkTree(ctx, root, root, state, visit)
+		} else {
+			err = idx.walkTreeParallel(ctx, root, root, state, workers, visit)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		if got := visited.Load(); got != int64(want) {
+			b.Fatalf("visited %d files, want %d", got, want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// maxWalkErrors caps the walk errors a scan keeps; further errors are only
+// counted.
+const maxWalkErrors = 100
+
+// WalkErrorKind classifies an error met while walking a root.
+type WalkErrorKind string
+
+const (
+	// WalkErrorPermission is reported for entries the indexer may not read.
+	WalkErrorPermission WalkErrorKind = "permission"
+	// WalkErrorNotFound is reported for a root that does not exist.
+	WalkErrorNotFound WalkErrorKind = "not-found"
+	// WalkErrorIO is reported when the device fails to read an entry.
+	WalkErrorIO WalkErrorKind = "io"
+	// WalkErrorUnavailable is reported for broken or disconnected mounts,
+	// such as a stale NFS handle.
+	WalkErrorUnavailable WalkErrorKind = "unavailable"
+	// WalkErrorOther covers every other error.
+	WalkErrorOther WalkErrorKind = "other"
+)
+
+// walkErrorKind classifies err.
+func walkErrorKind(err error) WalkErrorKind {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return WalkErrorPermission
+	case errors.Is(err, fs.ErrNotExist):
+		return WalkErrorNotFound
+	}
+	if kind, ok := deviceErrorKind(err); ok {
+		return kind
+	}
+	return WalkErrorOther
+}
+
+// WalkError is a directory or file that a scan could not read.
+type WalkError struct {
+	Path  string        `json:"path"`
+	Kind  WalkErrorKind `json:"kind"`
+	Error string        `json:"error"`
+}
+
+// WalkErrors aggregates the errors met while walking the roots of a scan.
+type WalkErrors struct {
+	// Count is the total number of errors and Kinds their number by kind.
+	Count int64                   `json:"count"`
+	Kinds map[WalkErrorKind]int64 `json:"kinds"`
+	// Errors holds the first errors, up to a cap; Count may be larger.
+	Errors []WalkError `json:"errors"`
+}
+
+func (e *WalkErrors) add(path string, err error) {
+	kind := walkErrorKind(err)
+	if e.Kinds == nil {
+		e.Kinds = make(map[WalkErrorKind]int64)
+	}
+	e.Count++
+	e.Kinds[kind]++
+	if len(e.Errors) < maxWalkErrors {
+		e.Errors = append(e.Errors, WalkError{Path: path, Kind: kind, Error: err.Error()})
+	}
+}
+
+// clone returns a copy of e, or nil when no error was recorded.
+func (e *WalkErrors) clone() *WalkErrors {
+	if e == nil || e.Count == 0 {
+		return nil
+	}
+	return &WalkErrors{Count: e.Count, Kinds: maps.Clone(e.Kinds), Errors: slices.Clone(e.Errors)}
+}
+
+// UnreadablePolicy decides what a scan does with the indexed records beneath
+// a directory or file it could not read.
+type UnreadablePolicy string
+
+const (
+	// UnreadableKeep keeps the records as they were, so that a directory
+	// that is briefly inaccessible or a broken mount does not empty the
+	// index. It is the default.
+	UnreadableKeep UnreadablePolicy = "keep"
+	// UnreadableRemove removes the records as if the entries were gone.
+	UnreadableRemove UnreadablePolicy = "remove"
+)
+
+// ParseUnreadablePolicy validates an unreadable policy string and falls back
+// to UnreadableKeep when empty.
+func ParseUnreadablePolicy(policy string) (UnreadablePolicy, error) {
+	if policy == "" {
+		return UnreadableKeep, nil
+	}
+	switch strings.ToLower(policy) {
+	case string(UnreadableKeep):
+		return UnreadableKeep, nil
+	case string(UnreadableRemove):
+		return UnreadableRemove, nil
+	default:
+		return "", fmt.Errorf("unknown unreadable policy %q", policy)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package indexer
+
+func deviceErrorKind(error) (WalkErrorKind, bool) {
+	return "", false
+}
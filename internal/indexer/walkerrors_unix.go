@@ -0,0 +1,20 @@
+//go:build unix
+
+package indexer
+
+import (
+	"errors"
+	"syscall"
+)
+
+// deviceErrorKind classifies errors raised by a failing device or mount.
+func deviceErrorKind(err error) (WalkErrorKind, bool) {
+	switch {
+	case errors.Is(err, syscall.EIO):
+		return WalkErrorIO, true
+	case errors.Is(err, syscall.ENOTCONN), errors.Is(err, syscall.ESTALE),
+		errors.Is(err, syscall.EHOSTDOWN), errors.Is(err, syscall.ETIMEDOUT):
+		return WalkErrorUnavailable, true
+	}
+	return "", false
+}
@@ -0,0 +1,500 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// watchFlushDelay bounds how long filesystem events are collected before
+	// they are applied to the index.
+	watchFlushDelay = 500 * time.Millisecond
+	// watchEventBuffer is the capacity of the channel between a watch backend
+	// and the goroutine applying its events.
+	watchEventBuffer = 4096
+	// watchRetryMin and watchRetryMax bound the delay before a failed watch
+	// backend is replaced. The delay doubles with every consecutive failure.
+	watchRetryMin = time.Second
+	watchRetryMax = time.Minute
+)
+
+// errWatchUnsupported is returned by newWatchBackend on platforms without a
+// filesystem notification backend.
+var errWatchUnsupported = errors.New("filesystem watching is not supported on this platform")
+
+// WatchStatus reports the health of the filesystem watcher attached to a scan root.
+type WatchStatus struct {
+	Root        string    `json:"root"`
+	Active      bool      `json:"active"`
+	WatchedDirs int       `json:"watchedDirs"`
+	Events      int64     `json:"events"`
+	Rescans     int64     `json:"rescans"`
+	Overflows   int64     `json:"overflows"`
+	LastEvent   time.Time `json:"lastEvent"`
+	LastRescan  time.Time `json:"lastRescan"`
+	// Restarts counts the times the watcher was restarted after its backend
+	// failed; the root is rescanned after each restart.
+	Restarts    int64     `json:"restarts"`
+	LastRestart time.Time `json:"lastRestart"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type watchOp int
+
+const (
+	watchCreate watchOp = iota
+	watchWrite
+	watchRemove
+	watchMoveFrom
+	watchMoveTo
+	watchSelfGone
+	watchOverflow
+)
+
+// watchEvent is a platform-neutral filesystem notification.
+type watchEvent struct {
+	Op     watchOp
+	Path   string
+	IsDir  bool
+	Cookie uint32
+}
+
+// watchBackend abstracts the operating system notification facility.
+type watchBackend interface {
+	// Add starts watching a single directory (not recursively).
+	Add(dir string) error
+	// RemoveTree stops watching dir and every watched directory beneath it.
+	RemoveTree(dir string)
+	// Count reports the number of watched directories.
+	Count() int
+	Events() <-chan watchEvent
+	Errors() <-chan error
+	Close() error
+}
+
+type rootWatch struct {
+	root    string
+	backend watchBackend
+	writer  recordWriter
+}
+
+// watchMove is a rename reported as a matching pair of move events.
+type watchMove struct {
+	from string
+	to   string
+}
+
+// moveSource is a move-from event waiting for its move-to event. held is set
+// once the source has been kept back from a flush.
+type moveSource struct {
+	path string
+	held bool
+}
+
+// StartWatching attaches a filesystem watcher to each of the provided roots so
+// that changes are applied to the index within seconds, without waiting for
+// the next scan. Roots that are not configured scan roots are rejected. The
+// watchers stop when the context is cancelled.
+func (idx *Indexer) StartWatching(ctx context.Context, roots []string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	configured := make(map[string]struct{}, len(idx.scanRoots))
+	for _, root := range idx.scanRoots {
+		configured[root] = struct{}{}
+	}
+
+	var errs []error
+	for _, raw := range roots {
+		root := filepath.Clean(raw)
+		if _, ok := configured[root]; !ok {
+			errs = append(errs, fmt.Errorf("watch %s: not a configured scan root", root))
+			continue
+		}
+
+		idx.watchMu.Lock()
+		if existing, ok := idx.watches[root]; ok && existing.Active {
+			idx.watchMu.Unlock()
+			continue
+		}
+		status := &WatchStatus{Root: root}
+		idx.watches[root] = status
+		idx.watchMu.Unlock()
+
+		backend, err := newWatchBackend()
+		if err != nil {
+			idx.updateWatch(root, func(status *WatchStatus) {
+				status.Error = err.Error()
+			})
+			errs = append(errs, fmt.Errorf("watch %s: %w", root, err))
+			continue
+		}
+
+		w := &rootWatch{
+			root:    root,
+			backend: backend,
+			writer:  directWriter{idx: idx, origin: changeOrigin{cause: ChangeCauseWatch}},
+		}
+		if err := idx.addWatchTree(w, root); err != nil {
+			idx.updateWatch(root, func(status *WatchStatus) {
+				status.Error = err.Error()
+			})
+			errs = append(errs, fmt.Errorf("watch %s: %w", root, err))
+		}
+
+		idx.updateWatch(root, func(status *WatchStatus) {
+			status.Active = true
+			status.WatchedDirs = backend.Count()
+		})
+
+		go idx.runWatch(ctx, w)
+	}
+
+	return errors.Join(errs...)
+}
+
+// runWatch applies the events of w until ctx is cancelled. When the backend
+// fails, it is replaced after a delay and the root is rescanned for the
+// changes missed in between.
+func (idx *Indexer) runWatch(ctx context.Context, w *rootWatch) {
+	delay := watchRetryMin
+	resync := false
+	for {
+		started := time.Now()
+		err := idx.watchEvents(ctx, w, resync)
+		w.backend.Close()
+		idx.updateWatch(w.root, func(status *WatchStatus) {
+			status.Active = false
+			status.WatchedDirs = 0
+			if err != nil {
+				status.Error = err.Error()
+			}
+		})
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		// A backend that ran for a while before failing starts over with
+		// the shortest delay.
+		if time.Since(started) > watchRetryMax {
+			delay = watchRetryMin
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, watchRetryMax)
+
+			backend, err := newWatchBackend()
+			if err != nil {
+				idx.updateWatch(w.root, func(status *WatchStatus) {
+					status.Error = err.Error()
+				})
+				continue
+			}
+			w.backend = backend
+			break
+		}
+
+		err = idx.addWatchTree(w, w.root)
+		idx.updateWatch(w.root, func(status *WatchStatus) {
+			status.Active = true
+			status.WatchedDirs = w.backend.Count()
+			status.Restarts++
+			status.LastRestart = time.Now()
+			status.Error = ""
+			if err != nil {
+				status.Error = err.Error()
+			}
+		})
+		resync = true
+	}
+}
+
+// watchEvents collects the events of w's backend and applies them in
+// batches. When resync is set, the whole root is rescanned first. It returns
+// nil when ctx is cancelled and the backend's error when it fails.
+func (idx *Indexer) watchEvents(ctx context.Context, w *rootWatch, resync bool) error {
+	pending := make(map[string]struct{})
+	rescans := make(map[string]struct{})
+	// sources holds move-from events by cookie until the matching move-to
+	// event arrives. A source that is still unpaired at a flush is held back
+	// for one more flush, since its move-to event may arrive just after it;
+	// after that its path is refreshed like any other.
+	sources := make(map[uint32]*moveSource)
+	var moves []watchMove
+
+	flush := time.NewTimer(watchFlushDelay)
+	if !flush.Stop() {
+		<-flush.C
+	}
+	armed := false
+	if resync {
+		rescans[w.root] = struct{}{}
+		flush.Reset(0)
+		armed = true
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush.Stop()
+			return nil
+		case err, ok := <-w.backend.Errors():
+			flush.Stop()
+			if !ok {
+				return errors.New("watch backend stopped")
+			}
+			return err
+		case event, ok := <-w.backend.Events():
+			if !ok {
+				flush.Stop()
+				select {
+				case err := <-w.backend.Errors():
+					return err
+				default:
+					return errors.New("watch backend stopped")
+				}
+			}
+
+			idx.updateWatch(w.root, func(status *WatchStatus) {
+				status.Events++
+				status.LastEvent = time.Now()
+				if event.Op == watchOverflow {
+					status.Overflows++
+				}
+			})
+
+			switch event.Op {
+			case watchOverflow:
+				rescans[w.root] = struct{}{}
+			case watchSelfGone:
+				if event.Path == w.root {
+					idx.updateWatch(w.root, func(status *WatchStatus) {
+						status.Error = "scan root was removed or moved"
+					})
+					continue
+				}
+				pending[event.Path] = struct{}{}
+			case watchMoveFrom:
+				if event.IsDir {
+					w.backend.RemoveTree(event.Path)
+				}
+				if event.Cookie != 0 {
+					sources[event.Cookie] = &moveSource{path: event.Path}
+				}
+				pending[event.Path] = struct{}{}
+			case watchMoveTo:
+				if source, ok := sources[event.Cookie]; ok && event.Cookie != 0 {
+					delete(sources, event.Cookie)
+					delete(pending, source.path)
+					moves = append(moves, watchMove{from: source.path, to: event.Path})
+				}
+				fallthrough
+			case watchCreate:
+				if event.IsDir {
+					rescans[event.Path] = struct{}{}
+				} else {
+					pending[event.Path] = struct{}{}
+				}
+			default:
+				pending[event.Path] = struct{}{}
+			}
+
+			if !event.IsDir && idx.rootConfig(w.root).isIgnoreFile(filepath.Base(event.Path)) {
+				rescans[filepath.Dir(event.Path)] = struct{}{}
+			}
+
+			if !armed {
+				flush.Reset(watchFlushDelay)
+				armed = true
+			}
+		case <-flush.C:
+			armed = false
+			for cookie, source := range sources {
+				if source.held {
+					delete(sources, cookie)
+					pending[source.path] = struct{}{}
+					continue
+				}
+				source.held = true
+				delete(pending, source.path)
+			}
+			idx.applyWatchChanges(ctx, w, moves, pending, rescans)
+			pending = make(map[string]struct{})
+			rescans = make(map[string]struct{})
+			moves = nil
+			if len(sources) > 0 {
+				flush.Reset(watchFlushDelay)
+				armed = true
+			}
+		}
+	}
+}
+
+// applyWatchChanges reconciles the index with the paths touched since the
+// last flush. Renames are applied first, in the order they happened, so that
+// moved records keep their data. Directories that appeared (or overflowed the
+// event queue) are then rescanned as a whole; everything else is refreshed
+// path by path.
+func (idx *Indexer) applyWatchChanges(ctx context.Context, w *rootWatch, moves []watchMove, pending, rescans map[string]struct{}) {
+	dirs := collapseDirs(rescans)
+	covered := func(path string) bool {
+		for _, dir := range dirs {
+			if withinDir(dir, path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var firstErr error
+	for _, move := range moves {
+		if err := idx.moveTree(ctx, w.writer, w.root, move.from, move.to); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := idx.addWatchTree(w, dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := idx.syncTree(ctx, w.writer, w.root, dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	paths := make([]string, 0, len(pending))
+	for path := range pending {
+		if !covered(path) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err := idx.syncPath(ctx, w, path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	idx.updateWatch(w.root, func(status *WatchStatus) {
+		status.WatchedDirs = w.backend.Count()
+		if len(dirs) > 0 {
+			status.Rescans += int64(len(dirs))
+			status.LastRescan = time.Now()
+		}
+		if firstErr != nil {
+			status.Error = firstErr.Error()
+		} else {
+			status.Error = ""
+		}
+	})
+}
+
+// syncPath refreshes a single path reported by the watcher.
+func (idx *Indexer) syncPath(ctx context.Context, w *rootWatch, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		w.backend.RemoveTree(path)
+		return idx.deleteTree(ctx, w.writer, path)
+	}
+
+	if idx.isExcluded(w.root, path, info.IsDir()) {
+		if info.IsDir() {
+			w.backend.RemoveTree(path)
+		}
+		return idx.deleteTree(ctx, w.writer, path)
+	}
+
+	if info.IsDir() {
+		if err := idx.addWatchTree(w, path); err != nil {
+			return err
+		}
+		return idx.syncTree(ctx, w.writer, w.root, path)
+	}
+
+	return idx.refreshRecord(ctx, w.writer, w.root, path, info)
+}
+
+// addWatchTree registers dir and all of its subdirectories that are not
+// excluded by the root's rules with the backend.
+func (idx *Indexer) addWatchTree(w *rootWatch, dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	return idx.walkTree(context.Background(), w.root, dir, newWalkState(), func(path string, entry fs.DirEntry) error {
+		if !entry.IsDir() {
+			return nil
+		}
+		if addErr := w.backend.Add(path); addErr != nil {
+			return fmt.Errorf("watch directory %s: %w", path, addErr)
+		}
+		return nil
+	})
+}
+
+func (idx *Indexer) updateWatch(root string, update func(*WatchStatus)) {
+	idx.watchMu.Lock()
+	status, ok := idx.watches[root]
+	if !ok {
+		status = &WatchStatus{Root: root}
+		idx.watches[root] = status
+	}
+	update(status)
+	idx.watchMu.Unlock()
+	idx.statusChanged()
+}
+
+func (idx *Indexer) watchStatuses() []WatchStatus {
+	idx.watchMu.RLock()
+	defer idx.watchMu.RUnlock()
+	if len(idx.watches) == 0 {
+		return nil
+	}
+
+	statuses := make([]WatchStatus, 0, len(idx.watches))
+	for _, root := range idx.scanRoots {
+		if status, ok := idx.watches[root]; ok {
+			statuses = append(statuses, *status)
+		}
+	}
+	return statuses
+}
+
+// collapseDirs returns the set of directories with nested entries removed,
+// since rescanning a parent already covers its children.
+func collapseDirs(dirs map[string]struct{}) []string {
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+
+	collapsed := make([]string, 0, len(sorted))
+	for _, dir := range sorted {
+		nested := false
+		for _, parent := range collapsed {
+			if withinDir(parent, dir) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			collapsed = append(collapsed, dir)
+		}
+	}
+	return collapsed
+}
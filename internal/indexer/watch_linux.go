@@ -0,0 +1,232 @@
+//go:build linux
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_ATTRIB |
+	unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_MOVE_SELF | unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW
+
+// inotifyBackend implements watchBackend on top of Linux inotify.
+type inotifyBackend struct {
+	fd int
+
+	mu      sync.Mutex
+	watches map[int]string
+	paths   map[string]int
+
+	events chan watchEvent
+	errors chan error
+	done   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+func newWatchBackend() (watchBackend, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("initialize inotify: %w", err)
+	}
+
+	backend := &inotifyBackend{
+		fd:      fd,
+		watches: make(map[int]string),
+		paths:   make(map[string]int),
+		events:  make(chan watchEvent, watchEventBuffer),
+		errors:  make(chan error, 1),
+		done:    make(chan struct{}),
+	}
+
+	backend.wg.Add(1)
+	go backend.readLoop()
+	return backend, nil
+}
+
+func (b *inotifyBackend) Add(dir string) error {
+	wd, err := unix.InotifyAddWatch(b.fd, dir, inotifyMask)
+	if err != nil {
+		if errors.Is(err, unix.ENOSPC) {
+			return fmt.Errorf("inotify watch limit reached (raise fs.inotify.max_user_watches): %w", err)
+		}
+		return err
+	}
+
+	b.mu.Lock()
+	if previous, ok := b.watches[wd]; ok && previous != dir {
+		delete(b.paths, previous)
+	}
+	b.watches[wd] = dir
+	b.paths[dir] = wd
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *inotifyBackend) RemoveTree(dir string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for path, wd := range b.paths {
+		if !withinDir(dir, path) {
+			continue
+		}
+		_, _ = unix.InotifyRmWatch(b.fd, uint32(wd))
+		delete(b.paths, path)
+		delete(b.watches, wd)
+	}
+}
+
+func (b *inotifyBackend) Count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.watches)
+}
+
+func (b *inotifyBackend) Events() <-chan watchEvent {
+	return b.events
+}
+
+func (b *inotifyBackend) Errors() <-chan error {
+	return b.errors
+}
+
+func (b *inotifyBackend) Close() error {
+	var err error
+	b.once.Do(func() {
+		close(b.done)
+		b.wg.Wait()
+		err = unix.Close(b.fd)
+	})
+	return err
+}
+
+func (b *inotifyBackend) readLoop() {
+	defer b.wg.Done()
+	defer close(b.events)
+
+	buf := make([]byte, 64*1024)
+	fds := []unix.PollFd{{Fd: int32(b.fd), Events: unix.POLLIN}}
+
+	for {
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+
+		ready, err := unix.Poll(fds, 500)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			b.fail(fmt.Errorf("poll inotify: %w", err))
+			return
+		}
+		if ready == 0 {
+			continue
+		}
+
+		n, err := unix.Read(b.fd, buf)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			b.fail(fmt.Errorf("read inotify: %w", err))
+			return
+		}
+
+		if !b.dispatch(buf[:n]) {
+			return
+		}
+	}
+}
+
+// dispatch decodes a buffer of raw inotify events. It returns false when the
+// backend is shutting down.
+func (b *inotifyBackend) dispatch(buf []byte) bool {
+	offset := 0
+	for offset+unix.SizeofInotifyEvent <= len(buf) {
+		raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + unix.SizeofInotifyEvent
+		nameEnd := nameStart + int(raw.Len)
+		if nameEnd > len(buf) {
+			break
+		}
+		name := strings.TrimRight(string(buf[nameStart:nameEnd]), "\x00")
+		offset = nameEnd
+
+		mask := raw.Mask
+		if mask&unix.IN_Q_OVERFLOW != 0 {
+			if !b.send(watchEvent{Op: watchOverflow}) {
+				return false
+			}
+			continue
+		}
+
+		b.mu.Lock()
+		dir, ok := b.watches[int(raw.Wd)]
+		if ok && mask&unix.IN_IGNORED != 0 {
+			delete(b.watches, int(raw.Wd))
+			if b.paths[dir] == int(raw.Wd) {
+				delete(b.paths, dir)
+			}
+		}
+		b.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		path := dir
+		if name != "" {
+			path = filepath.Join(dir, name)
+		}
+
+		event := watchEvent{Path: path, IsDir: mask&unix.IN_ISDIR != 0, Cookie: raw.Cookie}
+		switch {
+		case mask&unix.IN_MOVED_FROM != 0:
+			event.Op = watchMoveFrom
+		case mask&unix.IN_MOVED_TO != 0:
+			event.Op = watchMoveTo
+		case mask&unix.IN_CREATE != 0:
+			event.Op = watchCreate
+		case mask&unix.IN_DELETE != 0:
+			event.Op = watchRemove
+		case mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+			event.Op = watchSelfGone
+			event.IsDir = true
+		case mask&(unix.IN_CLOSE_WRITE|unix.IN_MODIFY|unix.IN_ATTRIB) != 0:
+			event.Op = watchWrite
+		default:
+			continue
+		}
+
+		if !b.send(event) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *inotifyBackend) send(event watchEvent) bool {
+	select {
+	case b.events <- event:
+		return true
+	case <-b.done:
+		return false
+	}
+}
+
+func (b *inotifyBackend) fail(err error) {
+	select {
+	case b.errors <- err:
+	default:
+	}
+}
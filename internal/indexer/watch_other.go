@@ -0,0 +1,7 @@
+//go:build !linux
+
+package indexer
+
+func newWatchBackend() (watchBackend, error) {
+	return nil, errWatchUnsupported
+}
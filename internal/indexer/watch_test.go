@@ -0,0 +1,172 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBackend is a watchBackend fed by the test.
+type fakeBackend struct {
+	events chan watchEvent
+	errors chan error
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{events: make(chan watchEvent, 16), errors: make(chan error, 1)}
+}
+
+func (b *fakeBackend) Add(string) error          { return nil }
+func (b *fakeBackend) RemoveTree(string)         {}
+func (b *fakeBackend) Count() int                { return 1 }
+func (b *fakeBackend) Events() <-chan watchEvent { return b.events }
+func (b *fakeBackend) Errors() <-chan error      { return b.errors }
+func (b *fakeBackend) Close() error              { return nil }
+
+// changeRecorder collects the changes reported to a change handler.
+type changeRecorder struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+func (r *changeRecorder) record(change Change) {
+	r.mu.Lock()
+	r.changes = append(r.changes, change)
+	r.mu.Unlock()
+}
+
+// kinds returns the kinds of the changes of path with the given cause.
+func (r *changeRecorder) kinds(cause ChangeCause, path string) []ChangeKind {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kinds []ChangeKind
+	for _, change := range r.changes {
+		if change.Cause == cause && (change.Path == path || change.OldPath == path) {
+			kinds = append(kinds, change.Kind)
+		}
+	}
+	return kinds
+}
+
+// eventually fails the test unless cond holds within five seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startFakeWatch runs the watcher of root on a fake backend until the test
+// ends.
+func startFakeWatch(t *testing.T, idx *Indexer, root string, run func(ctx context.Context, w *rootWatch)) *fakeBackend {
+	t.Helper()
+	backend := newFakeBackend()
+	w := &rootWatch{
+		root:    root,
+		backend: backend,
+		writer:  directWriter{idx: idx, origin: changeOrigin{cause: ChangeCauseWatch}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx, w)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return backend
+}
+
+func TestWatchPairsMovesAcrossFlushes(t *testing.T) {
+	var changes changeRecorder
+	idx, roots := newTestIndexer(t, 1, WithChangeHandler(changes.record))
+	root := roots[0]
+	from := filepath.Join(root, "from.txt")
+	to := filepath.Join(root, "to.txt")
+	gone := filepath.Join(root, "gone.txt")
+	writeFile(t, from, "moved")
+	writeFile(t, gone, "gone")
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	backend := startFakeWatch(t, idx, root, func(ctx context.Context, w *rootWatch) {
+		idx.watchEvents(ctx, w, false)
+	})
+
+	// The move-to event arrives after the flush that follows the move-from
+	// event; the source is held back until then.
+	if err := os.Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+	backend.events <- watchEvent{Op: watchMoveFrom, Path: from, Cookie: 7}
+	time.Sleep(watchFlushDelay * 3 / 2)
+	if _, ok := idx.Lookup(from); !ok {
+		t.Fatalf("%s was removed before its move-to event could arrive", from)
+	}
+	backend.events <- watchEvent{Op: watchMoveTo, Path: to, Cookie: 7}
+	eventually(t, "the move", func() bool {
+		_, ok := idx.Lookup(to)
+		return ok
+	})
+	if _, ok := idx.Lookup(from); ok {
+		t.Fatalf("%s is still indexed after it moved", from)
+	}
+	if got := changes.kinds(ChangeCauseWatch, from); !slices.Equal(got, []ChangeKind{ChangeMoved}) {
+		t.Fatalf("changes of %s = %v, want a single move", from, got)
+	}
+
+	// A source that stays unpaired is removed at the flush after the one it
+	// was held back from.
+	if err := os.Rename(gone, filepath.Join(t.TempDir(), "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	backend.events <- watchEvent{Op: watchMoveFrom, Path: gone, Cookie: 9}
+	eventually(t, "the removal", func() bool {
+		_, ok := idx.Lookup(gone)
+		return !ok
+	})
+	if got := changes.kinds(ChangeCauseWatch, gone); !slices.Equal(got, []ChangeKind{ChangeDeleted}) {
+		t.Fatalf("changes of %s = %v, want a single deletion", gone, got)
+	}
+}
+
+func TestWatchRestartsAfterBackendError(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("restarting needs a real watch backend")
+	}
+	idx, roots := newTestIndexer(t, 1)
+	root := roots[0]
+	scan(t, idx, ScanRequest{Mode: ScanModeIncremental})
+
+	backend := startFakeWatch(t, idx, root, idx.runWatch)
+
+	// A file created while the backend is down is picked up by the rescan
+	// that follows the restart.
+	missed := filepath.Join(root, "missed.txt")
+	writeFile(t, missed, "missed")
+	backend.errors <- errors.New("backend failed")
+
+	eventually(t, "the restart", func() bool {
+		statuses := idx.watchStatuses()
+		return len(statuses) == 1 && statuses[0].Restarts == 1 && statuses[0].Active
+	})
+	eventually(t, "the rescan", func() bool {
+		_, ok := idx.Lookup(missed)
+		return ok && idx.watchStatuses()[0].Rescans == 1
+	})
+	status := idx.watchStatuses()[0]
+	if status.Error != "" || status.LastRestart.IsZero() {
+		t.Fatalf("watch status after the restart = %+v", status)
+	}
+}
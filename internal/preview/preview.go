@@ -0,0 +1,242 @@
+// Package preview renders image thumbnails, cached on disk, and short text
+// previews of indexed files.
+package preview
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// DefaultThumbnailSize is the longest edge of a thumbnail in pixels.
+	DefaultThumbnailSize = 256
+	// DefaultTextLines is the number of lines in a text preview.
+	DefaultTextLines = 20
+
+	// maxImageBytes and maxImagePixels bound the images that are decoded.
+	maxImageBytes  = 64 << 20
+	maxImagePixels = 64 << 20
+	// maxTextBytes bounds how much of a file a text preview reads.
+	maxTextBytes = 64 << 10
+	// maxLineLength truncates very long lines of a text preview.
+	maxLineLength = 500
+
+	thumbnailQuality = 80
+)
+
+// ErrUnsupported is returned for files that cannot be previewed.
+var ErrUnsupported = errors.New("preview not available for this file")
+
+var imageExtensions = map[string]struct{}{
+	".png": {}, ".jpg": {}, ".jpeg": {}, ".gif": {}, ".webp": {},
+}
+
+// IsImage reports whether a file name has an extension that Thumbnail can
+// decode.
+func IsImage(name string) bool {
+	_, ok := imageExtensions[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// Options configures a Cache.
+type Options struct {
+	// Dir holds the cached thumbnails.
+	Dir string
+	// ThumbnailSize is the longest edge of a thumbnail; zero selects
+	// DefaultThumbnailSize.
+	ThumbnailSize int
+	// TextLines is the number of lines of a text preview; zero selects
+	// DefaultTextLines.
+	TextLines int
+}
+
+// Cache produces previews and keeps thumbnails on disk. A thumbnail is keyed
+// by the file's path, modification time and size, so a changed file never
+// hits a stale entry; Invalidate removes the entries of a path.
+type Cache struct {
+	dir       string
+	size      int
+	textLines int
+}
+
+// NewCache creates the cache directory if needed.
+func NewCache(opts Options) (*Cache, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("preview cache directory is required")
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create preview cache: %w", err)
+	}
+	c := &Cache{dir: opts.Dir, size: opts.ThumbnailSize, textLines: opts.TextLines}
+	if c.size <= 0 {
+		c.size = DefaultThumbnailSize
+	}
+	if c.textLines <= 0 {
+		c.textLines = DefaultTextLines
+	}
+	return c, nil
+}
+
+// Thumbnail returns a JPEG thumbnail of the image at path, rendering and
+// caching it on first use. size and modTime are the indexed metadata of the
+// file.
+func (c *Cache) Thumbnail(path string, size int64, modTime time.Time) ([]byte, error) {
+	if !IsImage(path) || size > maxImageBytes {
+		return nil, ErrUnsupported
+	}
+
+	cached := c.entryPath(path, size, modTime)
+	if data, err := os.ReadFile(cached); err == nil {
+		return data, nil
+	}
+
+	data, err := c.render(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the thumbnails of older versions before storing the new one.
+	c.Invalidate(path)
+	if err := os.MkdirAll(filepath.Dir(cached), 0o755); err == nil {
+		tmp := cached + ".tmp"
+		if err := os.WriteFile(tmp, data, 0o644); err == nil {
+			if err := os.Rename(tmp, cached); err != nil {
+				os.Remove(tmp)
+			}
+		}
+	}
+	return data, nil
+}
+
+// Invalidate removes the cached thumbnails of path.
+func (c *Cache) Invalidate(path string) {
+	key := pathKey(path)
+	matches, _ := filepath.Glob(filepath.Join(c.dir, key[:2], key+"-*"))
+	for _, match := range matches {
+		os.Remove(match)
+	}
+}
+
+func (c *Cache) entryPath(path string, size int64, modTime time.Time) string {
+	key := pathKey(path)
+	name := key + "-" + strconv.FormatInt(modTime.UnixNano(), 36) + "-" + strconv.FormatInt(size, 36) + ".jpg"
+	return filepath.Join(c.dir, key[:2], name)
+}
+
+func pathKey(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) render(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return nil, ErrUnsupported
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > c.size || height > c.size {
+		if width >= height {
+			width, height = c.size, max(1, height*c.size/width)
+		} else {
+			width, height = max(1, width*c.size/height), c.size
+		}
+	}
+
+	// JPEG has no alpha channel, so transparent areas are drawn on white.
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Text returns the first lines of a text file and whether it continues past
+// them. Files that look binary are rejected with ErrUnsupported.
+func (c *Cache) Text(path string) ([]string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	head := make([]byte, maxTextBytes)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, false, err
+	}
+	head = head[:n]
+	truncated := n == maxTextBytes
+	if !looksLikeText(head, truncated) {
+		return nil, false, ErrUnsupported
+	}
+
+	lines := make([]string, 0, c.textLines)
+	scanner := bufio.NewScanner(bytes.NewReader(head))
+	scanner.Buffer(make([]byte, 0, 4096), maxTextBytes)
+	for scanner.Scan() {
+		if len(lines) == c.textLines {
+			return lines, true, nil
+		}
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > maxLineLength {
+			line = strings.ToValidUTF8(line[:maxLineLength], "") + "…"
+		}
+		lines = append(lines, line)
+	}
+	return lines, truncated, nil
+}
+
+// looksLikeText rejects data containing NUL bytes or invalid UTF-8. A
+// sequence cut off at the end of a truncated read is tolerated.
+func looksLikeText(data []byte, truncated bool) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+	if truncated {
+		for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	return utf8.Valid(data)
+}
@@ -0,0 +1,566 @@
+package querylang
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxDepth bounds the nesting of parentheses and NOT.
+const maxDepth = 64
+
+// SyntaxError describes why a query could not be parsed.
+type SyntaxError struct {
+	// Pos is the 1-based character position of the offending input.
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	tokTerm
+)
+
+// valueForm is how the value of a term was written.
+type valueForm int
+
+const (
+	formWord valueForm = iota
+	formPhrase
+	formRegexp
+)
+
+type token struct {
+	kind tokenKind
+	pos  int
+	// The remaining fields describe a tokTerm.
+	field    Field
+	form     valueForm
+	value    string
+	valuePos int
+}
+
+var fields = map[string]Field{
+	"name":     FieldName,
+	"path":     FieldPath,
+	"ext":      FieldExt,
+	"root":     FieldRoot,
+	"size":     FieldSize,
+	"modified": FieldModified,
+}
+
+// Parse parses a query. An empty query yields a nil expression, which
+// matches everything.
+func Parse(input string) (Expr, error) {
+	p := &parser{input: input}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokEOF {
+		return nil, nil
+	}
+	expr, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf(p.tok.pos, "unexpected %s", p.describe())
+	}
+	return expr, nil
+}
+
+type parser struct {
+	input string
+	off   int
+	tok   token
+}
+
+func (p *parser) errorf(pos int, format string, args ...any) error {
+	return &SyntaxError{Pos: utf8.RuneCountInString(p.input[:pos]) + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) describe() string {
+	switch p.tok.kind {
+	case tokEOF:
+		return "end of query"
+	case tokLParen:
+		return `"("`
+	case tokRParen:
+		return `")"`
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokNot:
+		return "NOT"
+	default:
+		return "term"
+	}
+}
+
+// parseOr parses terms joined by OR, which binds looser than AND.
+func (p *parser) parseOr(depth int) (Expr, error) {
+	first, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	exprs := []Expr{first}
+	for p.tok.kind == tokOr {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	if len(exprs) == 1 {
+		return first, nil
+	}
+	return Or(exprs), nil
+}
+
+// parseAnd parses terms joined by AND or simply listed one after another.
+func (p *parser) parseAnd(depth int) (Expr, error) {
+	first, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	exprs := []Expr{first}
+	for {
+		switch p.tok.kind {
+		case tokAnd:
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		case tokTerm, tokNot, tokLParen:
+		default:
+			if len(exprs) == 1 {
+				return first, nil
+			}
+			return And(exprs), nil
+		}
+		expr, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+}
+
+func (p *parser) parseUnary(depth int) (Expr, error) {
+	if depth > maxDepth {
+		return nil, p.errorf(p.tok.pos, "query is nested too deeply")
+	}
+	switch p.tok.kind {
+	case tokNot:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	case tokLParen:
+		open := p.tok.pos
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokRParen {
+			return nil, p.errorf(p.tok.pos, "empty parentheses")
+		}
+		expr, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf(open, "missing closing parenthesis")
+		}
+		return expr, p.next()
+	case tokTerm:
+		expr, err := p.term(p.tok)
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.next()
+	default:
+		return nil, p.errorf(p.tok.pos, "expected a term, found %s", p.describe())
+	}
+}
+
+// term builds the expression of a single term.
+func (p *parser) term(tok token) (Expr, error) {
+	switch tok.field {
+	case FieldSize, FieldModified:
+		if tok.form != formWord {
+			return nil, p.errorf(tok.valuePos, "%s takes a number or date, not a phrase or regular expression", tok.field)
+		}
+		return p.comparison(tok)
+	}
+
+	switch tok.form {
+	case formRegexp:
+		re, err := regexp.Compile("(?i)" + tok.value)
+		if err != nil {
+			msg := err.Error()
+			var serr *syntax.Error
+			if errors.As(err, &serr) {
+				msg = string(serr.Code)
+			}
+			return nil, p.errorf(tok.valuePos, "invalid regular expression: %s", msg)
+		}
+		return Text{Field: tok.field, Mode: Regexp, Value: tok.value, Regexp: re}, nil
+	case formPhrase:
+		if tok.field == FieldExt || tok.field == FieldRoot {
+			return Text{Field: tok.field, Mode: Exact, Value: normalizeText(tok.field, tok.value)}, nil
+		}
+		return Text{Field: tok.field, Mode: Contains, Value: strings.ToLower(tok.value)}, nil
+	}
+
+	value := normalizeText(tok.field, tok.value)
+	if strings.ContainsAny(value, "*?") {
+		re := regexp.MustCompile("(?i)^" + wildcardPattern(value) + "$")
+		return Text{Field: tok.field, Mode: Wildcard, Value: value, Regexp: re}, nil
+	}
+	if tok.field == FieldExt || tok.field == FieldRoot {
+		return Text{Field: tok.field, Mode: Exact, Value: value}, nil
+	}
+	return Text{Field: tok.field, Mode: Contains, Value: value}, nil
+}
+
+func normalizeText(field Field, value string) string {
+	value = strings.ToLower(value)
+	if field == FieldExt {
+		value = strings.TrimSpace(value)
+		if value != "" && !strings.HasPrefix(value, ".") {
+			value = "." + value
+		}
+	}
+	return value
+}
+
+func wildcardPattern(pattern string) string {
+	escaped := regexp.QuoteMeta(pattern)
+	escaped = strings.ReplaceAll(escaped, `\*`, ".*")
+	return strings.ReplaceAll(escaped, `\?`, ".")
+}
+
+// comparison parses size:>10MB, modified:<2024-01-01 and ranges such as
+// size:1MB..5MB. A date stands for the whole day, month or year it names.
+func (p *parser) comparison(tok token) (Expr, error) {
+	value, pos := tok.value, tok.valuePos
+	op := Equal
+	for _, candidate := range []Op{GreaterEqual, LessEqual, Greater, Less, Equal} {
+		if strings.HasPrefix(value, string(candidate)) {
+			op = candidate
+			value = value[len(candidate):]
+			pos += len(candidate)
+			break
+		}
+	}
+
+	if low, high, ok := strings.Cut(value, ".."); ok {
+		if value != tok.value {
+			return nil, p.errorf(tok.valuePos, "a range cannot have a comparison operator")
+		}
+		if low == "" && high == "" {
+			return nil, p.errorf(pos, "empty range")
+		}
+		var exprs And
+		if low != "" {
+			start, _, err := p.bound(tok.field, low, pos)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, Compare{Field: tok.field, Op: GreaterEqual, Value: start})
+		}
+		if high != "" {
+			_, end, err := p.bound(tok.field, high, pos+len(low)+2)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, p.upTo(tok.field, end))
+		}
+		if len(exprs) == 1 {
+			return exprs[0], nil
+		}
+		return exprs, nil
+	}
+
+	start, end, err := p.bound(tok.field, value, pos)
+	if err != nil {
+		return nil, err
+	}
+	if tok.field == FieldSize {
+		return Compare{Field: tok.field, Op: op, Value: start}, nil
+	}
+	// A date covers [start, end).
+	switch op {
+	case Less:
+		return Compare{Field: tok.field, Op: Less, Value: start}, nil
+	case LessEqual:
+		return Compare{Field: tok.field, Op: Less, Value: end}, nil
+	case Greater:
+		return Compare{Field: tok.field, Op: GreaterEqual, Value: end}, nil
+	case GreaterEqual:
+		return Compare{Field: tok.field, Op: GreaterEqual, Value: start}, nil
+	default:
+		return And{
+			Compare{Field: tok.field, Op: GreaterEqual, Value: start},
+			Compare{Field: tok.field, Op: Less, Value: end},
+		}, nil
+	}
+}
+
+// upTo bounds a range from above: sizes inclusively, dates by the end of
+// the period.
+func (p *parser) upTo(field Field, end int64) Compare {
+	if field == FieldSize {
+		return Compare{Field: field, Op: LessEqual, Value: end}
+	}
+	return Compare{Field: field, Op: Less, Value: end}
+}
+
+// bound parses a size, returned as both values, or a date, returned as the
+// start and end of the period it names.
+func (p *parser) bound(field Field, value string, pos int) (int64, int64, error) {
+	if value == "" {
+		return 0, 0, p.errorf(pos, "missing %s value", field)
+	}
+	if field == FieldSize {
+		size, err := parseSize(value)
+		if err != nil {
+			return 0, 0, p.errorf(pos, "invalid size %q: %v", value, err)
+		}
+		return size, size, nil
+	}
+	start, end, err := parseDate(value)
+	if err != nil {
+		return 0, 0, p.errorf(pos, "invalid date %q: %v", value, err)
+	}
+	return start, end, nil
+}
+
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// parseSize parses a byte count with an optional binary unit, as in 512,
+// 10KB or 1.5GiB.
+func parseSize(value string) (int64, error) {
+	end := 0
+	for end < len(value) && (value[end] >= '0' && value[end] <= '9' || value[end] == '.') {
+		end++
+	}
+	if end == 0 {
+		return 0, fmt.Errorf("expected a number")
+	}
+	unit, ok := sizeUnits[strings.ToLower(value[end:])]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", value[end:])
+	}
+
+	// Whole numbers are parsed exactly, fractions as floating point.
+	if number, err := strconv.ParseInt(value[:end], 10, 64); err == nil {
+		if number > math.MaxInt64/int64(unit) {
+			return 0, fmt.Errorf("too large")
+		}
+		return number * int64(unit), nil
+	}
+	number, err := strconv.ParseFloat(value[:end], 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number")
+	}
+	size := number * unit
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("too large")
+	}
+	return int64(size), nil
+}
+
+// dateLayouts are tried in order; each names a period that ends when the
+// next one of the same length starts.
+var dateLayouts = []struct {
+	layout string
+	next   func(time.Time) time.Time
+}{
+	{time.RFC3339Nano, func(t time.Time) time.Time { return t.Add(time.Nanosecond) }},
+	{"2006-01-02T15:04:05", func(t time.Time) time.Time { return t.Add(time.Second) }},
+	{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+// Dates are stored as Unix nanoseconds, which cover this range.
+var (
+	minTime = time.Unix(0, math.MinInt64)
+	maxTime = time.Unix(0, math.MaxInt64)
+)
+
+// parseDate returns the period named by a date in local time, or by an
+// RFC 3339 timestamp, as Unix nanoseconds.
+func parseDate(value string) (int64, int64, error) {
+	for _, candidate := range dateLayouts {
+		start, err := time.ParseInLocation(candidate.layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+		end := candidate.next(start)
+		if start.Before(minTime) || end.After(maxTime) {
+			return 0, 0, fmt.Errorf("out of range")
+		}
+		return start.UnixNano(), end.UnixNano(), nil
+	}
+	return 0, 0, fmt.Errorf("expected YYYY, YYYY-MM, YYYY-MM-DD or an RFC 3339 time")
+}
+
+// next advances to the following token.
+func (p *parser) next() error {
+	for p.off < len(p.input) && isSpace(p.input[p.off]) {
+		p.off++
+	}
+	start := p.off
+	if start == len(p.input) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+
+	switch c := p.input[start]; {
+	case c == '(':
+		p.off++
+		p.tok = token{kind: tokLParen, pos: start}
+		return nil
+	case c == ')':
+		p.off++
+		p.tok = token{kind: tokRParen, pos: start}
+		return nil
+	case c == '-' && start+1 < len(p.input) && !isSpace(p.input[start+1]) && p.input[start+1] != ')':
+		p.off++
+		p.tok = token{kind: tokNot, pos: start}
+		return nil
+	}
+
+	// A field qualifier is a known name followed by a colon.
+	field := FieldName
+	valuePos := start
+	end := start
+	for end < len(p.input) && isLetter(p.input[end]) {
+		end++
+	}
+	if end > start && end < len(p.input) && p.input[end] == ':' {
+		name := strings.ToLower(p.input[start:end])
+		known, ok := fields[name]
+		if !ok {
+			return p.errorf(start, "unknown field %q", name)
+		}
+		field = known
+		valuePos = end + 1
+	}
+
+	tok := token{kind: tokTerm, pos: start, field: field, valuePos: valuePos}
+	p.off = valuePos
+	var err error
+	switch {
+	case p.off < len(p.input) && p.input[p.off] == '"':
+		tok.form = formPhrase
+		tok.value, err = p.delimited('"', "phrase")
+	case p.off < len(p.input) && p.input[p.off] == '/':
+		tok.form = formRegexp
+		tok.value, err = p.delimited('/', "regular expression")
+	default:
+		tok.form = formWord
+		tok.value = p.word()
+		if tok.value == "" {
+			return p.errorf(valuePos, "missing value for %s", field)
+		}
+		if valuePos == start {
+			switch tok.value {
+			case "AND":
+				tok = token{kind: tokAnd, pos: start}
+			case "OR":
+				tok = token{kind: tokOr, pos: start}
+			case "NOT":
+				tok = token{kind: tokNot, pos: start}
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// word reads up to the next space, parenthesis or quote.
+func (p *parser) word() string {
+	start := p.off
+	for p.off < len(p.input) {
+		c := p.input[p.off]
+		if isSpace(c) || c == '(' || c == ')' || c == '"' {
+			break
+		}
+		p.off++
+	}
+	return p.input[start:p.off]
+}
+
+// delimited reads a phrase or regular expression up to the closing
+// delimiter. In a phrase a backslash escapes any character; in a regular
+// expression only an escaped slash loses its backslash, so that the escape
+// sequences of the pattern are kept.
+func (p *parser) delimited(delim byte, what string) (string, error) {
+	open := p.off
+	p.off++
+	var b strings.Builder
+	for p.off < len(p.input) {
+		c := p.input[p.off]
+		switch {
+		case c == delim:
+			p.off++
+			return b.String(), nil
+		case c == '\\' && p.off+1 < len(p.input):
+			escaped := p.input[p.off+1]
+			if delim == '/' && escaped != '/' {
+				b.WriteByte(c)
+			}
+			b.WriteByte(escaped)
+			p.off += 2
+		default:
+			b.WriteByte(c)
+			p.off++
+		}
+	}
+	return "", p.errorf(open, "unterminated %s", what)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,157 @@
+package querylang
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "<nil>"},
+		{"report", `name:"report"`},
+		{"*.LOG", "name:*.log"},
+		{`"annual summary"`, `name:"annual summary"`},
+		{"a b OR c", `((name:"a" AND name:"b") OR name:"c")`},
+		{"a AND (b OR NOT c)", `(name:"a" AND (name:"b" OR NOT name:"c"))`},
+		{"-draft ext:PDF", `(NOT name:"draft" AND ext:".pdf")`},
+		{"path:reports/ root:archive", `(path:"reports/" AND root:"archive")`},
+		{`/^draft-\d+\/x/`, `name:/^draft-\d+\/x/`},
+		{"size:>10MB", "size:>10485760"},
+		{"size:1k..2k", "(size:>=1024 AND size:<=2048)"},
+		{"size:..1.5KB", "size:<=1536"},
+		{"modified:<2024-01-01T00:00:00Z", "modified:<2024-01-01T00:00:00Z"},
+		{"modified:>=2024-01-01T00:00:00Z", "modified:>=2024-01-01T00:00:00Z"},
+		{"10:30", `name:"10:30"`},
+		{`ext:""`, `ext:""`},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		got := "<nil>"
+		if expr != nil {
+			got = expr.String()
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{"a )", 3},
+		{"(a b", 1},
+		{"a OR", 5},
+		{"()", 2},
+		{`name:"open`, 6},
+		{"/unterminated", 1},
+		{"ext:/[a/", 5},
+		{"foo:bar", 1},
+		{"size:", 6},
+		{"size:10XB", 6},
+		{"modified:tomorrow", 10},
+		{"size:>1..2", 6},
+		{"é )", 3},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Parse(%q) error = %v, want a SyntaxError", tt.query, err)
+			continue
+		}
+		if syntaxErr.Pos != tt.pos {
+			t.Errorf("Parse(%q) error at %d, want %d: %v", tt.query, syntaxErr.Pos, tt.pos, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	record := Record{
+		Name:    "Report-2024.PDF",
+		Path:    "/data/archive/reports/Report-2024.PDF",
+		Root:    "/data/archive",
+		Size:    20 << 20,
+		ModTime: time.Date(2023, 6, 1, 12, 0, 0, 0, time.Local),
+	}
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"report", true},
+		{"report*.pdf", true},
+		{"report*.txt", false},
+		{"ext:pdf size:>10MB", true},
+		{"ext:pdf size:<10MB", false},
+		{"root:archive path:reports/", true},
+		{`root:"/data/archive"`, true},
+		{"root:arch", false},
+		{"modified:2023", true},
+		{"modified:2023-06-01", true},
+		{"modified:<2023-06-01", false},
+		{"modified:<=2023-06-01", true},
+		{"modified:2023-01..2023-05", false},
+		{"/^report-\\d{4}\\./", true},
+		{"NOT report OR ext:pdf", true},
+		{"-(report ext:pdf)", false},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		if got := Match(expr, record); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// FuzzParse checks that the parser never panics, that errors point into the
+// query and that a parsed query renders to a form that parses back to the
+// same tree.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"report", "*.log", `"a \"quoted\" phrase"`, "a b OR c", "(a OR b) -c",
+		"NOT NOT a", "ext:pdf size:>10MB modified:<2024-01-01",
+		"path:reports/ root:archive", `/^a\/b\d+$/`, "size:1KB..2.5MB",
+		"modified:2024-03..2024-04", "name:/x/ AND (ext:\"\" OR ext:*)",
+		"((a", "a)", "foo:bar", `"open`, "/[/", "size:>1..2", "-", "- a",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, query string) {
+		expr, err := Parse(query)
+		if err != nil {
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse(%q) returned %T, want *SyntaxError", query, err)
+			}
+			if syntaxErr.Pos < 1 || syntaxErr.Pos > len([]rune(query))+1 {
+				t.Fatalf("Parse(%q) error position %d out of range", query, syntaxErr.Pos)
+			}
+			return
+		}
+		if expr == nil {
+			return
+		}
+		rendered := expr.String()
+		again, err := Parse(rendered)
+		if err != nil {
+			t.Fatalf("Parse(%q) rendered as %q, which fails: %v", query, rendered, err)
+		}
+		if again.String() != rendered {
+			t.Fatalf("Parse(%q) rendered as %q, then as %q", query, rendered, again.String())
+		}
+	})
+}
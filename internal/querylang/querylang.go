@@ -0,0 +1,251 @@
+// Package querylang parses the search box syntax into an expression tree.
+//
+// A query is a list of terms that must all match. Terms combine with AND, OR
+// and NOT (or a leading -), group with parentheses and match the file name
+// unless they carry a field qualifier:
+//
+//	report "annual summary" /^draft-\d+/
+//	ext:pdf (path:reports/ OR root:archive) NOT name:*.tmp
+//	size:>10MB modified:<2024-01-01 size:1MB..5MB modified:2024-03
+//
+// Bare words match a substring of the field, or the whole field when they
+// contain * or ?. Quoted phrases match a substring verbatim and /.../ is a
+// regular expression. All text matching ignores case.
+package querylang
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field names the file attribute a term applies to.
+type Field string
+
+const (
+	FieldName     Field = "name"
+	FieldPath     Field = "path"
+	FieldExt      Field = "ext"
+	FieldRoot     Field = "root"
+	FieldSize     Field = "size"
+	FieldModified Field = "modified"
+)
+
+// Mode selects how a Text term compares its value.
+type Mode int
+
+const (
+	// Contains matches a case-insensitive substring.
+	Contains Mode = iota
+	// Wildcard matches the whole field against a * and ? pattern.
+	Wildcard
+	// Regexp matches a regular expression anywhere in the field.
+	Regexp
+	// Exact matches the whole field. A root also matches by its base name.
+	Exact
+)
+
+// Op is the comparison of a Compare term.
+type Op string
+
+const (
+	Less         Op = "<"
+	LessEqual    Op = "<="
+	Greater      Op = ">"
+	GreaterEqual Op = ">="
+	Equal        Op = "="
+)
+
+// Expr is a node of a parsed query.
+type Expr interface {
+	// String renders the node in a form that parses back to the same tree.
+	String() string
+	match(Record) bool
+}
+
+// And matches when every operand matches.
+type And []Expr
+
+// Or matches when any operand matches.
+type Or []Expr
+
+// Not inverts its operand.
+type Not struct {
+	Expr Expr
+}
+
+// Text compares a name, path, extension or root.
+type Text struct {
+	Field Field
+	Mode  Mode
+	// Value is lower-case for Contains, Wildcard and Exact; extensions keep
+	// their leading dot. For Regexp it is the pattern as written.
+	Value string
+	// Regexp is compiled for the Wildcard and Regexp modes.
+	Regexp *regexp.Regexp
+}
+
+// Compare compares the size in bytes or the modification time in Unix
+// nanoseconds.
+type Compare struct {
+	Field Field
+	Op    Op
+	Value int64
+}
+
+// Record holds the attributes of a file that a query matches against.
+type Record struct {
+	Name    string
+	Path    string
+	Root    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Match reports whether the record satisfies expr. A nil expression matches
+// every record.
+func Match(expr Expr, record Record) bool {
+	if expr == nil {
+		return true
+	}
+	return expr.match(record)
+}
+
+func (e And) match(r Record) bool {
+	for _, operand := range e {
+		if !operand.match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e Or) match(r Record) bool {
+	for _, operand := range e {
+		if operand.match(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e Not) match(r Record) bool {
+	return !e.Expr.match(r)
+}
+
+func (e Text) match(r Record) bool {
+	var value string
+	switch e.Field {
+	case FieldPath:
+		value = r.Path
+	case FieldExt:
+		value = strings.ToLower(filepath.Ext(r.Name))
+	case FieldRoot:
+		value = r.Root
+	default:
+		value = r.Name
+	}
+
+	switch e.Mode {
+	case Wildcard, Regexp:
+		return e.Regexp.MatchString(value)
+	case Exact:
+		value = strings.ToLower(value)
+		if e.Field == FieldRoot && value != e.Value {
+			return strings.ToLower(filepath.Base(r.Root)) == e.Value
+		}
+		return value == e.Value
+	default:
+		return strings.Contains(strings.ToLower(value), e.Value)
+	}
+}
+
+func (e Compare) match(r Record) bool {
+	value := r.Size
+	if e.Field == FieldModified {
+		value = r.ModTime.UnixNano()
+	}
+	switch e.Op {
+	case Less:
+		return value < e.Value
+	case LessEqual:
+		return value <= e.Value
+	case Greater:
+		return value > e.Value
+	case GreaterEqual:
+		return value >= e.Value
+	default:
+		return value == e.Value
+	}
+}
+
+func (e And) String() string {
+	return joinExprs(e, " AND ")
+}
+
+func (e Or) String() string {
+	return joinExprs(e, " OR ")
+}
+
+func joinExprs(exprs []Expr, sep string) string {
+	parts := make([]string, len(exprs))
+	for i, expr := range exprs {
+		parts[i] = expr.String()
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+func (e Not) String() string {
+	return "NOT " + e.Expr.String()
+}
+
+func (e Text) String() string {
+	switch e.Mode {
+	case Wildcard:
+		return string(e.Field) + ":" + e.Value
+	case Regexp:
+		return string(e.Field) + ":/" + escapeRegexp(e.Value) + "/"
+	default:
+		return string(e.Field) + ":" + quote(e.Value)
+	}
+}
+
+func (e Compare) String() string {
+	var value string
+	if e.Field == FieldModified {
+		value = time.Unix(0, e.Value).UTC().Format(time.RFC3339Nano)
+	} else {
+		value = strconv.FormatInt(e.Value, 10)
+	}
+	if e.Op == Equal {
+		return string(e.Field) + ":" + value
+	}
+	return fmt.Sprintf("%s:%s%s", e.Field, e.Op, value)
+}
+
+func quote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// escapeRegexp escapes the slashes of a pattern; escape sequences are kept
+// as they are.
+func escapeRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			b.WriteByte(c)
+			i++
+			b.WriteByte(pattern[i])
+		case c == '/':
+			b.WriteString(`\/`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
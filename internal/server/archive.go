@@ -0,0 +1,392 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"seekfile/internal/auth"
+	"seekfile/internal/indexer"
+)
+
+const (
+	// DefaultArchiveMaxFiles caps the number of files in one archive.
+	DefaultArchiveMaxFiles = 10000
+	// DefaultArchiveMaxBytes caps the total size of the files in one archive.
+	DefaultArchiveMaxBytes int64 = 4 << 30
+
+	archiveFormatZip   = "zip"
+	archiveFormatTarGz = "tar.gz"
+
+	// maxArchiveRequestSize bounds the request body listing the paths.
+	maxArchiveRequestSize = 8 << 20
+)
+
+var errArchiveTooLarge = errors.New("archive exceeds the file count or size limit")
+
+// ArchiveLimits bounds what /api/archive packs into a single download.
+type ArchiveLimits struct {
+	MaxFiles int
+	MaxBytes int64
+}
+
+// WithArchiveLimits overrides the default archive limits. Zero values keep
+// the defaults.
+func WithArchiveLimits(limits ArchiveLimits) Option {
+	return func(s *Server) {
+		if limits.MaxFiles > 0 {
+			s.archive.MaxFiles = limits.MaxFiles
+		}
+		if limits.MaxBytes > 0 {
+			s.archive.MaxBytes = limits.MaxBytes
+		}
+	}
+}
+
+// archiveRequest selects the files of an archive: either explicit paths,
+// where a directory stands for every indexed file beneath it, or the
+// matches of a search.
+type archiveRequest struct {
+	Format string         `json:"format"`
+	Paths  []string       `json:"paths"`
+	Search *archiveSearch `json:"search"`
+}
+
+// archiveSearch takes the filters of /api/search.
+type archiveSearch struct {
+	Query    string   `json:"query"`
+	Content  string   `json:"content"`
+	MinSize  int64    `json:"minSize"`
+	MaxSize  int64    `json:"maxSize"`
+	Category []string `json:"category"`
+	Ext      []string `json:"ext"`
+	Root     []string `json:"root"`
+	Size     string   `json:"size"`
+	Modified string   `json:"modified"`
+	Fuzzy    bool     `json:"fuzzy"`
+}
+
+func (a archiveSearch) values() url.Values {
+	values := url.Values{
+		"query":    {a.Query},
+		"content":  {a.Content},
+		"category": a.Category,
+		"ext":      a.Ext,
+		"root":     a.Root,
+		"size":     {a.Size},
+		"modified": {a.Modified},
+		"fuzzy":    {strconv.FormatBool(a.Fuzzy)},
+	}
+	if a.MinSize > 0 {
+		values.Set("minSize", strconv.FormatInt(a.MinSize, 10))
+	}
+	if a.MaxSize > 0 {
+		values.Set("maxSize", strconv.FormatInt(a.MaxSize, 10))
+	}
+	return values
+}
+
+// handleArchive streams a zip or tar.gz of indexed files. It accepts a JSON
+// body or a form post, so that the browser can save the stream directly.
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := parseArchiveRequest(w, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Format == "" {
+		req.Format = archiveFormatZip
+	}
+	if req.Format != archiveFormatZip && req.Format != archiveFormatTarGz {
+		http.Error(w, fmt.Sprintf("unsupported format %q", req.Format), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	records, err := s.archiveRecords(ctx, r, req)
+	cancel()
+	if err != nil {
+		switch {
+		case errors.Is(err, errArchiveTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case isQueryError(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			var status archiveStatusError
+			if errors.As(err, &status) {
+				http.Error(w, err.Error(), status.code)
+				return
+			}
+			http.Error(w, fmt.Sprintf("archive: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, "no files selected", http.StatusBadRequest)
+		return
+	}
+
+	name := fmt.Sprintf("seekfile-%s.%s", time.Now().Format("20060102-150405"), req.Format)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	if req.Format == archiveFormatZip {
+		w.Header().Set("Content-Type", "application/zip")
+		err = writeZip(w, records)
+	} else {
+		w.Header().Set("Content-Type", "application/gzip")
+		err = writeTarGz(w, records)
+	}
+	if err != nil {
+		// The response has started, so the client only sees a truncated
+		// archive.
+		log.Printf("stream archive: %v", err)
+	}
+}
+
+func parseArchiveRequest(w http.ResponseWriter, r *http.Request) (archiveRequest, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchiveRequestSize)
+	defer r.Body.Close()
+
+	var req archiveRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		return req, err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return req, err
+	}
+	form := r.PostForm
+	req.Format = form.Get("format")
+	req.Paths = form["path"]
+	if len(req.Paths) == 0 {
+		req.Search = &archiveSearch{
+			Query:    form.Get("query"),
+			Content:  form.Get("content"),
+			Category: form["category"],
+			Ext:      form["ext"],
+			Root:     form["root"],
+			Size:     form.Get("size"),
+			Modified: form.Get("modified"),
+		}
+		req.Search.Fuzzy, _ = strconv.ParseBool(form.Get("fuzzy"))
+		req.Search.MinSize, _ = strconv.ParseInt(form.Get("minSize"), 10, 64)
+		req.Search.MaxSize, _ = strconv.ParseInt(form.Get("maxSize"), 10, 64)
+	}
+	return req, nil
+}
+
+// archiveStatusError rejects a path with an HTTP status.
+type archiveStatusError struct {
+	path string
+	code int
+}
+
+func (e archiveStatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.path, strings.ToLower(http.StatusText(e.code)))
+}
+
+// archiveRecords resolves the request to downloadable records and checks
+// them against the limits before anything is written. Requested paths are
+// checked against the roots the caller may read and download from before
+// the index is consulted, and a directory is listed only up to the file
+// limit. Rejected paths are reported as the path the caller sent, with a
+// plain not found.
+func (s *Server) archiveRecords(ctx context.Context, r *http.Request, req archiveRequest) ([]indexer.FileRecord, error) {
+	allowed := intersectRoots(s.allowedRoots(r, auth.PermRead), s.allowedRoots(r, auth.PermDownload))
+
+	seen := make(map[string]struct{})
+	records := make([]indexer.FileRecord, 0)
+	var total int64
+	add := func(path, requested string) error {
+		record, status := s.downloadable(r, path)
+		if status != http.StatusOK {
+			return archiveStatusError{path: requested, code: http.StatusNotFound}
+		}
+		if _, ok := seen[record.Path]; ok {
+			return nil
+		}
+		seen[record.Path] = struct{}{}
+		total += record.Size
+		if len(records) >= s.archive.MaxFiles || total > s.archive.MaxBytes {
+			return s.archiveTooLarge()
+		}
+		records = append(records, record)
+		return nil
+	}
+
+	if len(req.Paths) > 0 {
+		roots := s.index.Roots()
+		for _, requested := range req.Paths {
+			path := filepath.Clean(requested)
+			if !isWithin(allowed, roots, path) {
+				return nil, archiveStatusError{path: requested, code: http.StatusNotFound}
+			}
+			if _, ok := s.index.Lookup(path); ok {
+				if err := add(path, requested); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			nested, err := s.index.FilesWithin(ctx, path, s.archive.MaxFiles+1)
+			if err != nil {
+				return nil, err
+			}
+			if len(nested) == 0 {
+				return nil, archiveStatusError{path: requested, code: http.StatusNotFound}
+			}
+			if len(nested) > s.archive.MaxFiles {
+				return nil, s.archiveTooLarge()
+			}
+			for _, file := range nested {
+				if err := add(file, requested); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else if req.Search != nil {
+		query := searchFilters(req.Search.values())
+		query.Roots = intersectRoots(query.Roots, allowed)
+		query.SortField = "path"
+		query.Limit = s.archive.MaxFiles + 1
+		result, err := s.index.Search(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		if result.Total > s.archive.MaxFiles {
+			return nil, s.archiveTooLarge()
+		}
+		for _, file := range result.Files {
+			if err := add(file.Path, file.Path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return records, nil
+}
+
+// intersectRoots intersects two root lists where nil stands for every root.
+func intersectRoots(a, b []string) []string {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	both := make([]string, 0, len(a))
+	for _, root := range a {
+		for _, other := range b {
+			if root == other {
+				both = append(both, root)
+				break
+			}
+		}
+	}
+	return both
+}
+
+func (s *Server) archiveTooLarge() error {
+	return fmt.Errorf("%w (at most %d files and %d bytes)", errArchiveTooLarge, s.archive.MaxFiles, s.archive.MaxBytes)
+}
+
+// archiveName places a file in the archive under the base name of its scan
+// root.
+func archiveName(record indexer.FileRecord) string {
+	rel, err := filepath.Rel(record.RootPath, record.Path)
+	if err != nil || record.RootPath == "" {
+		rel = record.Name
+	}
+	return filepath.ToSlash(filepath.Join(filepath.Base(record.RootPath), rel))
+}
+
+func writeZip(w io.Writer, records []indexer.FileRecord) error {
+	zw := zip.NewWriter(w)
+	for _, record := range records {
+		file, err := os.Open(record.Path)
+		if err != nil {
+			log.Printf("archive: skip %s: %v", record.Path, err)
+			continue
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			log.Printf("archive: skip %s: %v", record.Path, err)
+			continue
+		}
+
+		header := &zip.FileHeader{
+			Name:     archiveName(record),
+			Method:   zip.Deflate,
+			Modified: info.ModTime(),
+		}
+		header.SetMode(info.Mode())
+		entry, err := zw.CreateHeader(header)
+		if err == nil {
+			_, err = io.Copy(entry, file)
+		}
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("add %s: %w", record.Path, err)
+		}
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, records []indexer.FileRecord) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, record := range records {
+		file, err := os.Open(record.Path)
+		if err != nil {
+			log.Printf("archive: skip %s: %v", record.Path, err)
+			continue
+		}
+		info, err := file.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			file.Close()
+			log.Printf("archive: skip %s: not a regular file", record.Path)
+			continue
+		}
+
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     archiveName(record),
+			Size:     info.Size(),
+			Mode:     int64(info.Mode().Perm()),
+			ModTime:  info.ModTime(),
+		}
+		err = tw.WriteHeader(header)
+		if err == nil {
+			// Copy exactly the size announced in the header even if the file
+			// grows while it is read.
+			_, err = io.CopyN(tw, file, info.Size())
+		}
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("add %s: %w", record.Path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"seekfile/internal/auth"
+)
+
+// Authenticator verifies requests, manages login sessions and decides which
+// scan roots a principal may access. It is implemented by
+// *auth.Authenticator.
+type Authenticator interface {
+	Authenticate(r *http.Request) (auth.Principal, bool)
+	Login(name, password string) (string, time.Time, error)
+	Logout(sessionID string)
+	Allowed(principal auth.Principal, permission auth.Permission, root string) bool
+}
+
+// Option configures optional Server behaviour.
+type Option func(*Server)
+
+// WithAuthenticator requires every request except the login page and static
+// assets to be authenticated by a.
+func WithAuthenticator(a Authenticator) Option {
+	return func(s *Server) {
+		s.auth = a
+	}
+}
+
+// requireAuth rejects unauthenticated requests. API clients get 401; browsers
+// are redirected to the login page. The principal of an accepted request is
+// stored in its context.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, ok := s.auth.Authenticate(r)
+		if !ok {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="seekfile"`)
+				http.Error(w, "authentication required", http.StatusUnauthorized)
+				return
+			}
+			target := "/login?next=" + url.QueryEscape(r.URL.RequestURI())
+			http.Redirect(w, r, target, http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// allowedRoots returns the scan roots the request may access with permission,
+// or nil when it may access all of them.
+func (s *Server) allowedRoots(r *http.Request, permission auth.Permission) []string {
+	if s.auth == nil {
+		return nil
+	}
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		return []string{}
+	}
+	roots := s.index.Roots()
+	allowed := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if s.auth.Allowed(principal, permission, root) {
+			allowed = append(allowed, root)
+		}
+	}
+	if len(allowed) == len(roots) {
+		return nil
+	}
+	return allowed
+}
+
+// permits reports whether the request may access path with permission.
+func (s *Server) permits(r *http.Request, permission auth.Permission, path string) bool {
+	return isWithin(s.allowedRoots(r, permission), s.index.Roots(), path)
+}
+
+// isWithin reports whether path lies in one of the allowed roots; nil allowed
+// stands for every root.
+func isWithin(allowed, roots []string, path string) bool {
+	if allowed == nil {
+		allowed = roots
+	}
+	for _, root := range allowed {
+		if isSubPath(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPublicPath(path string) bool {
+	return path == "/login" || path == "/logout" || strings.HasPrefix(path, "/static/")
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if s.auth == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	next := safeRedirect(r.FormValue("next"))
+	switch r.Method {
+	case http.MethodGet:
+		s.renderLogin(w, next, "", http.StatusOK)
+	case http.MethodPost:
+		name := strings.TrimSpace(r.PostFormValue("username"))
+		sessionID, expires, err := s.auth.Login(name, r.PostFormValue("password"))
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				s.renderLogin(w, next, "用户名或密码错误", http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, fmt.Sprintf("login: %v", err), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     auth.SessionCookie,
+			Value:    sessionID,
+			Path:     "/",
+			Expires:  expires,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.auth != nil {
+		if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+			s.auth.Logout(cookie.Value)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (s *Server) renderLogin(w http.ResponseWriter, next, message string, status int) {
+	data := map[string]any{
+		"Year":  time.Now().Year(),
+		"Next":  next,
+		"Error": message,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := s.renderer.RenderLogin(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("render page: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// safeRedirect keeps post-login redirects on this site.
+func safeRedirect(target string) string {
+	if target == "" || !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"seekfile/internal/auth"
+	"seekfile/internal/frontend"
+	"seekfile/internal/indexer"
+	"seekfile/internal/preview"
+	"seekfile/internal/storage/sqlite"
+)
+
+// testServer serves two roots holding one file each, a.txt and b.txt, with
+// the same contents. The API tokens are named after their values: "admin"
+// may do everything, "alice" may read, download from and scan the first root
+// only, and "viewer" may only read the first root.
+type testServer struct {
+	server  *Server
+	handler http.Handler
+	index   *indexer.Indexer
+	roots   []string
+	files   []string
+	// scanID is the job that indexed both roots.
+	scanID int64
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	store, err := sqlite.Open(filepath.Join(t.TempDir(), "seekfile.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	roots := []string{t.TempDir(), t.TempDir()}
+	idx, err := indexer.New(roots, store, indexer.WithContentHashing(true), indexer.WithChangeLog(indexer.ChangeLogOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Scans queued by the tests end before the store is closed.
+	t.Cleanup(func() {
+		for _, job := range idx.ScanJobs() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			_, _ = idx.WaitScan(ctx, job.ID)
+			cancel()
+		}
+	})
+
+	roots = idx.Roots()
+	files := []string{filepath.Join(roots[0], "a.txt"), filepath.Join(roots[1], "b.txt")}
+	for _, path := range files {
+		if err := os.WriteFile(path, []byte("the same contents"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := idx.QueueScan(context.Background(), indexer.ScanRequest{Mode: indexer.ScanModeIncremental})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if job, err = idx.WaitScan(ctx, job.ID); err != nil || job.State != indexer.ScanJobFinished {
+		t.Fatalf("initial scan ended %s: %v %s", job.State, err, job.Error)
+	}
+
+	tokens := make([]auth.Token, 0, 3)
+	for _, name := range []string{"admin", "alice", "viewer"} {
+		tokens = append(tokens, auth.Token{Name: name, SHA256: auth.HashToken(name)})
+	}
+	authenticator, err := auth.New(auth.Options{
+		Tokens: tokens,
+		Roles: []auth.Role{
+			{Name: "admins", Members: []string{"admin"}, Roots: []string{auth.AllRoots}, Permissions: []auth.Permission{auth.PermRead, auth.PermDownload, auth.PermAdmin}},
+			{Name: "first", Members: []string{"alice"}, Roots: roots[:1], Permissions: []auth.Permission{auth.PermRead, auth.PermDownload, auth.PermAdmin}},
+			{Name: "viewers", Members: []string{"viewer"}, Roots: roots[:1], Permissions: []auth.Permission{auth.PermRead}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previews, err := preview.NewCache(preview.Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(idx, frontend.NewRenderer(), WithAuthenticator(authenticator), WithPreviews(previews))
+	return &testServer{server: s, handler: s.Routes(), index: idx, roots: roots, files: files, scanID: job.ID}
+}
+
+// do serves a request authenticated by token, if set. A body is sent as
+// JSON.
+func (ts *testServer) do(token, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, r)
+	return w
+}
+
+// as returns a request carrying the principal name, as requireAuth would
+// pass it on.
+func as(name string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	return r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Name: name, Kind: auth.KindToken}))
+}
+
+func TestEndpointAccess(t *testing.T) {
+	ts := newTestServer(t)
+	first, second := ts.files[0], ts.files[1]
+	query := func(path string, values url.Values) string {
+		return path + "?" + values.Encode()
+	}
+	file := func(path, name string) string {
+		return query(path, url.Values{"path": {name}})
+	}
+
+	for _, tc := range []struct {
+		name     string
+		token    string
+		method   string
+		target   string
+		body     string
+		status   int
+		contains string
+		excludes string
+	}{
+		{name: "no credentials", method: http.MethodGet, target: "/api/search", status: http.StatusUnauthorized},
+		{name: "unknown token", token: "mallory", method: http.MethodGet, target: "/api/status", status: http.StatusUnauthorized},
+		{name: "page without credentials", method: http.MethodGet, target: "/", status: http.StatusSeeOther},
+		{name: "login page", method: http.MethodGet, target: "/login", status: http.StatusOK},
+
+		{name: "search all roots", token: "admin", method: http.MethodGet, target: "/api/search", status: http.StatusOK, contains: second},
+		{name: "search readable roots", token: "alice", method: http.MethodGet, target: "/api/search", status: http.StatusOK, contains: first, excludes: second},
+		{name: "search an unreadable root", token: "alice", method: http.MethodGet, target: query("/api/search", url.Values{"root": {ts.roots[1]}}), status: http.StatusOK, excludes: second},
+
+		{name: "browse a root", token: "admin", method: http.MethodGet, target: file("/api/browse", ts.roots[1]), status: http.StatusOK, contains: second},
+		{name: "browse an unreadable root", token: "alice", method: http.MethodGet, target: file("/api/browse", ts.roots[1]), status: http.StatusNotFound},
+		{name: "list readable roots", token: "alice", method: http.MethodGet, target: "/api/browse", status: http.StatusOK, contains: ts.roots[0], excludes: ts.roots[1]},
+
+		{name: "download", token: "alice", method: http.MethodGet, target: file("/api/download", first), status: http.StatusOK, contains: "the same contents"},
+		{name: "download from an unreadable root", token: "alice", method: http.MethodGet, target: file("/api/download", second), status: http.StatusNotFound},
+		{name: "download without the download permission", token: "viewer", method: http.MethodGet, target: file("/api/download", first), status: http.StatusForbidden},
+
+		{name: "preview", token: "alice", method: http.MethodGet, target: file("/api/preview", first), status: http.StatusOK, contains: "the same contents"},
+		{name: "preview from an unreadable root", token: "alice", method: http.MethodGet, target: file("/api/preview", second), status: http.StatusNotFound},
+		{name: "preview without the download permission", token: "viewer", method: http.MethodGet, target: file("/api/preview", first), status: http.StatusForbidden},
+
+		{name: "archive", token: "alice", method: http.MethodPost, target: "/api/archive", body: fmt.Sprintf(`{"paths": [%q]}`, first), status: http.StatusOK, contains: "a.txt"},
+		{name: "archive from an unreadable root", token: "alice", method: http.MethodPost, target: "/api/archive", body: fmt.Sprintf(`{"paths": [%q]}`, second), status: http.StatusNotFound},
+		{name: "archive of an unreadable root", token: "alice", method: http.MethodPost, target: "/api/archive", body: fmt.Sprintf(`{"paths": [%q]}`, ts.roots[1]), status: http.StatusNotFound},
+		{name: "archive without the download permission", token: "viewer", method: http.MethodPost, target: "/api/archive", body: fmt.Sprintf(`{"paths": [%q]}`, first), status: http.StatusNotFound},
+		{name: "archive of a search", token: "alice", method: http.MethodPost, target: "/api/archive", body: `{"search": {}}`, status: http.StatusOK, contains: "a.txt", excludes: "b.txt"},
+
+		{name: "duplicates of all roots", token: "admin", method: http.MethodGet, target: "/api/duplicates", status: http.StatusOK, contains: second},
+		{name: "duplicates of readable roots", token: "alice", method: http.MethodGet, target: "/api/duplicates", status: http.StatusOK, excludes: second},
+
+		{name: "changes of all roots", token: "admin", method: http.MethodGet, target: "/api/changes", status: http.StatusOK, contains: second},
+		{name: "changes of readable roots", token: "alice", method: http.MethodGet, target: "/api/changes", status: http.StatusOK, contains: first, excludes: second},
+
+		{name: "status of all roots", token: "admin", method: http.MethodGet, target: "/api/status", status: http.StatusOK, contains: `"knownFiles":2`},
+		{name: "status of readable roots", token: "alice", method: http.MethodGet, target: "/api/status", status: http.StatusOK, contains: `"knownFiles":1`, excludes: ts.roots[1]},
+
+		{name: "scan job", token: "admin", method: http.MethodGet, target: fmt.Sprintf("/api/scans/%d", ts.scanID), status: http.StatusOK},
+		{name: "scan job of an unreadable root", token: "alice", method: http.MethodGet, target: fmt.Sprintf("/api/scans/%d", ts.scanID), status: http.StatusNotFound},
+		{name: "cancel a scan job of an unreadable root", token: "alice", method: http.MethodDelete, target: fmt.Sprintf("/api/scans/%d", ts.scanID), status: http.StatusNotFound},
+		{name: "scan jobs of readable roots", token: "alice", method: http.MethodGet, target: "/api/scans", status: http.StatusOK, excludes: ts.roots[1]},
+		{name: "scan history of all roots", token: "admin", method: http.MethodGet, target: "/api/scans/history", status: http.StatusOK, contains: ts.roots[1]},
+		{name: "scan history of readable roots", token: "alice", method: http.MethodGet, target: "/api/scans/history", status: http.StatusOK, excludes: ts.roots[1]},
+
+		{name: "scan every root", token: "admin", method: http.MethodPost, target: "/api/scan", body: `{"mode": "incremental"}`, status: http.StatusOK},
+		{name: "scan every root without admin on all", token: "alice", method: http.MethodPost, target: "/api/scan", body: `{"mode": "incremental"}`, status: http.StatusForbidden},
+		{name: "scan a root", token: "alice", method: http.MethodPost, target: "/api/scan", body: fmt.Sprintf(`{"mode": "incremental", "paths": [%q]}`, ts.roots[0]), status: http.StatusOK},
+		{name: "scan a root without admin", token: "alice", method: http.MethodPost, target: "/api/scan", body: fmt.Sprintf(`{"mode": "incremental", "paths": [%q]}`, ts.roots[1]), status: http.StatusForbidden},
+		{name: "scan without the admin permission", token: "viewer", method: http.MethodPost, target: "/api/scan", body: fmt.Sprintf(`{"mode": "incremental", "paths": [%q]}`, ts.roots[0]), status: http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := ts.do(tc.token, tc.method, tc.target, tc.body)
+			body := w.Body.String()
+			if w.Code != tc.status {
+				t.Fatalf("%s %s = %d %s, want %d", tc.method, tc.target, w.Code, body, tc.status)
+			}
+			if tc.contains != "" && !strings.Contains(body, tc.contains) {
+				t.Errorf("%s %s does not mention %s: %s", tc.method, tc.target, tc.contains, body)
+			}
+			if tc.excludes != "" && strings.Contains(body, tc.excludes) {
+				t.Errorf("%s %s mentions %s: %s", tc.method, tc.target, tc.excludes, body)
+			}
+		})
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	roots := []string{"/data/docs", "/data/media"}
+	for _, tc := range []struct {
+		allowed []string
+		path    string
+		want    bool
+	}{
+		{nil, "/data/docs/a.txt", true},
+		{nil, "/data/media", true},
+		{nil, "/data/other/a.txt", false},
+		{roots[:1], "/data/docs/sub/a.txt", true},
+		{roots[:1], "/data/media/a.txt", false},
+		{roots[:1], "/data/docs2/a.txt", false},
+		{roots[:1], "/data/docs/../media/a.txt", false},
+		{[]string{}, "/data/docs/a.txt", false},
+	} {
+		if got := isWithin(tc.allowed, roots, tc.path); got != tc.want {
+			t.Errorf("isWithin(%v, %s) = %t, want %t", tc.allowed, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestAllowedRoots(t *testing.T) {
+	ts := newTestServer(t)
+	for _, tc := range []struct {
+		request    *http.Request
+		permission auth.Permission
+		want       []string
+	}{
+		{as("admin"), auth.PermAdmin, nil},
+		{as("alice"), auth.PermRead, ts.roots[:1]},
+		{as("viewer"), auth.PermDownload, []string{}},
+		{as("mallory"), auth.PermRead, []string{}},
+		{httptest.NewRequest(http.MethodGet, "/", nil), auth.PermRead, []string{}},
+	} {
+		got := ts.server.allowedRoots(tc.request, tc.permission)
+		if (got == nil) != (tc.want == nil) || strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			principal, _ := auth.FromContext(tc.request.Context())
+			t.Errorf("allowedRoots(%s, %s) = %#v, want %#v", principal.Name, tc.permission, got, tc.want)
+		}
+	}
+}
+
+func TestVisibleJob(t *testing.T) {
+	ts := newTestServer(t)
+	job := indexer.ScanJob{
+		ID:          7,
+		Mode:        string(indexer.ScanModeFull),
+		State:       indexer.ScanJobRunning,
+		Targets:     []indexer.ScanTarget{{Root: ts.roots[0]}, {Root: ts.roots[1]}},
+		CurrentPath: ts.files[1],
+		Processed:   2,
+		Error:       "read " + ts.files[1],
+		WalkErrors:  &indexer.WalkErrors{Count: 1},
+	}
+
+	if got := ts.server.visibleJob(as("admin"), job); len(got.Targets) != 2 || got.CurrentPath != job.CurrentPath || got.WalkErrors == nil {
+		t.Errorf("job seen by admin = %+v, want it unchanged", got)
+	}
+
+	// A job covering a scan the caller requested keeps its ID, but only the
+	// readable targets.
+	got := ts.server.visibleJob(as("alice"), job)
+	if got.ID != job.ID || got.State != job.State || len(got.Targets) != 1 || got.Targets[0].Root != ts.roots[0] {
+		t.Errorf("job seen by alice = %+v, want job %d of %s only", got, job.ID, ts.roots[0])
+	}
+	if got.CurrentPath != "" || got.Processed != 0 || got.Error != "" || got.WalkErrors != nil {
+		t.Errorf("job seen by alice = %+v, want its progress and errors cleared", got)
+	}
+	if len(job.Targets) != 2 {
+		t.Error("visibleJob changed the job it was given")
+	}
+}
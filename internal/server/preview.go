@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"seekfile/internal/preview"
+)
+
+// WithPreviews serves thumbnails and text previews from cache on
+// /api/preview.
+func WithPreviews(cache *preview.Cache) Option {
+	return func(s *Server) {
+		s.previews = cache
+	}
+}
+
+// handlePreview returns a JPEG thumbnail for images or the first lines of a
+// text file as JSON. The kind parameter ("thumbnail" or "text") overrides the
+// choice made from the file extension.
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.previews == nil {
+		http.Error(w, "previews are disabled", http.StatusNotFound)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "missing path parameter", http.StatusBadRequest)
+		return
+	}
+	// A preview shows file contents, so it needs the same rights as a
+	// download.
+	record, status := s.downloadable(r, path)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = "text"
+		if preview.IsImage(record.Name) {
+			kind = "thumbnail"
+		}
+	}
+
+	switch kind {
+	case "thumbnail":
+		data, err := s.previews.Thumbnail(record.Path, record.Size, record.ModTime)
+		if err != nil {
+			writePreviewError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		http.ServeContent(w, r, "", record.ModTime, bytes.NewReader(data))
+	case "text":
+		lines, truncated, err := s.previews.Text(record.Path)
+		if err != nil {
+			writePreviewError(w, err)
+			return
+		}
+		writeJSON(w, map[string]any{
+			"path":      record.Path,
+			"lines":     lines,
+			"truncated": truncated,
+		})
+	default:
+		http.Error(w, fmt.Sprintf("unknown preview kind %q", kind), http.StatusBadRequest)
+	}
+}
+
+func writePreviewError(w http.ResponseWriter, err error) {
+	if errors.Is(err, preview.ErrUnsupported) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	http.Error(w, fmt.Sprintf("preview: %v", err), http.StatusInternalServerError)
+}
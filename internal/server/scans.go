@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"seekfile/internal/auth"
+	"seekfile/internal/indexer"
+)
+
+// handleScans lists the queued, running and recently ended scan jobs whose
+// roots the caller may read.
+func (s *Server) handleScans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobs := s.index.ScanJobs()
+	visible := make([]indexer.ScanJob, 0, len(jobs))
+	for _, job := range jobs {
+		if s.permitsJob(r, auth.PermRead, job) {
+			visible = append(visible, job)
+		}
+	}
+	writeJSON(w, map[string]any{"jobs": visible})
+}
+
+// handleScanJob returns the scan job named by the last path segment on GET
+// and cancels it on DELETE.
+func (s *Server) handleScanJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/scans/"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid scan job id", http.StatusBadRequest)
+		return
+	}
+	job, ok := s.index.LookupScan(id)
+	if !ok || !s.permitsJob(r, auth.PermRead, job) {
+		http.Error(w, indexer.ErrScanNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, map[string]any{"job": job})
+		return
+	}
+
+	if !s.permitsJob(r, auth.PermAdmin, job) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	job, err = s.index.CancelScan(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, indexer.ErrScanEnded):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, indexer.ErrScanNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, fmt.Sprintf("cancel scan: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, map[string]any{"job": job})
+}
+
+// handleScanHistory returns a page of the persisted scan runs, most recent
+// first, leaving out runs of roots the caller may not read.
+func (s *Server) handleScanHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queryValues := r.URL.Query()
+	historyQuery := indexer.ScanRunQuery{
+		Cursor: strings.TrimSpace(queryValues.Get("cursor")),
+		Roots:  s.allowedRoots(r, auth.PermRead),
+		Limit:  min(parsePositiveInt(queryValues.Get("limit"), defaultScanRunPageSize), maxScanRunPageSize),
+	}
+	if root := strings.TrimSpace(queryValues.Get("root")); root != "" {
+		historyQuery.Root = filepath.Clean(root)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	page, err := s.index.ScanHistory(ctx, historyQuery)
+	if err != nil {
+		if errors.Is(err, indexer.ErrScanHistoryUnavailable) || errors.Is(err, indexer.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("scan history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"runs":       page.Runs,
+		"nextCursor": page.NextCursor,
+	})
+}
+
+// visibleJob redacts a job that also scans roots the caller may not read,
+// such as a queued job covering a scan the caller requested: only the targets
+// in readable roots are kept, and the progress, error and walk errors, which
+// may describe the other roots, are cleared.
+func (s *Server) visibleJob(r *http.Request, job indexer.ScanJob) indexer.ScanJob {
+	if s.permitsJob(r, auth.PermRead, job) {
+		return job
+	}
+	readable := s.allowedRoots(r, auth.PermRead)
+	job.Targets = filterEntries(job.Targets, func(target indexer.ScanTarget) bool {
+		return isWithin(readable, s.index.Roots(), target.Root)
+	})
+	if job.CurrentPath != "" && !isWithin(readable, s.index.Roots(), job.CurrentPath) {
+		job.CurrentPath = ""
+	}
+	job.Processed, job.Error, job.WalkErrors = 0, "", nil
+	return job
+}
+
+// permitsJob reports whether the caller holds permission on every root the
+// job scans.
+func (s *Server) permitsJob(r *http.Request, permission auth.Permission, job indexer.ScanJob) bool {
+	allowed := s.allowedRoots(r, permission)
+	if allowed == nil {
+		return true
+	}
+	for _, target := range job.Targets {
+		if !isWithin(allowed, s.index.Roots(), target.Root) {
+			return false
+		}
+	}
+	return true
+}
@@ -7,19 +7,34 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"seekfile/internal/auth"
 	"seekfile/internal/frontend"
 	"seekfile/internal/indexer"
+	"seekfile/internal/preview"
+	"seekfile/internal/querylang"
 )
 
 const (
 	defaultPageSize = 20
 	maxPageSize     = 200
+
+	defaultChangePageSize = 100
+	maxChangePageSize     = 1000
+
+	defaultScanRunPageSize = 50
+	maxScanRunPageSize     = 500
+
+	// streamKeepAlive is how often an idle event stream sends a comment so
+	// that proxies do not close the connection.
+	streamKeepAlive = 15 * time.Second
 )
 
 var categoryExtensions = map[string][]string{
@@ -34,11 +49,24 @@ type Server struct {
 	index    *indexer.Indexer
 	renderer *frontend.Renderer
 	baseCtx  context.Context
+	auth     Authenticator
+	tls      *TLSOptions
+	archive  ArchiveLimits
+	previews *preview.Cache
 }
 
 // New creates a Server instance backed by the provided indexer and renderer.
-func New(idx *indexer.Indexer, renderer *frontend.Renderer) *Server {
-	return &Server{index: idx, renderer: renderer, baseCtx: context.Background()}
+func New(idx *indexer.Indexer, renderer *frontend.Renderer, opts ...Option) *Server {
+	s := &Server{
+		index:    idx,
+		renderer: renderer,
+		baseCtx:  context.Background(),
+		archive:  ArchiveLimits{MaxFiles: DefaultArchiveMaxFiles, MaxBytes: DefaultArchiveMaxBytes},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Routes returns the HTTP handler that exposes the application endpoints.
@@ -46,11 +74,25 @@ func (s *Server) Routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleIndex)
 	mux.HandleFunc("/api/search", s.handleSearch)
+	mux.HandleFunc("/api/browse", s.handleBrowse)
 	mux.HandleFunc("/api/download", s.handleDownload)
+	mux.HandleFunc("/api/archive", s.handleArchive)
+	mux.HandleFunc("/api/preview", s.handlePreview)
 	mux.HandleFunc("/api/status", s.handleStatus)
+	mux.HandleFunc("/api/status/stream", s.handleStatusStream)
 	mux.HandleFunc("/api/scan", s.handleScan)
+	mux.HandleFunc("/api/scans", s.handleScans)
+	mux.HandleFunc("/api/scans/", s.handleScanJob)
+	mux.HandleFunc("/api/scans/history", s.handleScanHistory)
+	mux.HandleFunc("/api/duplicates", s.handleDuplicates)
+	mux.HandleFunc("/api/changes", s.handleChanges)
+	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc("/logout", s.handleLogout)
 	mux.Handle("/static/", http.StripPrefix("/static/", s.renderer.StaticHandler()))
-	return mux
+	if s.auth == nil {
+		return mux
+	}
+	return s.requireAuth(mux)
 }
 
 // Start runs the HTTP server until the provided context is cancelled.
@@ -65,9 +107,22 @@ func (s *Server) Start(ctx context.Context, addr string) error {
 		Handler: s.Routes(),
 	}
 
+	serve := srv.ListenAndServe
+	if s.tls != nil {
+		tlsConfig, reloader, err := s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = tlsConfig
+		go reloader.Watch(ctx, certReloadInterval)
+		serve = func() error {
+			return srv.ListenAndServeTLS("", "")
+		}
+	}
+
 	errCh := make(chan error, 1)
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errCh <- err
 		} else {
 			errCh <- nil
@@ -91,7 +146,13 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	data := map[string]any{
-		"Year": time.Now().Year(),
+		"Year":        time.Now().Year(),
+		"AuthEnabled": s.auth != nil,
+		"CanScan":     s.allowedRoots(r, auth.PermAdmin) == nil,
+		"Previews":    s.previews != nil,
+	}
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		data["User"] = principal.Name
 	}
 	if err := s.renderer.RenderIndex(w, data); err != nil {
 		http.Error(w, fmt.Sprintf("render page: %v", err), http.StatusInternalServerError)
@@ -105,28 +166,15 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	queryValues := r.URL.Query()
-	idxQuery := indexer.Query{
-		NamePattern: strings.TrimSpace(queryValues.Get("query")),
-	}
-	if minSizeStr := queryValues.Get("minSize"); minSizeStr != "" {
-		if minSize, err := strconv.ParseInt(minSizeStr, 10, 64); err == nil {
-			idxQuery.MinSize = minSize
-		}
-	}
-	if maxSizeStr := queryValues.Get("maxSize"); maxSizeStr != "" {
-		if maxSize, err := strconv.ParseInt(maxSizeStr, 10, 64); err == nil {
-			idxQuery.MaxSize = maxSize
-		}
-	}
-
-	categories := queryValues["category"]
-	if exts := resolveCategoryExtensions(categories); len(exts) > 0 {
-		idxQuery.Extensions = exts
-	}
+	idxQuery := searchFilters(queryValues)
+	idxQuery.Roots = intersectRoots(idxQuery.Roots, s.allowedRoots(r, auth.PermRead))
+	idxQuery.Facets, _ = strconv.ParseBool(queryValues.Get("facets"))
 
 	sortField := strings.TrimSpace(queryValues.Get("sort"))
 	if sortField != "" {
 		idxQuery.SortField = sortField
+	} else if idxQuery.Content != "" || idxQuery.Fuzzy {
+		idxQuery.SortField = "relevance"
 	}
 	idxQuery.SortDescending = strings.EqualFold(queryValues.Get("order"), "desc")
 
@@ -138,21 +186,30 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	offset := (page - 1) * pageSize
 	idxQuery.Offset = offset
 	idxQuery.Limit = pageSize
+	idxQuery.Cursor = strings.TrimSpace(queryValues.Get("cursor"))
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	result := s.index.Search(ctx, idxQuery)
+	result, err := s.index.Search(ctx, idxQuery)
+	if err != nil {
+		writeSearchError(w, err)
+		return
+	}
 
 	totalPages := 0
 	if pageSize > 0 && result.Total > 0 {
 		totalPages = (result.Total + pageSize - 1) / pageSize
 	}
 
-	if totalPages > 0 && page > totalPages {
+	if idxQuery.Cursor == "" && totalPages > 0 && page > totalPages {
 		page = totalPages
 		idxQuery.Offset = (page - 1) * pageSize
-		result = s.index.Search(ctx, idxQuery)
+		result, err = s.index.Search(ctx, idxQuery)
+		if err != nil {
+			writeSearchError(w, err)
+			return
+		}
 	}
 
 	sortFieldResponse := idxQuery.SortField
@@ -168,11 +225,99 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		"totalPages": totalPages,
 		"sort":       sortFieldResponse,
 		"order":      ternary(idxQuery.SortDescending, "desc", "asc"),
+		"nextCursor": result.NextCursor,
+		"truncated":  result.Truncated,
+	}
+	if result.Facets != nil {
+		response["facets"] = searchFacets(result.Facets)
 	}
 
 	writeJSON(w, response)
 }
 
+func (s *Server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queryValues := r.URL.Query()
+	browseQuery := indexer.BrowseQuery{
+		Path:           strings.TrimSpace(queryValues.Get("path")),
+		SortField:      strings.TrimSpace(queryValues.Get("sort")),
+		SortDescending: strings.EqualFold(queryValues.Get("order"), "desc"),
+		Roots:          s.allowedRoots(r, auth.PermRead),
+	}
+
+	page := parsePositiveInt(queryValues.Get("page"), 1)
+	pageSize := clampPageSize(parsePositiveInt(queryValues.Get("pageSize"), defaultPageSize))
+	browseQuery.Offset = (page - 1) * pageSize
+	browseQuery.Limit = pageSize
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.index.Browse(ctx, browseQuery)
+	if errors.Is(err, indexer.ErrDirectoryNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("browse: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	totalPages := 0
+	if result.Total > 0 {
+		totalPages = (result.Total + pageSize - 1) / pageSize
+	}
+	if totalPages > 0 && page > totalPages {
+		page = totalPages
+		browseQuery.Offset = (page - 1) * pageSize
+		result, err = s.index.Browse(ctx, browseQuery)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("browse: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := map[string]any{
+		"path":        result.Directory.Path,
+		"breadcrumbs": breadcrumbs(result.Directory),
+		"directories": result.Directories,
+		"files":       result.Files,
+		"total":       result.Total,
+		"page":        page,
+		"pageSize":    pageSize,
+		"totalPages":  totalPages,
+		"sort":        ternary(browseQuery.SortField == "", "name", browseQuery.SortField),
+		"order":       ternary(browseQuery.SortDescending, "desc", "asc"),
+	}
+	if result.Directory.Path != "" {
+		response["directory"] = result.Directory
+	}
+	writeJSON(w, response)
+}
+
+// breadcrumbs lists the directories from the scan root down to dir.
+func breadcrumbs(dir indexer.DirectoryStats) []map[string]string {
+	crumbs := []map[string]string{}
+	if dir.Path == "" {
+		return crumbs
+	}
+	path := dir.RootPath
+	crumbs = append(crumbs, map[string]string{"name": filepath.Base(path), "path": path})
+	rel, err := filepath.Rel(dir.RootPath, dir.Path)
+	if err != nil || rel == "." {
+		return crumbs
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		crumbs = append(crumbs, map[string]string{"name": part, "path": path})
+	}
+	return crumbs
+}
+
 func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -185,14 +330,9 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	record, ok := s.index.Lookup(path)
-	if !ok {
-		http.NotFound(w, r)
-		return
-	}
-
-	if !s.isWithinRoots(record.Path) {
-		http.Error(w, "invalid path", http.StatusBadRequest)
+	record, status := s.downloadable(r, path)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
 		return
 	}
 
@@ -200,6 +340,22 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, record.Path)
 }
 
+// downloadable looks up an indexed file and checks that the request may
+// download it. It returns http.StatusOK or the status to reject it with.
+func (s *Server) downloadable(r *http.Request, path string) (indexer.FileRecord, int) {
+	record, ok := s.index.Lookup(path)
+	if !ok || !s.permits(r, auth.PermRead, record.Path) {
+		return indexer.FileRecord{}, http.StatusNotFound
+	}
+	if !s.isWithinRoots(record.Path) {
+		return indexer.FileRecord{}, http.StatusBadRequest
+	}
+	if !s.permits(r, auth.PermDownload, record.Path) {
+		return indexer.FileRecord{}, http.StatusForbidden
+	}
+	return record, http.StatusOK
+}
+
 func (s *Server) isWithinRoots(path string) bool {
 	for _, root := range s.index.Roots() {
 		if isSubPath(root, path) {
@@ -215,10 +371,131 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status := s.index.Status()
+	status := s.visibleStatus(r, s.index.Status())
 	writeJSON(w, status)
 }
 
+// visibleStatus limits status to the roots the caller may read: the entries
+// of other roots are left out of the per-root, watch and schedule lists, jobs
+// the caller could not see through /api/scans are dropped, and the fields
+// describing the most recent job, including its walk errors, are cleared
+// when it is one of them. Visible jobs only scan readable roots, so the walk
+// errors they carry name readable paths only. The file count and the last
+// successful run are recomputed from the readable roots alone. The lists are
+// copied, since a status event is shared by every subscriber.
+func (s *Server) visibleStatus(r *http.Request, status indexer.ScanStatus) indexer.ScanStatus {
+	readable := s.allowedRoots(r, auth.PermRead)
+	if readable == nil {
+		return status
+	}
+	roots := s.index.Roots()
+	visible := func(path string) bool {
+		return isWithin(readable, roots, path)
+	}
+
+	status.Roots = filterEntries(status.Roots, func(entry indexer.RootScanStatus) bool { return visible(entry.Root) })
+	status.Watch = filterEntries(status.Watch, func(entry indexer.WatchStatus) bool { return visible(entry.Root) })
+	status.Schedules = filterEntries(status.Schedules, func(entry indexer.ScheduleStatus) bool { return visible(entry.Root) })
+
+	// The totals only count the readable roots.
+	status.KnownFiles = 0
+	if count, err := s.index.CountFiles(r.Context(), readable); err == nil {
+		status.KnownFiles = count
+	}
+	status.LastSuccessfulRun = time.Time{}
+	for _, entry := range status.Roots {
+		if entry.LastSuccessfulRun.After(status.LastSuccessfulRun) {
+			status.LastSuccessfulRun = entry.LastSuccessfulRun
+		}
+	}
+	if status.CurrentPath != "" && !visible(status.CurrentPath) {
+		status.CurrentPath = ""
+	}
+
+	status.Jobs = filterEntries(status.Jobs, func(job indexer.ScanJob) bool { return s.permitsJob(r, auth.PermRead, job) })
+	status.Running = slices.ContainsFunc(status.Jobs, func(job indexer.ScanJob) bool { return job.State == indexer.ScanJobRunning })
+	if job, ok := s.index.LookupScan(status.ID); ok && !s.permitsJob(r, auth.PermRead, job) {
+		status.ID, status.Mode, status.Phase, status.Error = 0, "", "", ""
+		status.CurrentPath, status.Processed = "", 0
+		status.StartedAt, status.FinishedAt = time.Time{}, time.Time{}
+		status.WalkErrors = nil
+	}
+	return status
+}
+
+// filterEntries returns a new slice holding the entries for which keep
+// reports true.
+func filterEntries[T any](entries []T, keep func(T) bool) []T {
+	if entries == nil {
+		return nil
+	}
+	filtered := make([]T, 0, len(entries))
+	for _, entry := range entries {
+		if keep(entry) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// handleStatusStream sends the scan status as Server-Sent Events: a "status"
+// event with the current snapshot on connect, followed by the indexer's
+// throttled progress and scan lifecycle events.
+func (s *Server) handleStatusStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := s.index.SubscribeStatus()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	initial := indexer.StatusEvent{Type: "status", Status: s.visibleStatus(r, s.index.Status())}
+	if err := writeEvent(w, string(initial.Type), initial); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.baseCtx.Done():
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.Job != nil && !s.permitsJob(r, auth.PermRead, *event.Job) {
+				continue
+			}
+			event.Status = s.visibleStatus(r, event.Status)
+			if err := writeEvent(w, string(event.Type), event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
 func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -227,6 +504,8 @@ func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
 
 	var payload struct {
 		Mode string `json:"mode"`
+		// Paths limits the scan to these roots or subtrees.
+		Paths []string `json:"paths"`
 	}
 
 	if r.Body != nil {
@@ -243,16 +522,250 @@ func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.index.StartScan(s.baseCtx, mode); err != nil {
-		if errors.Is(err, indexer.ErrScanInProgress) {
-			http.Error(w, "scan already in progress", http.StatusConflict)
+	if len(payload.Paths) == 0 {
+		// A scan covers every root, so it needs admin rights on all of them.
+		if s.allowedRoots(r, auth.PermAdmin) != nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	} else {
+		for _, path := range payload.Paths {
+			if !filepath.IsAbs(path) {
+				http.Error(w, fmt.Sprintf("scan path %q must be absolute", path), http.StatusBadRequest)
+				return
+			}
+			// Paths outside every root are rejected by the indexer below.
+			clean := filepath.Clean(path)
+			if isWithin(nil, s.index.Roots(), clean) && !s.permits(r, auth.PermAdmin, clean) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+	}
+	job, err := s.index.QueueScan(s.baseCtx, indexer.ScanRequest{Mode: mode, Paths: payload.Paths})
+	if err != nil {
+		if errors.Is(err, indexer.ErrOutsideRoots) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		http.Error(w, fmt.Sprintf("start scan: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	writeJSON(w, map[string]any{"status": s.index.Status()})
+	writeJSON(w, map[string]any{"status": s.visibleStatus(r, s.index.Status()), "job": s.visibleJob(r, job)})
+}
+
+func (s *Server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queryValues := r.URL.Query()
+	var minSize int64
+	if minSizeStr := queryValues.Get("minSize"); minSizeStr != "" {
+		if parsed, err := strconv.ParseInt(minSizeStr, 10, 64); err == nil {
+			minSize = parsed
+		}
+	}
+
+	page := parsePositiveInt(queryValues.Get("page"), 1)
+	pageSize := clampPageSize(parsePositiveInt(queryValues.Get("pageSize"), defaultPageSize))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	dupQuery := indexer.DuplicateQuery{
+		MinSize: minSize,
+		Roots:   s.allowedRoots(r, auth.PermRead),
+		Offset:  (page - 1) * pageSize,
+		Limit:   pageSize,
+	}
+	result, err := s.index.Duplicates(ctx, dupQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	totalPages := 0
+	if result.TotalGroups > 0 {
+		totalPages = (result.TotalGroups + pageSize - 1) / pageSize
+	}
+	if totalPages > 0 && page > totalPages {
+		page = totalPages
+		dupQuery.Offset = (page - 1) * pageSize
+		result, err = s.index.Duplicates(ctx, dupQuery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSON(w, map[string]any{
+		"enabled":     s.index.HashingEnabled(),
+		"groups":      result.Groups,
+		"totalGroups": result.TotalGroups,
+		"totalFiles":  result.TotalFiles,
+		"wastedBytes": result.WastedBytes,
+		"page":        page,
+		"pageSize":    pageSize,
+		"totalPages":  totalPages,
+	})
+}
+
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queryValues := r.URL.Query()
+	changeQuery := indexer.ChangeQuery{
+		Cursor: strings.TrimSpace(queryValues.Get("cursor")),
+		Roots:  s.allowedRoots(r, auth.PermRead),
+		Limit:  min(parsePositiveInt(queryValues.Get("limit"), defaultChangePageSize), maxChangePageSize),
+	}
+	if since := strings.TrimSpace(queryValues.Get("since")); since != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		changeQuery.Since = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	page, err := s.index.Changes(ctx, changeQuery)
+	if err != nil {
+		if errors.Is(err, indexer.ErrChangeLogDisabled) || errors.Is(err, indexer.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("changes: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if changeQuery.Roots != nil {
+		page.Changes = hideOldPaths(page.Changes, changeQuery.Roots, s.index.Roots())
+	}
+
+	writeJSON(w, map[string]any{
+		"changes":    page.Changes,
+		"nextCursor": page.NextCursor,
+		"hasMore":    page.HasMore,
+	})
+}
+
+// hideOldPaths hides the old location of files moved in from outside the
+// readable roots. The store already left out changes to other roots.
+func hideOldPaths(changes []indexer.Change, readable, roots []string) []indexer.Change {
+	for i, change := range changes {
+		if change.OldPath != "" && !isWithin(readable, roots, change.OldPath) {
+			changes[i].OldPath = ""
+		}
+	}
+	return changes
+}
+
+// searchFilters reads the filter parameters shared by searches and archives.
+func searchFilters(values url.Values) indexer.Query {
+	query := indexer.Query{
+		NamePattern: strings.TrimSpace(values.Get("query")),
+		Content:     strings.TrimSpace(values.Get("content")),
+	}
+	query.Fuzzy, _ = strconv.ParseBool(values.Get("fuzzy"))
+	if minSizeStr := values.Get("minSize"); minSizeStr != "" {
+		if minSize, err := strconv.ParseInt(minSizeStr, 10, 64); err == nil {
+			query.MinSize = minSize
+		}
+	}
+	if maxSizeStr := values.Get("maxSize"); maxSizeStr != "" {
+		if maxSize, err := strconv.ParseInt(maxSizeStr, 10, 64); err == nil {
+			query.MaxSize = maxSize
+		}
+	}
+	if exts := resolveCategoryExtensions(values["category"]); len(exts) > 0 {
+		query.Extensions = exts
+	}
+
+	// The facet filters narrow the search further.
+	if exts := values["ext"]; len(exts) > 0 {
+		query.Extensions = intersectExtensions(query.Extensions, exts)
+		if len(query.Extensions) == 0 {
+			// No extension satisfies both the category and the ext filter.
+			query.Roots = []string{}
+		}
+	}
+	if roots := values["root"]; len(roots) > 0 {
+		query.Roots = intersectRoots(query.Roots, roots)
+	}
+	if size := values.Get("size"); size != "" {
+		query.ApplySizeBucket(size)
+	}
+	if modified := values.Get("modified"); modified != "" {
+		query.ApplyModifiedBucket(modified, time.Now())
+	}
+	return query
+}
+
+// intersectExtensions keeps the extensions of exts that are in allowed, where
+// nil allows every extension.
+func intersectExtensions(allowed, exts []string) []string {
+	both := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if allowed == nil || slices.Contains(allowed, ext) {
+			both = append(both, ext)
+		}
+	}
+	return both
+}
+
+// maxExtensionFacets bounds the extension facet of a search response.
+const maxExtensionFacets = 20
+
+// searchFacets shapes the facets of a search for the response, adding the
+// categories that the extensions add up to.
+func searchFacets(facets *indexer.Facets) map[string]any {
+	categories := make([]indexer.FacetValue, 0, len(categoryExtensions))
+	for category, extensions := range categoryExtensions {
+		value := indexer.FacetValue{Value: category}
+		for _, facet := range facets.Extensions {
+			if slices.Contains(extensions, facet.Value) {
+				value.Count += facet.Count
+				value.Bytes += facet.Bytes
+			}
+		}
+		if value.Count > 0 {
+			categories = append(categories, value)
+		}
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Count != categories[j].Count {
+			return categories[i].Count > categories[j].Count
+		}
+		return categories[i].Value < categories[j].Value
+	})
+
+	extensions := facets.Extensions
+	if len(extensions) > maxExtensionFacets {
+		extensions = extensions[:maxExtensionFacets]
+	}
+	return map[string]any{
+		"extensions": extensions,
+		"categories": categories,
+		"roots":      facets.Roots,
+		"sizes":      facets.Sizes,
+		"modified":   facets.Modified,
+	}
 }
 
 func isSubPath(root, target string) bool {
@@ -263,6 +776,31 @@ func isSubPath(root, target string) bool {
 	return !strings.HasPrefix(rel, "..") && rel != ".."
 }
 
+func writeSearchError(w http.ResponseWriter, err error) {
+	if isQueryError(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, fmt.Sprintf("search: %v", err), http.StatusInternalServerError)
+}
+
+// writeEvent writes a single Server-Sent Event with a JSON payload.
+func writeEvent(w io.Writer, event string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// isQueryError reports whether a search failed because of the request rather
+// than the server.
+func isQueryError(err error) bool {
+	var syntaxErr *querylang.SyntaxError
+	return errors.Is(err, indexer.ErrContentSearchDisabled) || errors.Is(err, indexer.ErrInvalidCursor) || errors.As(err, &syntaxErr)
+}
+
 func writeJSON(w http.ResponseWriter, payload any) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	if err := json.NewEncoder(w).Encode(payload); err != nil {
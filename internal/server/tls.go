@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"seekfile/internal/certs"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// changes.
+const certReloadInterval = 30 * time.Second
+
+// TLSOptions configures HTTPS.
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables client certificate verification against the CA
+	// certificates it contains.
+	ClientCAFile string
+	// RequireClientCert rejects connections without a valid client
+	// certificate; otherwise one is only verified when presented.
+	RequireClientCert bool
+}
+
+// WithTLS serves HTTPS, and HTTP/2 where clients support it, instead of plain
+// HTTP.
+func WithTLS(opts TLSOptions) Option {
+	return func(s *Server) {
+		s.tls = &opts
+	}
+}
+
+// tlsConfig loads the certificates configured by WithTLS. The returned
+// reloader keeps the server certificate current.
+func (s *Server) tlsConfig() (*tls.Config, *certs.Reloader, error) {
+	reloader, err := certs.NewReloader(s.tls.CertFile, s.tls.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load tls certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if s.tls.ClientCAFile != "" {
+		pool, err := certs.LoadPool(s.tls.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load client ca: %w", err)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if s.tls.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return cfg, reloader, nil
+}
@@ -1,19 +1,227 @@
 package storage
 
-import "time"
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"seekfile/internal/querylang"
+)
 
 // Record represents a persisted file entry.
 type Record struct {
-	Path     string
-	Name     string
-	Size     int64
-	ModTime  time.Time
-	RootPath string
+	Path        string
+	Name        string
+	Size        int64
+	ModTime     time.Time
+	RootPath    string
+	PartialHash string
+	ContentHash string
+	// ContentIndexed records that text extraction has run for the current
+	// size and modification time.
+	ContentIndexed bool
+	// Device and Inode identify the file on disk independently of its path.
+	// Both are zero when the platform does not expose them.
+	Device uint64
+	Inode  uint64
+}
+
+// ContentHit is a single full-text match returned by the store.
+type ContentHit struct {
+	Path string
+	// Snippet is an excerpt of the matched text in which highlighted terms are
+	// wrapped in SnippetStart and SnippetEnd.
+	Snippet string
+	// Rank orders hits; lower values are better matches.
+	Rank float64
 }
 
+// Markers used to delimit highlighted terms in ContentHit snippets.
+const (
+	SnippetStart = "\x02"
+	SnippetEnd   = "\x03"
+)
+
 // ScanState captures bookkeeping for the last scan times of a root path.
 type ScanState struct {
 	RootPath            string
 	LastFullScan        time.Time
 	LastIncrementalScan time.Time
 }
+
+// Change is an entry of the persistent change log.
+type Change struct {
+	// ID increases with every logged change and serves as the feed cursor.
+	ID   int64
+	Time time.Time
+	// Kind is one of created, modified, moved or deleted.
+	Kind    string
+	Path    string
+	OldPath string
+	// Cause is one of scan, watch or api.
+	Cause string
+	// ScanID identifies the scan that made the change; it is zero for other
+	// causes.
+	ScanID int64
+}
+
+// ChangeQuery selects a page of the change log in ID order.
+type ChangeQuery struct {
+	// AfterID skips changes with an ID less than or equal to it.
+	AfterID int64
+	// Since skips changes logged before it.
+	Since time.Time
+	// Roots, when not nil, keeps only changes to paths within these roots.
+	Roots []string
+	Limit int
+}
+
+// ScanRun is the persisted summary of a scan job that ran.
+type ScanRun struct {
+	// ID is the ID of the scan job.
+	ID   int64
+	Mode string
+	// State is one of finished, failed or canceled.
+	State string
+	Roots []string
+	// Paths lists the subtrees scanned when the job did not cover whole
+	// roots.
+	Paths      []string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Duration   time.Duration
+	Processed  int64
+	Added      int64
+	Modified   int64
+	Moved      int64
+	Deleted    int64
+	// Bytes is the total size of the files the scan processed.
+	Bytes  int64
+	Errors []string
+	// WalkErrorCount is the number of entries the scan could not read and
+	// WalkErrorKinds their number by kind; WalkErrors holds the first ones.
+	WalkErrorCount int64
+	WalkErrorKinds map[string]int64
+	WalkErrors     []WalkError
+}
+
+// WalkError is a directory or file that a scan could not read.
+type WalkError struct {
+	Path  string
+	Kind  string
+	Error string
+}
+
+// ScanRunQuery selects a page of scan runs, most recent first.
+type ScanRunQuery struct {
+	// BeforeID skips runs with an ID greater than or equal to it; zero
+	// starts at the most recent run.
+	BeforeID int64
+	// Root, when set, keeps only runs that scanned this root.
+	Root string
+	// Roots, when not nil, keeps only runs that scanned no root outside it.
+	Roots []string
+	Limit int
+}
+
+// Query describes a record search that is evaluated by the store.
+type Query struct {
+	// Expr is the parsed search box query; nil matches every record.
+	Expr querylang.Expr
+	// Extensions lists lower-case extensions including the leading dot.
+	Extensions []string
+	Roots      []string
+	Paths      []string
+	// NameWords restricts results to the candidates of a fuzzy name search
+	// for these words, whose names share trigrams with each of them.
+	NameWords []string
+	// Dir restricts results to files directly inside this directory.
+	Dir            string
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// SortField is one of name, size, modified or path.
+	SortField      string
+	SortDescending bool
+	// After continues a previous page using keyset pagination; Offset is
+	// ignored when it is set.
+	After  *Cursor
+	Offset int
+	Limit  int
+}
+
+// Cursor identifies the last record of a page for keyset pagination.
+type Cursor struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Path    string
+}
+
+// SearchPage is a page of search results.
+type SearchPage struct {
+	Records []Record
+	Total   int
+}
+
+// FacetValue counts the records sharing a value.
+type FacetValue struct {
+	Value string
+	Count int
+	Bytes int64
+}
+
+// Facets aggregates the records matching a query. Sizes and Modified hold
+// one entry per bucket delimited by the edges passed to the store, starting
+// below the first edge.
+type Facets struct {
+	Extensions []FacetValue
+	Roots      []FacetValue
+	Sizes      []FacetValue
+	Modified   []FacetValue
+}
+
+// Directory aggregates the records beneath a directory at any depth.
+type Directory struct {
+	Path     string
+	RootPath string
+	Files    int
+	Size     int64
+	// ModTime is the latest modification time of any of the files.
+	ModTime time.Time
+}
+
+// DuplicateGroup lists records sharing the same content hash.
+type DuplicateGroup struct {
+	Hash    string
+	Size    int64
+	Records []Record
+}
+
+// DuplicateSummary totals every duplicate group before paging.
+type DuplicateSummary struct {
+	Groups      int
+	Files       int
+	WastedBytes int64
+}
+
+// Extension returns the lower-case extension of a file name, including the
+// leading dot, or an empty string when there is none.
+func Extension(name string) string {
+	return strings.ToLower(filepath.Ext(name))
+}
+
+// Batch groups record writes into a single transaction. Nothing is visible to
+// readers until Commit succeeds; Rollback discards every write in the batch
+// and is a no-op after Commit.
+type Batch interface {
+	Upsert(ctx context.Context, record Record) error
+	Delete(ctx context.Context, path string) error
+	Move(ctx context.Context, from string, record Record) error
+	AppendChange(ctx context.Context, change Change) error
+	UpdateScanState(ctx context.Context, state ScanState) error
+	Commit() error
+	Rollback() error
+}
@@ -0,0 +1,139 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"seekfile/internal/storage"
+)
+
+// Batch is a write transaction with prepared statements for the operations
+// performed by scans.
+type Batch struct {
+	tx           *sql.Tx
+	previous     *sql.Stmt
+	upsert       *sql.Stmt
+	deleteRecord *sql.Stmt
+	deleteBody   *sql.Stmt
+	deleteDoc    *sql.Stmt
+	scanState    *sql.Stmt
+	change       *sql.Stmt
+	// dirs collects directory aggregate changes until Commit.
+	dirs dirDeltas
+}
+
+// Begin starts a write transaction. The caller must end it with Commit or
+// Rollback.
+func (s *Store) Begin(ctx context.Context) (storage.Batch, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin batch: %w", err)
+	}
+
+	batch := &Batch{tx: tx, dirs: make(dirDeltas)}
+	statements := []struct {
+		target **sql.Stmt
+		query  string
+	}{
+		{&batch.previous, previousRecordSQL},
+		{&batch.upsert, upsertRecordSQL},
+		{&batch.deleteRecord, deleteRecordSQL},
+		{&batch.deleteBody, deleteContentBodySQL},
+		{&batch.deleteDoc, deleteContentDocSQL},
+		{&batch.scanState, upsertScanStateSQL},
+		{&batch.change, appendChangeSQL},
+	}
+	for _, statement := range statements {
+		stmt, err := tx.PrepareContext(ctx, statement.query)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("prepare batch: %w", err)
+		}
+		*statement.target = stmt
+	}
+	return batch, nil
+}
+
+// Upsert inserts or updates a record.
+func (b *Batch) Upsert(ctx context.Context, record storage.Record) error {
+	if err := b.dirs.removeStored(b.previous.QueryRowContext(ctx, record.Path), record.Path); err != nil {
+		return err
+	}
+	if _, err := b.upsert.ExecContext(ctx, recordArgs(record)...); err != nil {
+		return fmt.Errorf("upsert record %s: %w", record.Path, err)
+	}
+	b.dirs.insert(record.Path, record.RootPath, record.Size, record.ModTime.UnixNano())
+	return nil
+}
+
+// Delete removes a record, along with any indexed content, by its path.
+func (b *Batch) Delete(ctx context.Context, path string) error {
+	if err := b.dirs.removeStored(b.previous.QueryRowContext(ctx, path), path); err != nil {
+		return err
+	}
+	for _, stmt := range []*sql.Stmt{b.deleteRecord, b.deleteBody, b.deleteDoc} {
+		if _, err := stmt.ExecContext(ctx, path); err != nil {
+			return fmt.Errorf("delete record %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Move renames the record at from to the path of record and updates its
+// metadata in place.
+func (b *Batch) Move(ctx context.Context, from string, record storage.Record) error {
+	return moveRecord(ctx, b.tx, b.dirs, from, record)
+}
+
+// UpsertContent stores the extracted text of a file in the full-text index.
+func (b *Batch) UpsertContent(ctx context.Context, path, name, body string) error {
+	return upsertContent(ctx, b.tx, path, name, body)
+}
+
+// DeleteContent removes a file from the full-text index.
+func (b *Batch) DeleteContent(ctx context.Context, path string) error {
+	for _, stmt := range []*sql.Stmt{b.deleteBody, b.deleteDoc} {
+		if _, err := stmt.ExecContext(ctx, path); err != nil {
+			return fmt.Errorf("delete content %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// AppendChange adds an entry to the change log.
+func (b *Batch) AppendChange(ctx context.Context, change storage.Change) error {
+	if _, err := b.change.ExecContext(ctx, changeArgs(change)...); err != nil {
+		return fmt.Errorf("append change %s: %w", change.Path, err)
+	}
+	return nil
+}
+
+// UpdateScanState writes the scan timestamps for a root path.
+func (b *Batch) UpdateScanState(ctx context.Context, state storage.ScanState) error {
+	_, err := b.scanState.ExecContext(ctx, state.RootPath, state.LastFullScan.UnixNano(), state.LastIncrementalScan.UnixNano())
+	if err != nil {
+		return fmt.Errorf("update scan state %s: %w", state.RootPath, err)
+	}
+	return nil
+}
+
+// Commit makes every write in the batch durable.
+func (b *Batch) Commit() error {
+	if err := b.dirs.apply(context.Background(), b.tx); err != nil {
+		return err
+	}
+	if err := b.tx.Commit(); err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+	return nil
+}
+
+// Rollback discards the batch.
+func (b *Batch) Rollback() error {
+	if err := b.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return fmt.Errorf("roll back batch: %w", err)
+	}
+	return nil
+}
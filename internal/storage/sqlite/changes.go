@@ -0,0 +1,120 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+const appendChangeSQL = `INSERT INTO change_log(time, kind, path, old_path, cause, scan_id) VALUES(?, ?, ?, ?, ?, ?)`
+
+func changeArgs(change storage.Change) []any {
+	return []any{change.Time.UnixNano(), change.Kind, change.Path, change.OldPath, change.Cause, change.ScanID}
+}
+
+// AppendChanges adds entries to the change log in one transaction.
+func (s *Store) AppendChanges(ctx context.Context, changes []storage.Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("append changes: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, appendChangeSQL)
+	if err != nil {
+		return fmt.Errorf("append changes: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, change := range changes {
+		if _, err := stmt.ExecContext(ctx, changeArgs(change)...); err != nil {
+			return fmt.Errorf("append change %s: %w", change.Path, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("append changes: %w", err)
+	}
+	return nil
+}
+
+// Changes returns a page of the change log in ID order.
+func (s *Store) Changes(ctx context.Context, query storage.ChangeQuery) ([]storage.Change, error) {
+	sqlQuery := `SELECT id, time, kind, path, old_path, cause, scan_id FROM change_log WHERE id > ?`
+	args := []any{query.AfterID}
+	if !query.Since.IsZero() {
+		sqlQuery += ` AND time >= ?`
+		args = append(args, query.Since.UnixNano())
+	}
+	if query.Roots != nil {
+		clauses := make([]string, 0, len(query.Roots))
+		for _, root := range query.Roots {
+			lower, upper := prefixRange(root)
+			clauses = append(clauses, "path = ? OR (path >= ? AND path < ?)")
+			args = append(args, root, lower, upper)
+		}
+		if len(clauses) == 0 {
+			clauses = append(clauses, "0")
+		}
+		sqlQuery += ` AND (` + strings.Join(clauses, " OR ") + `)`
+	}
+	sqlQuery += ` ORDER BY id`
+	if query.Limit > 0 {
+		sqlQuery += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query changes: %w", err)
+	}
+	defer rows.Close()
+
+	changes := make([]storage.Change, 0)
+	for rows.Next() {
+		var (
+			change    storage.Change
+			timestamp int64
+		)
+		if err := rows.Scan(&change.ID, &timestamp, &change.Kind, &change.Path, &change.OldPath, &change.Cause, &change.ScanID); err != nil {
+			return nil, fmt.Errorf("scan change: %w", err)
+		}
+		change.Time = time.Unix(0, timestamp)
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate changes: %w", err)
+	}
+	return changes, nil
+}
+
+// PruneChanges deletes change log entries logged before the cutoff and, when
+// maxEntries is positive, the oldest entries beyond that count. It returns
+// the number of deleted entries.
+func (s *Store) PruneChanges(ctx context.Context, before time.Time, maxEntries int) (int64, error) {
+	var deleted int64
+	if !before.IsZero() {
+		result, err := s.db.ExecContext(ctx, `DELETE FROM change_log WHERE time < ?`, before.UnixNano())
+		if err != nil {
+			return 0, fmt.Errorf("prune changes: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	if maxEntries > 0 {
+		result, err := s.db.ExecContext(ctx, `
+DELETE FROM change_log WHERE id <= (SELECT id FROM change_log ORDER BY id DESC LIMIT 1 OFFSET ?)
+`, maxEntries)
+		if err != nil {
+			return deleted, fmt.Errorf("prune changes: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
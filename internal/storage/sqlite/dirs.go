@@ -0,0 +1,241 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+const (
+	previousRecordSQL  = `SELECT size, mod_time, root_path FROM file_records WHERE path = ?`
+	upsertDirectorySQL = `
+INSERT INTO directories(path, parent, root_path, file_count, total_size, latest_mod)
+VALUES(?1, ?2, ?3, ?4, ?5, ?6)
+ON CONFLICT(path) DO UPDATE SET
+        file_count = file_count + excluded.file_count,
+        total_size = total_size + excluded.total_size,
+        latest_mod = CASE
+                WHEN latest_mod IS NULL OR ?7 >= latest_mod THEN NULL
+                ELSE max(latest_mod, coalesce(excluded.latest_mod, latest_mod))
+        END
+`
+	deleteEmptyDirectorySQL = `DELETE FROM directories WHERE path = ? AND file_count <= 0`
+	directoryColumns        = `path, root_path, file_count, total_size, latest_mod`
+)
+
+// dirDelta accumulates the changes to one directory's aggregates.
+type dirDelta struct {
+	parent string
+	root   string
+	files  int
+	size   int64
+	// added is the latest modification time among added files.
+	added sql.NullInt64
+	// removed is the latest modification time among removed files. When it
+	// reaches the stored latest time, that time is cleared and recomputed on
+	// the next read.
+	removed sql.NullInt64
+}
+
+// dirDeltas collects directory aggregate changes by path until they are
+// written with apply.
+type dirDeltas map[string]*dirDelta
+
+// insert counts a file in every directory from its parent up to its root.
+func (d dirDeltas) insert(path, root string, size, modTime int64) {
+	d.walk(path, root, func(delta *dirDelta) {
+		delta.files++
+		delta.size += size
+		if !delta.added.Valid || modTime > delta.added.Int64 {
+			delta.added = sql.NullInt64{Int64: modTime, Valid: true}
+		}
+	})
+}
+
+// remove uncounts a file from every directory from its parent up to its
+// root.
+func (d dirDeltas) remove(path, root string, size, modTime int64) {
+	d.walk(path, root, func(delta *dirDelta) {
+		delta.files--
+		delta.size -= size
+		if !delta.removed.Valid || modTime > delta.removed.Int64 {
+			delta.removed = sql.NullInt64{Int64: modTime, Valid: true}
+		}
+	})
+}
+
+// removeStored uncounts the record stored at path, if any, reading it from
+// row.
+func (d dirDeltas) removeStored(row *sql.Row, path string) error {
+	var (
+		size, modTime int64
+		root          string
+	)
+	err := row.Scan(&size, &modTime, &root)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read record %s: %w", path, err)
+	}
+	d.remove(path, root, size, modTime)
+	return nil
+}
+
+func (d dirDeltas) walk(path, root string, fn func(*dirDelta)) {
+	for dir := filepath.Dir(path); ; {
+		parent := filepath.Dir(dir)
+		top := dir == root || parent == dir
+		delta, ok := d[dir]
+		if !ok {
+			delta = &dirDelta{parent: parent, root: root}
+			if top {
+				delta.parent = ""
+			}
+			d[dir] = delta
+		}
+		fn(delta)
+		if top {
+			return
+		}
+		dir = parent
+	}
+}
+
+// apply writes the collected changes and drops directories left without
+// files.
+func (d dirDeltas) apply(ctx context.Context, tx *sql.Tx) error {
+	if len(d) == 0 {
+		return nil
+	}
+	upsert, err := tx.PrepareContext(ctx, upsertDirectorySQL)
+	if err != nil {
+		return fmt.Errorf("update directories: %w", err)
+	}
+	defer upsert.Close()
+	prune, err := tx.PrepareContext(ctx, deleteEmptyDirectorySQL)
+	if err != nil {
+		return fmt.Errorf("update directories: %w", err)
+	}
+	defer prune.Close()
+
+	for path, delta := range d {
+		if _, err := upsert.ExecContext(ctx, path, delta.parent, delta.root, delta.files, delta.size, delta.added, delta.removed); err != nil {
+			return fmt.Errorf("update directory %s: %w", path, err)
+		}
+		if delta.files < 0 {
+			if _, err := prune.ExecContext(ctx, path); err != nil {
+				return fmt.Errorf("update directory %s: %w", path, err)
+			}
+		}
+	}
+	clear(d)
+	return nil
+}
+
+// Directory returns the aggregates of an indexed directory.
+func (s *Store) Directory(ctx context.Context, path string) (storage.Directory, bool, error) {
+	dirs, err := s.directories(ctx, `SELECT `+directoryColumns+` FROM directories WHERE path = ?`, path)
+	if err != nil || len(dirs) == 0 {
+		return storage.Directory{}, false, err
+	}
+	return dirs[0], true, nil
+}
+
+// Subdirectories returns the aggregates of the indexed directories directly
+// inside path.
+func (s *Store) Subdirectories(ctx context.Context, path string) ([]storage.Directory, error) {
+	return s.directories(ctx, `SELECT `+directoryColumns+` FROM directories WHERE parent = ?`, path)
+}
+
+// directories reads directory rows, recomputing latest modification times
+// that were cleared when a file was removed.
+func (s *Store) directories(ctx context.Context, query string, args ...any) ([]storage.Directory, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query directories: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		dirs  []storage.Directory
+		stale []int
+	)
+	for rows.Next() {
+		var (
+			dir    storage.Directory
+			latest sql.NullInt64
+		)
+		if err := rows.Scan(&dir.Path, &dir.RootPath, &dir.Files, &dir.Size, &latest); err != nil {
+			return nil, fmt.Errorf("scan directory: %w", err)
+		}
+		if latest.Valid {
+			dir.ModTime = time.Unix(0, latest.Int64)
+		} else {
+			stale = append(stale, len(dirs))
+		}
+		dirs = append(dirs, dir)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate directories: %w", err)
+	}
+	rows.Close()
+
+	for _, i := range stale {
+		lower, upper := prefixRange(dirs[i].Path)
+		var latest int64
+		if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(mod_time), 0) FROM file_records WHERE path >= ? AND path < ?`,
+			lower, upper).Scan(&latest); err != nil {
+			return nil, fmt.Errorf("query directory %s: %w", dirs[i].Path, err)
+		}
+		if _, err := s.db.ExecContext(ctx, `UPDATE directories SET latest_mod = ? WHERE path = ? AND latest_mod IS NULL`,
+			latest, dirs[i].Path); err != nil {
+			return nil, fmt.Errorf("update directory %s: %w", dirs[i].Path, err)
+		}
+		dirs[i].ModTime = time.Unix(0, latest)
+	}
+	return dirs, nil
+}
+
+// backfillDirectories fills the dir column and the directories table for
+// rows written before they existed.
+func backfillDirectories(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT path, size, mod_time, root_path FROM file_records`)
+	if err != nil {
+		return fmt.Errorf("backfill directories: %w", err)
+	}
+
+	var (
+		paths  []string
+		deltas = make(dirDeltas)
+	)
+	for rows.Next() {
+		var (
+			path, root    string
+			size, modTime int64
+		)
+		if err := rows.Scan(&path, &size, &modTime, &root); err != nil {
+			rows.Close()
+			return fmt.Errorf("backfill directories: %w", err)
+		}
+		paths = append(paths, path)
+		deltas.insert(path, root, size, modTime)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("backfill directories: %w", err)
+	}
+	rows.Close()
+
+	for _, path := range paths {
+		if _, err := tx.ExecContext(ctx, `UPDATE file_records SET dir = ? WHERE path = ?`, filepath.Dir(path), path); err != nil {
+			return fmt.Errorf("backfill directories: %w", err)
+		}
+	}
+	return deltas.apply(ctx, tx)
+}
@@ -0,0 +1,105 @@
+package sqlite
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"seekfile/internal/querylang"
+
+	sqlitedriver "modernc.org/sqlite"
+)
+
+func init() {
+	// SQLite rewrites X REGEXP Y into regexp(Y, X) but leaves the function
+	// to the application.
+	sqlitedriver.MustRegisterDeterministicScalarFunction("regexp", 2, sqlRegexp)
+}
+
+// compiledPatterns caches the patterns of sqlRegexp, which is called once
+// per row.
+var compiledPatterns sync.Map
+
+func sqlRegexp(_ *sqlitedriver.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("regexp: pattern must be text")
+	}
+	var value string
+	switch v := args[1].(type) {
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	case nil:
+		return false, nil
+	default:
+		value = fmt.Sprint(v)
+	}
+
+	cached, ok := compiledPatterns.Load(pattern)
+	if !ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		cached, _ = compiledPatterns.LoadOrStore(pattern, re)
+	}
+	return cached.(*regexp.Regexp).MatchString(value), nil
+}
+
+var textColumns = map[querylang.Field]string{
+	querylang.FieldName: "name",
+	querylang.FieldPath: "path",
+	querylang.FieldExt:  "ext",
+	querylang.FieldRoot: "root_path",
+}
+
+var compareColumns = map[querylang.Field]string{
+	querylang.FieldSize:     "size",
+	querylang.FieldModified: "mod_time",
+}
+
+// compileExpr translates a parsed query into a WHERE clause.
+func compileExpr(expr querylang.Expr) (string, []any) {
+	switch e := expr.(type) {
+	case querylang.And:
+		return compileList(e, " AND ")
+	case querylang.Or:
+		return compileList(e, " OR ")
+	case querylang.Not:
+		clause, args := compileExpr(e.Expr)
+		return "NOT " + clause, args
+	case querylang.Compare:
+		return fmt.Sprintf("%s %s ?", compareColumns[e.Field], e.Op), []any{e.Value}
+	case querylang.Text:
+		column := textColumns[e.Field]
+		switch e.Mode {
+		case querylang.Wildcard:
+			return column + ` LIKE ? ESCAPE '\'`, []any{likePattern(e.Value)}
+		case querylang.Regexp:
+			return column + " REGEXP ?", []any{e.Regexp.String()}
+		case querylang.Exact:
+			if e.Field == querylang.FieldRoot {
+				return `(lower(root_path) = ? OR lower(root_path) LIKE ? ESCAPE '\')`, []any{e.Value, "%/" + escapeLike(e.Value)}
+			}
+			return column + " = ?", []any{e.Value}
+		default:
+			return column + ` LIKE ? ESCAPE '\'`, []any{"%" + escapeLike(e.Value) + "%"}
+		}
+	}
+	return "1", nil
+}
+
+func compileList(exprs []querylang.Expr, sep string) (string, []any) {
+	clauses := make([]string, 0, len(exprs))
+	var args []any
+	for _, expr := range exprs {
+		clause, exprArgs := compileExpr(expr)
+		clauses = append(clauses, clause)
+		args = append(args, exprArgs...)
+	}
+	return "(" + strings.Join(clauses, sep) + ")", args
+}
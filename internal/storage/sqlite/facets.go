@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+// Facets aggregates the records matching query by extension, scan root, size
+// and modification time. sizeEdges and timeEdges must be ascending; bucket i
+// holds the values below edge i and at or above edge i-1.
+func (s *Store) Facets(ctx context.Context, query storage.Query, sizeEdges []int64, timeEdges []time.Time) (storage.Facets, error) {
+	where, args := buildWhere(query)
+
+	var (
+		facets storage.Facets
+		err    error
+	)
+	if facets.Extensions, err = s.facetValues(ctx, "ext", where, args); err != nil {
+		return storage.Facets{}, err
+	}
+	if facets.Roots, err = s.facetValues(ctx, "root_path", where, args); err != nil {
+		return storage.Facets{}, err
+	}
+
+	edges := make([]int64, 0, len(timeEdges))
+	for _, edge := range timeEdges {
+		edges = append(edges, edge.UnixNano())
+	}
+	if facets.Sizes, err = s.facetBuckets(ctx, "size", sizeEdges, where, args); err != nil {
+		return storage.Facets{}, err
+	}
+	if facets.Modified, err = s.facetBuckets(ctx, "mod_time", edges, where, args); err != nil {
+		return storage.Facets{}, err
+	}
+	return facets, nil
+}
+
+func (s *Store) facetValues(ctx context.Context, column, where string, args []any) ([]storage.FacetValue, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+column+`, COUNT(*), COALESCE(SUM(size), 0) FROM file_records`+where+` GROUP BY `+column, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query %s facets: %w", column, err)
+	}
+	defer rows.Close()
+
+	values := make([]storage.FacetValue, 0)
+	for rows.Next() {
+		var value storage.FacetValue
+		if err := rows.Scan(&value.Value, &value.Count, &value.Bytes); err != nil {
+			return nil, fmt.Errorf("scan %s facet: %w", column, err)
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s facets: %w", column, err)
+	}
+	return values, nil
+}
+
+// facetBuckets counts records per range of column delimited by edges.
+func (s *Store) facetBuckets(ctx context.Context, column string, edges []int64, where string, args []any) ([]storage.FacetValue, error) {
+	var bucket strings.Builder
+	bucketArgs := make([]any, 0, len(edges)+len(args))
+	bucket.WriteString("CASE")
+	for i, edge := range edges {
+		fmt.Fprintf(&bucket, " WHEN %s < ? THEN %d", column, i)
+		bucketArgs = append(bucketArgs, edge)
+	}
+	fmt.Fprintf(&bucket, " ELSE %d END", len(edges))
+	bucketArgs = append(bucketArgs, args...)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+bucket.String()+` AS bucket, COUNT(*), COALESCE(SUM(size), 0) FROM file_records`+where+` GROUP BY bucket`, bucketArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query %s buckets: %w", column, err)
+	}
+	defer rows.Close()
+
+	buckets := make([]storage.FacetValue, len(edges)+1)
+	for rows.Next() {
+		var (
+			index int
+			value storage.FacetValue
+		)
+		if err := rows.Scan(&index, &value.Count, &value.Bytes); err != nil {
+			return nil, fmt.Errorf("scan %s bucket: %w", column, err)
+		}
+		if index >= 0 && index < len(buckets) {
+			buckets[index] = value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s buckets: %w", column, err)
+	}
+	return buckets, nil
+}
@@ -0,0 +1,493 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+// ErrSchemaTooNew is returned by Open when the database was written by a newer
+// version of seekfile than the running one.
+var ErrSchemaTooNew = errors.New("database schema is newer than this version supports")
+
+// migration upgrades the schema by one version. Migrations run in order, each
+// in its own transaction, and must be safe to apply to databases created
+// before versioning was introduced, which already contain some of their
+// changes. Destructive migrations, which drop or rewrite data, trigger a
+// backup of the database before they run.
+type migration struct {
+	version     int
+	name        string
+	destructive bool
+	apply       func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations lists every schema version in order. Append new entries; never
+// edit or reorder released ones.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "file records and scan state",
+		apply: execMigration(`
+CREATE TABLE IF NOT EXISTS file_records (
+        path TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        size INTEGER NOT NULL,
+        mod_time INTEGER NOT NULL,
+        root_path TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS scan_state (
+        root_path TEXT PRIMARY KEY,
+        last_full_scan INTEGER NOT NULL DEFAULT 0,
+        last_incremental_scan INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_records_root ON file_records(root_path);
+`),
+	},
+	{
+		version: 2,
+		name:    "content hashes",
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			if err := addColumn(ctx, tx, "file_records", "partial_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			if err := addColumn(ctx, tx, "file_records", "content_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			return execMigration(`
+CREATE INDEX IF NOT EXISTS idx_file_records_content_hash ON file_records(content_hash) WHERE content_hash <> '';
+`)(ctx, tx)
+		},
+	},
+	{
+		version: 3,
+		name:    "full-text content index",
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			if err := addColumn(ctx, tx, "file_records", "content_indexed", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+			return execMigration(`
+CREATE TABLE IF NOT EXISTS content_docs (
+        id INTEGER PRIMARY KEY,
+        path TEXT NOT NULL UNIQUE
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS file_contents USING fts5(
+        name,
+        body,
+        tokenize = 'unicode61 remove_diacritics 2'
+);
+`)(ctx, tx)
+		},
+	},
+	{
+		version: 4,
+		name:    "search indexes",
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			if err := addColumn(ctx, tx, "file_records", "ext", "TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			if err := backfillExtensions(ctx, tx); err != nil {
+				return err
+			}
+			return execMigration(`
+CREATE INDEX IF NOT EXISTS idx_file_records_name ON file_records(name COLLATE NOCASE, path);
+CREATE INDEX IF NOT EXISTS idx_file_records_ext ON file_records(ext, name COLLATE NOCASE);
+CREATE INDEX IF NOT EXISTS idx_file_records_size ON file_records(size, path);
+CREATE INDEX IF NOT EXISTS idx_file_records_mod_time ON file_records(mod_time, path);
+`)(ctx, tx)
+		},
+	},
+	{
+		version: 5,
+		name:    "file identity",
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			if err := addColumn(ctx, tx, "file_records", "device", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+			if err := addColumn(ctx, tx, "file_records", "inode", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+			return execMigration(`
+CREATE INDEX IF NOT EXISTS idx_file_records_identity ON file_records(device, inode) WHERE inode <> 0;
+`)(ctx, tx)
+		},
+	},
+	{
+		version: 6,
+		name:    "change log",
+		apply: execMigration(`
+CREATE TABLE IF NOT EXISTS change_log (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        time INTEGER NOT NULL,
+        kind TEXT NOT NULL,
+        path TEXT NOT NULL,
+        old_path TEXT NOT NULL DEFAULT '',
+        cause TEXT NOT NULL,
+        scan_id INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_change_log_time ON change_log(time);
+`),
+	},
+	{
+		version: 7,
+		name:    "file name trigrams",
+		apply: execMigration(`
+CREATE VIRTUAL TABLE IF NOT EXISTS file_names USING fts5(
+        name,
+        content = 'file_records',
+        tokenize = 'trigram'
+);
+
+CREATE TRIGGER IF NOT EXISTS file_names_insert AFTER INSERT ON file_records BEGIN
+        INSERT INTO file_names(rowid, name) VALUES (new.rowid, new.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS file_names_delete AFTER DELETE ON file_records BEGIN
+        INSERT INTO file_names(file_names, rowid, name) VALUES ('delete', old.rowid, old.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS file_names_update AFTER UPDATE OF name ON file_records BEGIN
+        INSERT INTO file_names(file_names, rowid, name) VALUES ('delete', old.rowid, old.name);
+        INSERT INTO file_names(rowid, name) VALUES (new.rowid, new.name);
+END;
+
+INSERT INTO file_names(file_names) VALUES ('rebuild');
+`),
+	},
+	{
+		version: 8,
+		name:    "directory aggregates",
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			if err := addColumn(ctx, tx, "file_records", "dir", "TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			if err := execMigration(`
+CREATE TABLE IF NOT EXISTS directories (
+        path TEXT PRIMARY KEY,
+        parent TEXT NOT NULL,
+        root_path TEXT NOT NULL,
+        file_count INTEGER NOT NULL,
+        total_size INTEGER NOT NULL,
+        latest_mod INTEGER
+);
+
+DELETE FROM directories;
+
+CREATE INDEX IF NOT EXISTS idx_directories_parent ON directories(parent);
+CREATE INDEX IF NOT EXISTS idx_file_records_dir ON file_records(dir, name COLLATE NOCASE);
+`)(ctx, tx); err != nil {
+				return err
+			}
+			return backfillDirectories(ctx, tx)
+		},
+	},
+	{
+		version: 9,
+		name:    "scan runs",
+		apply: execMigration(`
+CREATE TABLE IF NOT EXISTS scan_runs (
+        id INTEGER PRIMARY KEY,
+        mode TEXT NOT NULL,
+        state TEXT NOT NULL,
+        roots TEXT NOT NULL,
+        paths TEXT NOT NULL DEFAULT '[]',
+        started_at INTEGER NOT NULL,
+        finished_at INTEGER NOT NULL,
+        duration INTEGER NOT NULL,
+        processed INTEGER NOT NULL DEFAULT 0,
+        added INTEGER NOT NULL DEFAULT 0,
+        modified INTEGER NOT NULL DEFAULT 0,
+        moved INTEGER NOT NULL DEFAULT 0,
+        deleted INTEGER NOT NULL DEFAULT 0,
+        bytes INTEGER NOT NULL DEFAULT 0,
+        errors TEXT NOT NULL DEFAULT '[]'
+);
+`),
+	},
+	{
+		version: 10,
+		name:    "scan walk errors",
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			for _, column := range []struct{ name, definition string }{
+				{"walk_error_count", "INTEGER NOT NULL DEFAULT 0"},
+				{"walk_error_kinds", "TEXT NOT NULL DEFAULT '{}'"},
+				{"walk_errors", "TEXT NOT NULL DEFAULT '[]'"},
+			} {
+				if err := addColumn(ctx, tx, "scan_runs", column.name, column.definition); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// file_names is keyed by the rowid of file_records, which VACUUM may
+		// renumber while path is the primary key. Rebuild file_records with
+		// an explicit id for file_names to reference.
+		version:     11,
+		name:        "file record ids",
+		destructive: true,
+		apply: execMigration(`
+DROP TRIGGER IF EXISTS file_names_insert;
+DROP TRIGGER IF EXISTS file_names_delete;
+DROP TRIGGER IF EXISTS file_names_update;
+DROP TABLE IF EXISTS file_names;
+
+CREATE TABLE file_records_new (
+        id INTEGER PRIMARY KEY,
+        path TEXT NOT NULL UNIQUE,
+        name TEXT NOT NULL,
+        size INTEGER NOT NULL,
+        mod_time INTEGER NOT NULL,
+        root_path TEXT NOT NULL,
+        partial_hash TEXT NOT NULL DEFAULT '',
+        content_hash TEXT NOT NULL DEFAULT '',
+        content_indexed INTEGER NOT NULL DEFAULT 0,
+        ext TEXT NOT NULL DEFAULT '',
+        device INTEGER NOT NULL DEFAULT 0,
+        inode INTEGER NOT NULL DEFAULT 0,
+        dir TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO file_records_new(path, name, size, mod_time, root_path, partial_hash, content_hash, content_indexed, ext, device, inode, dir)
+SELECT path, name, size, mod_time, root_path, partial_hash, content_hash, content_indexed, ext, device, inode, dir FROM file_records;
+
+DROP TABLE file_records;
+ALTER TABLE file_records_new RENAME TO file_records;
+
+CREATE INDEX idx_file_records_root ON file_records(root_path);
+CREATE INDEX idx_file_records_content_hash ON file_records(content_hash) WHERE content_hash <> '';
+CREATE INDEX idx_file_records_name ON file_records(name COLLATE NOCASE, path);
+CREATE INDEX idx_file_records_ext ON file_records(ext, name COLLATE NOCASE);
+CREATE INDEX idx_file_records_size ON file_records(size, path);
+CREATE INDEX idx_file_records_mod_time ON file_records(mod_time, path);
+CREATE INDEX idx_file_records_identity ON file_records(device, inode) WHERE inode <> 0;
+CREATE INDEX idx_file_records_dir ON file_records(dir, name COLLATE NOCASE);
+
+CREATE VIRTUAL TABLE file_names USING fts5(
+        name,
+        content = 'file_records',
+        content_rowid = 'id',
+        tokenize = 'trigram'
+);
+
+CREATE TRIGGER file_names_insert AFTER INSERT ON file_records BEGIN
+        INSERT INTO file_names(rowid, name) VALUES (new.id, new.name);
+END;
+
+CREATE TRIGGER file_names_delete AFTER DELETE ON file_records BEGIN
+        INSERT INTO file_names(file_names, rowid, name) VALUES ('delete', old.id, old.name);
+END;
+
+CREATE TRIGGER file_names_update AFTER UPDATE OF name ON file_records BEGIN
+        INSERT INTO file_names(file_names, rowid, name) VALUES ('delete', old.id, old.name);
+        INSERT INTO file_names(rowid, name) VALUES (new.id, new.name);
+END;
+
+INSERT INTO file_names(file_names) VALUES ('rebuild');
+`),
+	},
+}
+
+// SchemaVersion is the schema version written by this build.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate brings the database at path up to SchemaVersion.
+func (s *Store) migrate(ctx context.Context, path string) error {
+	if _, err := s.db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at INTEGER NOT NULL
+)`); err != nil {
+		return fmt.Errorf("initialize schema: %w", err)
+	}
+
+	current, err := schemaVersion(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	if latest := SchemaVersion(); current > latest {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, current, latest)
+	}
+
+	pending := make([]migration, 0, len(migrations))
+	for _, m := range migrations {
+		if m.version > current {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	existing, err := hasRecords(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	if existing {
+		for _, m := range pending {
+			if !m.destructive {
+				continue
+			}
+			if err := s.backup(ctx, path, current); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	for _, m := range pending {
+		if err := s.applyMigration(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) applyMigration(ctx context.Context, m migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migrate schema to version %d: %w", m.version, err)
+	}
+	defer tx.Rollback()
+
+	// Another process may have applied the migration since the version was
+	// read.
+	current, err := schemaVersion(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if current >= m.version {
+		return nil
+	}
+
+	if err := m.apply(ctx, tx); err != nil {
+		return fmt.Errorf("migrate schema to version %d (%s): %w", m.version, m.name, err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations(version, name, applied_at) VALUES(?, ?, ?)`,
+		m.version, m.name, time.Now().UnixNano()); err != nil {
+		return fmt.Errorf("migrate schema to version %d: %w", m.version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migrate schema to version %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// backup writes a consistent copy of the database next to it before a
+// destructive migration.
+func (s *Store) backup(ctx context.Context, path string, version int) error {
+	target := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102T150405"))
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, target); err != nil {
+		return fmt.Errorf("back up database before migration: %w", err)
+	}
+	return nil
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func schemaVersion(ctx context.Context, q queryer) (int, error) {
+	var version int
+	if err := q.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// hasRecords reports whether the database already holds a file_records
+// table, which databases created before versioning have without a version.
+func hasRecords(ctx context.Context, q queryer) (bool, error) {
+	var count int
+	if err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'file_records'`).Scan(&count); err != nil {
+		return false, fmt.Errorf("inspect schema: %w", err)
+	}
+	return count > 0, nil
+}
+
+func execMigration(statements string) func(ctx context.Context, tx *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, statements)
+		return err
+	}
+}
+
+// addColumn adds a column to a table unless it already exists.
+func addColumn(ctx context.Context, tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return fmt.Errorf("inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("inspect table %s: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// backfillExtensions populates the ext column for rows written before it
+// existed.
+func backfillExtensions(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT path, name FROM file_records WHERE ext = '' AND name LIKE '%.%'`)
+	if err != nil {
+		return fmt.Errorf("backfill extensions: %w", err)
+	}
+
+	updates := make(map[string]string)
+	for rows.Next() {
+		var path, name string
+		if err := rows.Scan(&path, &name); err != nil {
+			rows.Close()
+			return fmt.Errorf("backfill extensions: %w", err)
+		}
+		if ext := storage.Extension(name); ext != "" {
+			updates[path] = ext
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("backfill extensions: %w", err)
+	}
+	rows.Close()
+
+	for path, ext := range updates {
+		if _, err := tx.ExecContext(ctx, `UPDATE file_records SET ext = ? WHERE path = ?`, ext, path); err != nil {
+			return fmt.Errorf("backfill extensions: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+// baselineSQL is the schema of databases written before versioning was
+// introduced.
+const baselineSQL = `
+CREATE TABLE file_records (
+        path TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        size INTEGER NOT NULL,
+        mod_time INTEGER NOT NULL,
+        root_path TEXT NOT NULL
+);
+
+CREATE TABLE scan_state (
+        root_path TEXT PRIMARY KEY,
+        last_full_scan INTEGER NOT NULL DEFAULT 0,
+        last_incremental_scan INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO file_records(path, name, size, mod_time, root_path) VALUES
+        ('/data/docs/report.pdf', 'report.pdf', 100, 1700000000000000000, '/data'),
+        ('/data/docs/notes.txt', 'notes.txt', 20, 1700000001000000000, '/data'),
+        ('/data/photo.jpeg', 'photo.jpeg', 300, 1700000002000000000, '/data');
+
+INSERT INTO scan_state(root_path, last_full_scan) VALUES ('/data', 1700000003000000000);
+`
+
+// openRaw opens a database without migrating it.
+func openRaw(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// createAtVersion creates a database at path with the migrations up to
+// version applied.
+func createAtVersion(t *testing.T, path string, version int) *sql.DB {
+	t.Helper()
+	db := openRaw(t, path)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at INTEGER NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	store := &Store{db: db}
+	for _, m := range migrations {
+		if m.version > version {
+			break
+		}
+		if err := store.applyMigration(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(path + ".v*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func rawVersion(t *testing.T, path string) int {
+	t.Helper()
+	version, err := schemaVersion(context.Background(), openRaw(t, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestOpenNewDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seekfile.db")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	if got := rawVersion(t, path); got != SchemaVersion() {
+		t.Fatalf("schema version = %d, want %d", got, SchemaVersion())
+	}
+	if got := backups(t, path); len(got) != 0 {
+		t.Fatalf("new database was backed up to %v", got)
+	}
+
+	// Reopening an up-to-date database applies nothing.
+	store, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if got := backups(t, path); len(got) != 0 {
+		t.Fatalf("current database was backed up to %v", got)
+	}
+}
+
+func TestOpenRejectsNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seekfile.db")
+	db := createAtVersion(t, path, SchemaVersion())
+	if _, err := db.Exec(`INSERT INTO schema_migrations(version, name, applied_at) VALUES(?, 'future', 0)`, SchemaVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	store, err := Open(path)
+	if err == nil {
+		store.Close()
+		t.Fatal("Open succeeded on a newer schema")
+	}
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("Open error = %v, want ErrSchemaTooNew", err)
+	}
+	if got := rawVersion(t, path); got != SchemaVersion()+1 {
+		t.Fatalf("schema version = %d after a refused open, want %d", got, SchemaVersion()+1)
+	}
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seekfile.db")
+	db := openRaw(t, path)
+	if _, err := db.Exec(baselineSQL); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	if got := backups(t, path); len(got) != 1 || rawVersion(t, got[0]) != 0 {
+		t.Fatalf("backups = %v, want one of the unversioned database", got)
+	}
+
+	records, err := store.LoadAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("LoadAll returned %d records, want 3", len(records))
+	}
+	record, ok, err := store.Lookup(ctx, "/data/docs/report.pdf")
+	if err != nil || !ok {
+		t.Fatalf("Lookup = %v, %v", ok, err)
+	}
+	if record.Size != 100 || record.RootPath != "/data" || !record.ModTime.Equal(time.Unix(0, 1700000000000000000)) {
+		t.Fatalf("Lookup = %+v, want the baseline row", record)
+	}
+
+	var ext string
+	if err := store.db.QueryRowContext(ctx, `SELECT ext FROM file_records WHERE path = '/data/photo.jpeg'`).Scan(&ext); err != nil {
+		t.Fatal(err)
+	}
+	if ext != ".jpeg" {
+		t.Fatalf("backfilled ext = %q, want .jpeg", ext)
+	}
+
+	dir, ok, err := store.Directory(ctx, "/data/docs")
+	if err != nil || !ok {
+		t.Fatalf("Directory = %v, %v", ok, err)
+	}
+	if dir.Files != 2 || dir.Size != 120 {
+		t.Fatalf("Directory = %+v, want 2 files of 120 bytes", dir)
+	}
+
+	paths, err := store.NameCandidates(ctx, []string{"report"}, storage.Query{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(paths, []string{"/data/docs/report.pdf"}) {
+		t.Fatalf("NameCandidates = %v, want the migrated record", paths)
+	}
+
+	state, err := store.ScanState(ctx, "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.LastFullScan.Equal(time.Unix(0, 1700000003000000000)) {
+		t.Fatalf("LastFullScan = %v, want the baseline value", state.LastFullScan)
+	}
+}
+
+func TestMigrateBacksUpBeforeDestructiveMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seekfile.db")
+	previous := SchemaVersion()
+	db := createAtVersion(t, path, previous)
+	if _, err := db.Exec(`INSERT INTO file_records(path, name, size, mod_time, root_path) VALUES ('/data/a.txt', 'a.txt', 1, 0, '/data')`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	migrations = append(slices.Clip(migrations), migration{
+		version:     previous + 1,
+		name:        "rebuild",
+		destructive: true,
+		apply:       execMigration(`CREATE TABLE rebuilt (id INTEGER PRIMARY KEY)`),
+	})
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	got := backups(t, path)
+	if len(got) != 1 {
+		t.Fatalf("backups = %v, want one", got)
+	}
+	if version := rawVersion(t, got[0]); version != previous {
+		t.Fatalf("backup schema version = %d, want %d", version, previous)
+	}
+	if version := rawVersion(t, path); version != SchemaVersion() {
+		t.Fatalf("schema version = %d, want %d", version, SchemaVersion())
+	}
+	if _, ok, err := store.Lookup(context.Background(), "/data/a.txt"); err != nil || !ok {
+		t.Fatalf("Lookup after migration = %v, %v", ok, err)
+	}
+}
+
+// TestNameCandidatesAfterVacuum checks that the trigram index still resolves
+// to the right records once deletions have left gaps and VACUUM has rewritten
+// the table.
+func TestNameCandidatesAfterVacuum(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "seekfile.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	for _, name := range []string{"alpha.txt", "bravo.txt", "charlie.txt", "delta.txt"} {
+		if err := store.Upsert(ctx, storage.Record{Path: "/data/" + name, Name: name, RootPath: "/data"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{"/data/alpha.txt", "/data/charlie.txt"} {
+		if err := store.Delete(ctx, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.db.ExecContext(ctx, `VACUUM`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"bravo", "delta"} {
+		paths, err := store.NameCandidates(ctx, []string{name}, storage.Query{}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"/data/" + name + ".txt"}; !slices.Equal(paths, want) {
+			t.Errorf("NameCandidates(%s) = %v, want %v", name, paths, want)
+		}
+	}
+}
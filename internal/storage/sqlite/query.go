@@ -0,0 +1,504 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+const recordColumns = `path, name, size, mod_time, root_path, partial_hash, content_hash, content_indexed, device, inode`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRecord(row rowScanner) (storage.Record, error) {
+	var (
+		record  storage.Record
+		modTime int64
+		device  int64
+		inode   int64
+	)
+	err := row.Scan(&record.Path, &record.Name, &record.Size, &modTime, &record.RootPath,
+		&record.PartialHash, &record.ContentHash, &record.ContentIndexed, &device, &inode)
+	if err != nil {
+		return storage.Record{}, fmt.Errorf("scan record: %w", err)
+	}
+	record.ModTime = time.Unix(0, modTime)
+	record.Device = uint64(device)
+	record.Inode = uint64(inode)
+	return record, nil
+}
+
+func (s *Store) queryRecords(ctx context.Context, query string, args ...any) ([]storage.Record, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query records: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]storage.Record, 0)
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate records: %w", err)
+	}
+	return records, nil
+}
+
+// Lookup retrieves a single record by path.
+func (s *Store) Lookup(ctx context.Context, path string) (storage.Record, bool, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+recordColumns+` FROM file_records WHERE path = ?`, path)
+	record, err := scanRecord(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Record{}, false, nil
+	}
+	if err != nil {
+		return storage.Record{}, false, err
+	}
+	return record, true, nil
+}
+
+// LookupIdentity retrieves the records of files with the given device and
+// inode numbers. More than one record is returned for hard links.
+func (s *Store) LookupIdentity(ctx context.Context, device, inode uint64) ([]storage.Record, error) {
+	return s.queryRecords(ctx, `SELECT `+recordColumns+` FROM file_records WHERE device = ? AND inode = ? AND inode <> 0`,
+		int64(device), int64(inode))
+}
+
+// Count returns the number of persisted records.
+func (s *Store) Count(ctx context.Context) (int, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM file_records`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count records: %w", err)
+	}
+	return count, nil
+}
+
+// PathsWithin lists the paths equal to or nested beneath dir, up to limit
+// paths when limit is positive.
+func (s *Store) PathsWithin(ctx context.Context, dir string, limit int) ([]string, error) {
+	lower, upper := prefixRange(dir)
+	sqlQuery := `SELECT path FROM file_records WHERE path = ? OR (path >= ? AND path < ?)`
+	args := []any{dir, lower, upper}
+	if limit > 0 {
+		sqlQuery += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query paths within %s: %w", dir, err)
+	}
+	defer rows.Close()
+
+	paths := make([]string, 0)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("scan path: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate paths: %w", err)
+	}
+	return paths, nil
+}
+
+// RootPaths streams every path recorded for a scan root.
+func (s *Store) RootPaths(ctx context.Context, root string, fn func(path string) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT path FROM file_records WHERE root_path = ?`, root)
+	if err != nil {
+		return fmt.Errorf("query root paths %s: %w", root, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return fmt.Errorf("scan path: %w", err)
+		}
+		if err := fn(path); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate root paths: %w", err)
+	}
+	return nil
+}
+
+// Search evaluates a query with indexed SQL.
+func (s *Store) Search(ctx context.Context, query storage.Query) (storage.SearchPage, error) {
+	where, args := buildWhere(query)
+
+	var total int
+	countSQL := `SELECT COUNT(*) FROM file_records` + where
+	if err := s.db.QueryRowContext(ctx, countSQL, args...).Scan(&total); err != nil {
+		return storage.SearchPage{}, fmt.Errorf("count search results: %w", err)
+	}
+
+	column, direction := sortColumn(query.SortField), "ASC"
+	if query.SortDescending {
+		direction = "DESC"
+	}
+
+	pageArgs := append([]any(nil), args...)
+	pageWhere := where
+	if query.After != nil {
+		op := ">"
+		if query.SortDescending {
+			op = "<"
+		}
+		value := cursorValue(query.SortField, *query.After)
+		clause := fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND path %[2]s ?))", column, op)
+		if pageWhere == "" {
+			pageWhere = " WHERE " + clause
+		} else {
+			pageWhere += " AND " + clause
+		}
+		pageArgs = append(pageArgs, value, value, query.After.Path)
+	}
+
+	pageSQL := `SELECT ` + recordColumns + ` FROM file_records` + pageWhere +
+		fmt.Sprintf(" ORDER BY %s %s, path %s", column, direction, direction)
+	if query.Limit > 0 {
+		pageSQL += " LIMIT ?"
+		pageArgs = append(pageArgs, query.Limit)
+		if query.After == nil && query.Offset > 0 {
+			pageSQL += " OFFSET ?"
+			pageArgs = append(pageArgs, query.Offset)
+		}
+	}
+
+	records, err := s.queryRecords(ctx, pageSQL, pageArgs...)
+	if err != nil {
+		return storage.SearchPage{}, err
+	}
+	return storage.SearchPage{Records: records, Total: total}, nil
+}
+
+// NameCandidates returns up to limit paths of records matching filter whose
+// names share trigrams with every word of a fuzzy search, best first. Words
+// shorter than three characters cannot be looked up in the trigram index;
+// when no word is long enough the names must contain their letters in order
+// instead. The sort and paging fields of filter are ignored.
+func (s *Store) NameCandidates(ctx context.Context, words []string, filter storage.Query, limit int) ([]string, error) {
+	filter.Paths, filter.Offset, filter.Limit, filter.After = nil, 0, 0, nil
+
+	var (
+		query string
+		args  []any
+	)
+	if match, _ := nameMatch(words); match != "" {
+		where, filterArgs := buildWhere(filter)
+		query = `
+SELECT path FROM file_records
+JOIN (SELECT rowid AS name_id, rank AS name_rank FROM file_names WHERE file_names MATCH ?) m
+ON m.name_id = file_records.id` + where + `
+ORDER BY m.name_rank
+LIMIT ?`
+		args = append(append([]any{match}, filterArgs...), limit)
+	} else {
+		filter.NameWords = words
+		where, filterArgs := buildWhere(filter)
+		query = `SELECT path FROM file_records` + where + ` LIMIT ?`
+		args = append(filterArgs, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query name candidates: %w", err)
+	}
+	defer rows.Close()
+
+	paths := make([]string, 0)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("scan name candidate: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate name candidates: %w", err)
+	}
+	return paths, nil
+}
+
+// nameMatch builds the trigram index expression for the words of a fuzzy
+// search, which requires a trigram of every word of three or more
+// characters. It returns the shorter words separately; match is empty when
+// there are only short words.
+func nameMatch(words []string) (match string, short []string) {
+	var groups []string
+	for _, word := range words {
+		runes := []rune(word)
+		if len(runes) < 3 {
+			short = append(short, word)
+			continue
+		}
+		grams := make([]string, 0, len(runes)-2)
+		for i := 0; i+3 <= len(runes); i++ {
+			grams = append(grams, `"`+strings.ReplaceAll(string(runes[i:i+3]), `"`, `""`)+`"`)
+		}
+		groups = append(groups, "("+strings.Join(grams, " OR ")+")")
+	}
+	return strings.Join(groups, " AND "), short
+}
+
+// nameClause restricts records to the fuzzy name candidates of words, as
+// NameCandidates finds them.
+func nameClause(words []string) (string, []any) {
+	match, short := nameMatch(words)
+	if match != "" {
+		return "id IN (SELECT rowid FROM file_names WHERE file_names MATCH ?)", []any{match}
+	}
+	clauses := make([]string, len(short))
+	args := make([]any, len(short))
+	for i, word := range short {
+		clauses[i] = `name LIKE ? ESCAPE '\'`
+		args[i] = subsequencePattern(word)
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+// subsequencePattern matches names containing the letters of word in order,
+// the loosest match a fuzzy search scores.
+func subsequencePattern(word string) string {
+	var b strings.Builder
+	b.WriteByte('%')
+	for _, r := range word {
+		b.WriteString(escapeLike(string(r)))
+		b.WriteByte('%')
+	}
+	return b.String()
+}
+
+// SizeBuckets calls fn for every file size shared by more than one record,
+// passing the records of that size.
+func (s *Store) SizeBuckets(ctx context.Context, fn func(size int64, records []storage.Record) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT size FROM file_records WHERE size > 0 GROUP BY size HAVING COUNT(*) > 1`)
+	if err != nil {
+		return fmt.Errorf("query size buckets: %w", err)
+	}
+	var sizes []int64
+	for rows.Next() {
+		var size int64
+		if err := rows.Scan(&size); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan size bucket: %w", err)
+		}
+		sizes = append(sizes, size)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("iterate size buckets: %w", err)
+	}
+	rows.Close()
+
+	for _, size := range sizes {
+		records, err := s.queryRecords(ctx, `SELECT `+recordColumns+` FROM file_records WHERE size = ?`, size)
+		if err != nil {
+			return err
+		}
+		if err := fn(size, records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DuplicateGroups returns groups of records sharing a content hash, ordered by
+// wasted space, along with totals over every group. Only records of the given
+// roots are considered unless roots is empty.
+func (s *Store) DuplicateGroups(ctx context.Context, minSize int64, roots []string, offset, limit int) ([]storage.DuplicateGroup, storage.DuplicateSummary, error) {
+	filter := `content_hash <> '' AND size >= ?`
+	filterArgs := []any{minSize}
+	if len(roots) > 0 {
+		filter += ` AND root_path IN (` + placeholders(len(roots)) + `)`
+		for _, root := range roots {
+			filterArgs = append(filterArgs, root)
+		}
+	}
+	groupsSQL := `
+SELECT content_hash, MAX(size), COUNT(*)
+FROM file_records
+WHERE ` + filter + `
+GROUP BY content_hash
+HAVING COUNT(*) > 1`
+
+	var summary storage.DuplicateSummary
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(n), 0), COALESCE(SUM(sz * (n - 1)), 0) FROM (SELECT MAX(size) AS sz, COUNT(*) AS n FROM file_records WHERE `+filter+` GROUP BY content_hash HAVING COUNT(*) > 1)`, filterArgs...).
+		Scan(&summary.Groups, &summary.Files, &summary.WastedBytes)
+	if err != nil {
+		return nil, storage.DuplicateSummary{}, fmt.Errorf("summarize duplicates: %w", err)
+	}
+
+	query := groupsSQL + ` ORDER BY MAX(size) * (COUNT(*) - 1) DESC, content_hash`
+	args := append([]any(nil), filterArgs...)
+	if limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, storage.DuplicateSummary{}, fmt.Errorf("query duplicates: %w", err)
+	}
+	var groups []storage.DuplicateGroup
+	for rows.Next() {
+		var (
+			group storage.DuplicateGroup
+			count int
+		)
+		if err := rows.Scan(&group.Hash, &group.Size, &count); err != nil {
+			rows.Close()
+			return nil, storage.DuplicateSummary{}, fmt.Errorf("scan duplicate group: %w", err)
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, storage.DuplicateSummary{}, fmt.Errorf("iterate duplicates: %w", err)
+	}
+	rows.Close()
+
+	for i := range groups {
+		groupArgs := append([]any(nil), filterArgs...)
+		groupArgs = append(groupArgs, groups[i].Hash)
+		records, err := s.queryRecords(ctx, `SELECT `+recordColumns+` FROM file_records WHERE `+filter+` AND content_hash = ? ORDER BY path`, groupArgs...)
+		if err != nil {
+			return nil, storage.DuplicateSummary{}, err
+		}
+		groups[i].Records = records
+	}
+	return groups, summary, nil
+}
+
+func buildWhere(query storage.Query) (string, []any) {
+	var (
+		clauses []string
+		args    []any
+	)
+
+	if query.Expr != nil {
+		clause, exprArgs := compileExpr(query.Expr)
+		clauses = append(clauses, clause)
+		args = append(args, exprArgs...)
+	}
+	if len(query.Extensions) > 0 {
+		clauses = append(clauses, "ext IN ("+placeholders(len(query.Extensions))+")")
+		for _, ext := range query.Extensions {
+			args = append(args, ext)
+		}
+	}
+	if len(query.Roots) > 0 {
+		clauses = append(clauses, "root_path IN ("+placeholders(len(query.Roots))+")")
+		for _, root := range query.Roots {
+			args = append(args, root)
+		}
+	}
+	if query.Paths != nil {
+		if len(query.Paths) == 0 {
+			clauses = append(clauses, "0")
+		} else {
+			clauses = append(clauses, "path IN ("+placeholders(len(query.Paths))+")")
+			for _, path := range query.Paths {
+				args = append(args, path)
+			}
+		}
+	}
+	if len(query.NameWords) > 0 {
+		clause, nameArgs := nameClause(query.NameWords)
+		clauses = append(clauses, clause)
+		args = append(args, nameArgs...)
+	}
+	if query.Dir != "" {
+		clauses = append(clauses, "dir = ?")
+		args = append(args, query.Dir)
+	}
+	if query.MinSize > 0 {
+		clauses = append(clauses, "size >= ?")
+		args = append(args, query.MinSize)
+	}
+	if query.MaxSize > 0 {
+		clauses = append(clauses, "size <= ?")
+		args = append(args, query.MaxSize)
+	}
+	if !query.ModifiedAfter.IsZero() {
+		clauses = append(clauses, "mod_time >= ?")
+		args = append(args, query.ModifiedAfter.UnixNano())
+	}
+	if !query.ModifiedBefore.IsZero() {
+		clauses = append(clauses, "mod_time <= ?")
+		args = append(args, query.ModifiedBefore.UnixNano())
+	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
+// likePattern converts a * and ? wildcard pattern into a LIKE expression
+// that matches the whole value.
+func likePattern(pattern string) string {
+	escaped := escapeLike(pattern)
+	escaped = strings.ReplaceAll(escaped, "*", "%")
+	return strings.ReplaceAll(escaped, "?", "_")
+}
+
+// escapeLike escapes the LIKE wildcards of a literal for ESCAPE '\'.
+func escapeLike(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(value)
+}
+
+func sortColumn(field string) string {
+	switch strings.ToLower(field) {
+	case "size":
+		return "size"
+	case "modified", "time":
+		return "mod_time"
+	case "path":
+		return "path"
+	default:
+		return "name COLLATE NOCASE"
+	}
+}
+
+func cursorValue(field string, cursor storage.Cursor) any {
+	switch strings.ToLower(field) {
+	case "size":
+		return cursor.Size
+	case "modified", "time":
+		return cursor.ModTime.UnixNano()
+	case "path":
+		return cursor.Path
+	default:
+		return cursor.Name
+	}
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// prefixRange returns the half-open range of paths nested beneath dir, which
+// lets SQLite use the primary key index instead of a LIKE scan.
+func prefixRange(dir string) (string, string) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	upper := prefix[:len(prefix)-1] + string(rune('/'+1))
+	return prefix, upper
+}
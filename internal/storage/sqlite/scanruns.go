@@ -0,0 +1,131 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"seekfile/internal/storage"
+)
+
+// AppendScanRun records a scan run. Root, path and error lists, as well as
+// the walk errors and their counts by kind, are stored as JSON.
+func (s *Store) AppendScanRun(ctx context.Context, run storage.ScanRun) error {
+	walkErrors, walkErrorKinds := run.WalkErrors, run.WalkErrorKinds
+	if walkErrors == nil {
+		walkErrors = []storage.WalkError{}
+	}
+	if walkErrorKinds == nil {
+		walkErrorKinds = map[string]int64{}
+	}
+
+	lists := make([]string, 0, 5)
+	for _, list := range []any{nonNil(run.Roots), nonNil(run.Paths), nonNil(run.Errors), walkErrorKinds, walkErrors} {
+		encoded, err := json.Marshal(list)
+		if err != nil {
+			return fmt.Errorf("append scan run: %w", err)
+		}
+		lists = append(lists, string(encoded))
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO scan_runs(id, mode, state, roots, paths, started_at, finished_at, duration,
+        processed, added, modified, moved, deleted, bytes, errors,
+        walk_error_count, walk_error_kinds, walk_errors)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO NOTHING
+`, run.ID, run.Mode, run.State, lists[0], lists[1], run.StartedAt.UnixNano(), run.FinishedAt.UnixNano(), int64(run.Duration),
+		run.Processed, run.Added, run.Modified, run.Moved, run.Deleted, run.Bytes, lists[2],
+		run.WalkErrorCount, lists[3], lists[4])
+	if err != nil {
+		return fmt.Errorf("append scan run: %w", err)
+	}
+	return nil
+}
+
+// ScanRuns returns a page of scan runs in descending ID order.
+func (s *Store) ScanRuns(ctx context.Context, query storage.ScanRunQuery) ([]storage.ScanRun, error) {
+	sqlQuery := `SELECT id, mode, state, roots, paths, started_at, finished_at, duration,
+        processed, added, modified, moved, deleted, bytes, errors,
+        walk_error_count, walk_error_kinds, walk_errors FROM scan_runs WHERE 1 = 1`
+	var args []any
+	if query.BeforeID > 0 {
+		sqlQuery += ` AND id < ?`
+		args = append(args, query.BeforeID)
+	}
+	if query.Root != "" {
+		sqlQuery += ` AND EXISTS (SELECT 1 FROM json_each(scan_runs.roots) WHERE value = ?)`
+		args = append(args, query.Root)
+	}
+	if query.Roots != nil {
+		sqlQuery += ` AND NOT EXISTS (SELECT 1 FROM json_each(scan_runs.roots) WHERE value NOT IN (` + placeholders(len(query.Roots)) + `))`
+		for _, root := range query.Roots {
+			args = append(args, root)
+		}
+	}
+	sqlQuery += ` ORDER BY id DESC`
+	if query.Limit > 0 {
+		sqlQuery += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query scan runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := make([]storage.ScanRun, 0)
+	for rows.Next() {
+		var (
+			run                   storage.ScanRun
+			roots, paths, errs    string
+			walkKinds, walkErrs   string
+			started, finished, ns int64
+		)
+		if err := rows.Scan(&run.ID, &run.Mode, &run.State, &roots, &paths, &started, &finished, &ns,
+			&run.Processed, &run.Added, &run.Modified, &run.Moved, &run.Deleted, &run.Bytes, &errs,
+			&run.WalkErrorCount, &walkKinds, &walkErrs); err != nil {
+			return nil, fmt.Errorf("scan scan run: %w", err)
+		}
+		for _, list := range []struct {
+			encoded string
+			target  any
+		}{{roots, &run.Roots}, {paths, &run.Paths}, {errs, &run.Errors}, {walkKinds, &run.WalkErrorKinds}, {walkErrs, &run.WalkErrors}} {
+			if err := json.Unmarshal([]byte(list.encoded), list.target); err != nil {
+				return nil, fmt.Errorf("decode scan run %d: %w", run.ID, err)
+			}
+		}
+		run.StartedAt = time.Unix(0, started)
+		run.FinishedAt = time.Unix(0, finished)
+		run.Duration = time.Duration(ns)
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate scan runs: %w", err)
+	}
+	return runs, nil
+}
+
+// nonNil returns list, or an empty list when it is nil, so that it encodes
+// as a JSON array.
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// PruneScanRuns deletes all but the most recent keep scan runs and returns
+// the number of deleted runs.
+func (s *Store) PruneScanRuns(ctx context.Context, keep int) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+DELETE FROM scan_runs WHERE id <= (SELECT id FROM scan_runs ORDER BY id DESC LIMIT 1 OFFSET ?)
+`, keep)
+	if err != nil {
+		return 0, fmt.Errorf("prune scan runs: %w", err)
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
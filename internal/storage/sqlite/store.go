@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -31,25 +32,32 @@ func Open(path string) (*Store, error) {
 		return nil, fmt.Errorf("create database directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite", path)
-	if err != nil {
-		return nil, fmt.Errorf("open sqlite database: %w", err)
+	// Pragmas are passed in the DSN so that every pooled connection gets
+	// them; the busy timeout lets concurrent scan workers wait for the write
+	// lock instead of failing. Transactions take the write lock when they
+	// begin, since a transaction that reads before writing cannot wait for a
+	// concurrent scan's commit and fails with SQLITE_BUSY instead.
+	pragmas := url.Values{"_txlock": {"immediate"}}
+	for _, pragma := range []string{
+		"journal_mode(WAL)",
+		"synchronous(NORMAL)",
+		"foreign_keys(ON)",
+		"busy_timeout(10000)",
+	} {
+		pragmas.Add("_pragma", pragma)
 	}
 
-	pragmas := []string{
-		"PRAGMA journal_mode=WAL;",
-		"PRAGMA synchronous=NORMAL;",
-		"PRAGMA foreign_keys=ON;",
+	db, err := sql.Open("sqlite", path+"?"+pragmas.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite database: %w", err)
 	}
-	for _, pragma := range pragmas {
-		if _, execErr := db.Exec(pragma); execErr != nil {
-			db.Close()
-			return nil, fmt.Errorf("apply pragma %q: %w", pragma, execErr)
-		}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open sqlite database: %w", err)
 	}
 
 	store := &Store{db: db}
-	if err := store.initSchema(); err != nil {
+	if err := store.migrate(context.Background(), path); err != nil {
 		db.Close()
 		return nil, err
 	}
@@ -65,34 +73,9 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
-func (s *Store) initSchema() error {
-	const schema = `
-CREATE TABLE IF NOT EXISTS file_records (
-        path TEXT PRIMARY KEY,
-        name TEXT NOT NULL,
-        size INTEGER NOT NULL,
-        mod_time INTEGER NOT NULL,
-        root_path TEXT NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS scan_state (
-        root_path TEXT PRIMARY KEY,
-        last_full_scan INTEGER NOT NULL DEFAULT 0,
-        last_incremental_scan INTEGER NOT NULL DEFAULT 0
-);
-
-CREATE INDEX IF NOT EXISTS idx_file_records_root ON file_records(root_path);
-`
-
-	if _, err := s.db.Exec(schema); err != nil {
-		return fmt.Errorf("initialize schema: %w", err)
-	}
-	return nil
-}
-
 // LoadAll retrieves every persisted record.
 func (s *Store) LoadAll(ctx context.Context) ([]storage.Record, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT path, name, size, mod_time, root_path FROM file_records`)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+recordColumns+` FROM file_records`)
 	if err != nil {
 		return nil, fmt.Errorf("query records: %w", err)
 	}
@@ -100,23 +83,9 @@ func (s *Store) LoadAll(ctx context.Context) ([]storage.Record, error) {
 
 	var records []storage.Record
 	for rows.Next() {
-		var (
-			path    string
-			name    string
-			size    int64
-			modTime int64
-			root    string
-		)
-		if scanErr := rows.Scan(&path, &name, &size, &modTime, &root); scanErr != nil {
-			return nil, fmt.Errorf("scan record: %w", scanErr)
-		}
-
-		record := storage.Record{
-			Path:     path,
-			Name:     name,
-			Size:     size,
-			ModTime:  time.Unix(0, modTime),
-			RootPath: root,
+		record, scanErr := scanRecord(rows)
+		if scanErr != nil {
+			return nil, scanErr
 		}
 		records = append(records, record)
 	}
@@ -128,31 +97,224 @@ func (s *Store) LoadAll(ctx context.Context) ([]storage.Record, error) {
 	return records, nil
 }
 
-// Upsert inserts or updates a record.
-func (s *Store) Upsert(ctx context.Context, record storage.Record) error {
-	_, err := s.db.ExecContext(ctx, `
-INSERT INTO file_records(path, name, size, mod_time, root_path)
-VALUES(?, ?, ?, ?, ?)
+const (
+	upsertRecordSQL = `
+INSERT INTO file_records(path, name, size, mod_time, root_path, partial_hash, content_hash, content_indexed, ext, device, inode, dir)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 ON CONFLICT(path) DO UPDATE SET
         name=excluded.name,
+        ext=excluded.ext,
         size=excluded.size,
         mod_time=excluded.mod_time,
-        root_path=excluded.root_path
-`, record.Path, record.Name, record.Size, record.ModTime.UnixNano(), record.RootPath)
+        root_path=excluded.root_path,
+        partial_hash=excluded.partial_hash,
+        content_hash=excluded.content_hash,
+        content_indexed=excluded.content_indexed,
+        device=excluded.device,
+        inode=excluded.inode,
+        dir=excluded.dir
+`
+	moveRecordSQL = `
+UPDATE file_records SET
+        path=?, name=?, size=?, mod_time=?, root_path=?, partial_hash=?, content_hash=?, content_indexed=?, ext=?, device=?, inode=?, dir=?
+WHERE path = ?
+`
+	deleteRecordSQL      = `DELETE FROM file_records WHERE path = ?`
+	deleteContentBodySQL = `DELETE FROM file_contents WHERE rowid IN (SELECT id FROM content_docs WHERE path = ?)`
+	deleteContentDocSQL  = `DELETE FROM content_docs WHERE path = ?`
+	upsertScanStateSQL   = `
+INSERT INTO scan_state(root_path, last_full_scan, last_incremental_scan)
+VALUES(?, ?, ?)
+ON CONFLICT(root_path) DO UPDATE SET
+        last_full_scan=excluded.last_full_scan,
+        last_incremental_scan=excluded.last_incremental_scan
+`
+)
+
+func recordArgs(record storage.Record) []any {
+	return []any{record.Path, record.Name, record.Size, record.ModTime.UnixNano(), record.RootPath,
+		record.PartialHash, record.ContentHash, record.ContentIndexed, storage.Extension(record.Name),
+		int64(record.Device), int64(record.Inode), filepath.Dir(record.Path)}
+}
+
+// Upsert inserts or updates a record.
+func (s *Store) Upsert(ctx context.Context, record storage.Record) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("upsert record %s: %w", record.Path, err)
 	}
+	defer tx.Rollback()
+
+	dirs := make(dirDeltas)
+	if err := dirs.removeStored(tx.QueryRowContext(ctx, previousRecordSQL, record.Path), record.Path); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, upsertRecordSQL, recordArgs(record)...); err != nil {
+		return fmt.Errorf("upsert record %s: %w", record.Path, err)
+	}
+	dirs.insert(record.Path, record.RootPath, record.Size, record.ModTime.UnixNano())
+	if err := dirs.apply(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("upsert record %s: %w", record.Path, err)
+	}
 	return nil
 }
 
-// Delete removes a record by its path.
+// Delete removes a record, along with any indexed content, by its path.
 func (s *Store) Delete(ctx context.Context, path string) error {
-	if _, err := s.db.ExecContext(ctx, `DELETE FROM file_records WHERE path = ?`, path); err != nil {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("delete record %s: %w", path, err)
+	}
+	defer tx.Rollback()
+
+	dirs := make(dirDeltas)
+	if err := dirs.removeStored(tx.QueryRowContext(ctx, previousRecordSQL, path), path); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, deleteRecordSQL, path); err != nil {
+		return fmt.Errorf("delete record %s: %w", path, err)
+	}
+	if err := deleteContent(ctx, tx, path); err != nil {
+		return err
+	}
+	if err := dirs.apply(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("delete record %s: %w", path, err)
 	}
 	return nil
 }
 
+// Move renames the record at from to the path of record and updates its
+// metadata in place. A record already stored at the new path is replaced.
+func (s *Store) Move(ctx context.Context, from string, record storage.Record) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("move record %s: %w", from, err)
+	}
+	defer tx.Rollback()
+
+	dirs := make(dirDeltas)
+	if err := moveRecord(ctx, tx, dirs, from, record); err != nil {
+		return err
+	}
+	if err := dirs.apply(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("move record %s: %w", from, err)
+	}
+	return nil
+}
+
+// moveRecord renames a record within tx and collects the directory changes
+// in dirs. Indexed content follows the record when it is still current;
+// otherwise it is dropped so that it is extracted again.
+func moveRecord(ctx context.Context, tx *sql.Tx, dirs dirDeltas, from string, record storage.Record) error {
+	replaced := []string{from}
+	if record.Path != from {
+		replaced = append(replaced, record.Path)
+	}
+	for _, path := range replaced {
+		if err := dirs.removeStored(tx.QueryRowContext(ctx, previousRecordSQL, path), path); err != nil {
+			return err
+		}
+	}
+	dirs.insert(record.Path, record.RootPath, record.Size, record.ModTime.UnixNano())
+
+	if _, err := tx.ExecContext(ctx, deleteRecordSQL, record.Path); err != nil {
+		return fmt.Errorf("move record %s: %w", from, err)
+	}
+	if err := deleteContent(ctx, tx, record.Path); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, moveRecordSQL, append(recordArgs(record), from)...); err != nil {
+		return fmt.Errorf("move record %s: %w", from, err)
+	}
+
+	if !record.ContentIndexed {
+		return deleteContent(ctx, tx, from)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE content_docs SET path = ? WHERE path = ?`, record.Path, from); err != nil {
+		return fmt.Errorf("move content %s: %w", from, err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE file_contents SET name = ? WHERE rowid IN (SELECT id FROM content_docs WHERE path = ?)`,
+		record.Name, record.Path); err != nil {
+		return fmt.Errorf("move content %s: %w", from, err)
+	}
+	return nil
+}
+
+// upsertContent stores the extracted text of a file in the full-text index.
+func upsertContent(ctx context.Context, tx *sql.Tx, path, name, body string) error {
+	if _, err := tx.ExecContext(ctx, `INSERT INTO content_docs(path) VALUES(?) ON CONFLICT(path) DO NOTHING`, path); err != nil {
+		return fmt.Errorf("index content %s: %w", path, err)
+	}
+
+	var id int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM content_docs WHERE path = ?`, path).Scan(&id); err != nil {
+		return fmt.Errorf("index content %s: %w", path, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM file_contents WHERE rowid = ?`, id); err != nil {
+		return fmt.Errorf("index content %s: %w", path, err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO file_contents(rowid, name, body) VALUES(?, ?, ?)`, id, name, body); err != nil {
+		return fmt.Errorf("index content %s: %w", path, err)
+	}
+	return nil
+}
+
+func deleteContent(ctx context.Context, tx *sql.Tx, path string) error {
+	if _, err := tx.ExecContext(ctx, deleteContentBodySQL, path); err != nil {
+		return fmt.Errorf("delete content %s: %w", path, err)
+	}
+	if _, err := tx.ExecContext(ctx, deleteContentDocSQL, path); err != nil {
+		return fmt.Errorf("delete content %s: %w", path, err)
+	}
+	return nil
+}
+
+// SearchContent runs an FTS5 MATCH expression against the indexed contents
+// of the records matching filter and returns up to limit hits ordered by
+// relevance.
+func (s *Store) SearchContent(ctx context.Context, match string, filter storage.Query, limit int) ([]storage.ContentHit, error) {
+	where, filterArgs := buildWhere(filter)
+	restrict := ""
+	if where != "" {
+		restrict = "AND d.path IN (SELECT path FROM file_records" + where + ")"
+	}
+	args := append([]any{storage.SnippetStart, storage.SnippetEnd, match}, filterArgs...)
+	rows, err := s.db.QueryContext(ctx, `
+SELECT d.path, snippet(file_contents, 1, ?, ?, '…', 16), bm25(file_contents, 2.0, 1.0)
+FROM file_contents
+JOIN content_docs d ON d.id = file_contents.rowid
+WHERE file_contents MATCH ? `+restrict+`
+ORDER BY bm25(file_contents, 2.0, 1.0)
+LIMIT ?
+`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("search content: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []storage.ContentHit
+	for rows.Next() {
+		var hit storage.ContentHit
+		if err := rows.Scan(&hit.Path, &hit.Snippet, &hit.Rank); err != nil {
+			return nil, fmt.Errorf("scan content hit: %w", err)
+		}
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate content hits: %w", err)
+	}
+	return hits, nil
+}
+
 // ScanState retrieves the last known scan state for a root path.
 func (s *Store) ScanState(ctx context.Context, root string) (storage.ScanState, error) {
 	var (
@@ -179,13 +341,7 @@ SELECT last_full_scan, last_incremental_scan FROM scan_state WHERE root_path = ?
 
 // UpdateScanState writes the scan timestamps for a root path.
 func (s *Store) UpdateScanState(ctx context.Context, state storage.ScanState) error {
-	_, err := s.db.ExecContext(ctx, `
-INSERT INTO scan_state(root_path, last_full_scan, last_incremental_scan)
-VALUES(?, ?, ?)
-ON CONFLICT(root_path) DO UPDATE SET
-        last_full_scan=excluded.last_full_scan,
-        last_incremental_scan=excluded.last_incremental_scan
-`, state.RootPath, state.LastFullScan.UnixNano(), state.LastIncrementalScan.UnixNano())
+	_, err := s.db.ExecContext(ctx, upsertScanStateSQL, state.RootPath, state.LastFullScan.UnixNano(), state.LastIncrementalScan.UnixNano())
 	if err != nil {
 		return fmt.Errorf("update scan state %s: %w", state.RootPath, err)
 	}